@@ -0,0 +1,165 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Asynchronous requests of a client that run at once, the others wait for a slot
+	maxConcurrentRequests = 32
+	// How long request events wait for room in a full queue once the client disconnects
+	requestEventDrainTimeout = 5 * time.Second
+)
+
+// RequestCompletedEvent is emitted when an asynchronous request succeeds
+type RequestCompletedEvent struct {
+	RequestID string `json:"requestId"`
+	Method    string `json:"method"`
+	Result    any    `json:"result,omitempty"` // what the synchronous call returns as data
+}
+
+// RequestFailedEvent is emitted when an asynchronous request fails
+type RequestFailedEvent struct {
+	RequestID string `json:"requestId"`
+	Method    string `json:"method"`
+	Error     string `json:"error"`
+	Code      string `json:"code,omitempty"`
+	Details   any    `json:"details,omitempty"`
+}
+
+// CompleteRequest emits the result of an asynchronous request. Unlike other events,
+// it waits for room in a full event queue instead of being dropped.
+func (c *Client) CompleteRequest(evt *RequestCompletedEvent) {
+	c.emitEventWait(EventTypeRequestCompleted, evt)
+}
+
+// FailRequest emits the error of an asynchronous request, see CompleteRequest
+func (c *Client) FailRequest(evt *RequestFailedEvent) {
+	c.emitEventWait(EventTypeRequestFailed, evt)
+}
+
+// RunRequest runs an asynchronous request on a goroutine. fn is called with nil once one of
+// the client's request slots is free, or with the context error if the client disconnects first.
+// Disconnect waits for running requests so their completion events are still queued.
+func (c *Client) RunRequest(fn func(err error)) error {
+	c.requestsMu.Lock()
+	if err := c.ctx.Err(); err != nil {
+		c.requestsMu.Unlock()
+		return err
+	}
+	c.requests.Add(1)
+	c.requestsMu.Unlock()
+
+	go func() {
+		defer c.requests.Done()
+		select {
+		case c.requestSlots <- struct{}{}:
+			defer func() { <-c.requestSlots }()
+			fn(nil)
+		case <-c.ctx.Done():
+			fn(c.ctx.Err())
+		}
+	}()
+	return nil
+}
+
+// sendWait queues v on ch, waiting for room while the client is connected and for up to
+// requestEventDrainTimeout after it disconnects. Returns false if v was dropped.
+func sendWait[T any](c *Client, ch chan<- T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	case <-c.ctx.Done():
+	}
+	timer := time.NewTimer(requestEventDrainTimeout)
+	defer timer.Stop()
+	select {
+	case ch <- v:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// callRegistry tracks the contexts of running calls so they can be cancelled by request ID
+type callRegistry struct {
+	mu    sync.Mutex
+	calls map[string]*registeredCall // key: request ID
+}
+
+type registeredCall struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Set by ReserveCall until BeginCall takes the call over
+	reserved bool
+}
+
+func newCallRegistry() *callRegistry {
+	return &callRegistry{calls: make(map[string]*registeredCall)}
+}
+
+// registerCall adds a call under requestID. Without reserve, a reserved call is taken over.
+func (m *ClientManager) registerCall(c *Client, requestID string, timeout time.Duration, reserve bool) (context.Context, context.CancelFunc, error) {
+	m.calls.mu.Lock()
+	defer m.calls.mu.Unlock()
+	call, ok := m.calls.calls[requestID]
+	if ok && (reserve || !call.reserved) {
+		return nil, nil, fmt.Errorf("request %s is already running", requestID)
+	}
+	if !ok {
+		call = &registeredCall{}
+		if timeout > 0 {
+			call.ctx, call.cancel = context.WithTimeout(c.ctx, timeout)
+		} else {
+			call.ctx, call.cancel = context.WithCancel(c.ctx)
+		}
+		m.calls.calls[requestID] = call
+	}
+	call.reserved = reserve
+	return call.ctx, func() {
+		call.cancel()
+		m.calls.mu.Lock()
+		if m.calls.calls[requestID] == call {
+			delete(m.calls.calls, requestID)
+		}
+		m.calls.mu.Unlock()
+	}, nil
+}
+
+// BeginCall derives the context of one call from the client context. A zero timeout
+// means no deadline, an empty requestID means the call can't be cancelled with Cancel.
+// A call reserved with ReserveCall is taken over, keeping its context.
+// The returned function must be called when the call is done.
+func (m *ClientManager) BeginCall(c *Client, requestID string, timeout time.Duration) (context.Context, context.CancelFunc, error) {
+	if requestID == "" {
+		if timeout > 0 {
+			ctx, cancel := context.WithTimeout(c.ctx, timeout)
+			return ctx, cancel, nil
+		}
+		ctx, cancel := context.WithCancel(c.ctx)
+		return ctx, cancel, nil
+	}
+	return m.registerCall(c, requestID, timeout, false)
+}
+
+// ReserveCall registers a request ID before the call starts, so a duplicate ID is rejected
+// right away and Cancel works while the call waits to run. The returned function must be
+// called once the call is done, or if it never starts.
+func (m *ClientManager) ReserveCall(c *Client, requestID string, timeout time.Duration) (context.CancelFunc, error) {
+	_, release, err := m.registerCall(c, requestID, timeout, true)
+	return release, err
+}
+
+// Cancel cancels a running call. Returns false if no call has the request ID.
+func (m *ClientManager) Cancel(requestID string) bool {
+	m.calls.mu.Lock()
+	call, ok := m.calls.calls[requestID]
+	m.calls.mu.Unlock()
+	if ok {
+		call.cancel()
+	}
+	return ok
+}
@@ -0,0 +1,164 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestCallClient(t *testing.T) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &Client{ctx: ctx, cancel: cancel}
+}
+
+func TestBeginCallDuplicateID(t *testing.T) {
+	m := NewClientManager(nil)
+	c := newTestCallClient(t)
+
+	_, done, err := m.BeginCall(c, "req-1", 0)
+	if err != nil {
+		t.Fatalf("BeginCall() error = %v", err)
+	}
+	if _, _, err = m.BeginCall(c, "req-1", 0); err == nil {
+		t.Fatal("BeginCall() with a running request ID succeeded, want error")
+	}
+	// Calls without a request ID are never tracked
+	for range 2 {
+		_, anonDone, err := m.BeginCall(c, "", 0)
+		if err != nil {
+			t.Fatalf("BeginCall() without request ID error = %v", err)
+		}
+		anonDone()
+	}
+
+	done()
+	_, done, err = m.BeginCall(c, "req-1", 0)
+	if err != nil {
+		t.Fatalf("BeginCall() after the first call finished error = %v", err)
+	}
+	done()
+}
+
+func TestReserveCall(t *testing.T) {
+	m := NewClientManager(nil)
+	c := newTestCallClient(t)
+
+	release, err := m.ReserveCall(c, "req", 0)
+	if err != nil {
+		t.Fatalf("ReserveCall() error = %v", err)
+	}
+	if _, err = m.ReserveCall(c, "req", 0); err == nil {
+		t.Error("ReserveCall() with a reserved request ID succeeded, want error")
+	}
+	// Cancelling before the call begins cancels the context it takes over
+	if !m.Cancel("req") {
+		t.Error("Cancel() didn't find the reserved request")
+	}
+	ctx, done, err := m.BeginCall(c, "req", 0)
+	if err != nil {
+		t.Fatalf("BeginCall() taking over the reservation error = %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Error("taken over context wasn't cancelled")
+	}
+	if _, _, err = m.BeginCall(c, "req", 0); err == nil {
+		t.Error("BeginCall() after the takeover succeeded, want error")
+	}
+	done()
+
+	// A late release doesn't remove a newer call with the same ID
+	_, newDone, err := m.BeginCall(c, "req", 0)
+	if err != nil {
+		t.Fatalf("BeginCall() after done error = %v", err)
+	}
+	release()
+	if !m.Cancel("req") {
+		t.Error("release removed a newer call")
+	}
+	newDone()
+}
+
+func TestBeginCallCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		cancel  func(m *ClientManager, c *Client)
+		wantErr error
+	}{
+		{
+			name:    "cancel by request ID",
+			cancel:  func(m *ClientManager, c *Client) { m.Cancel("req") },
+			wantErr: context.Canceled,
+		},
+		{
+			name:    "client disconnect",
+			cancel:  func(m *ClientManager, c *Client) { c.cancel() },
+			wantErr: context.Canceled,
+		},
+		{
+			name:    "timeout",
+			timeout: time.Millisecond,
+			cancel:  func(m *ClientManager, c *Client) {},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewClientManager(nil)
+			c := newTestCallClient(t)
+			ctx, done, err := m.BeginCall(c, "req", tt.timeout)
+			if err != nil {
+				t.Fatalf("BeginCall() error = %v", err)
+			}
+			tt.cancel(m, c)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+				t.Fatal("call context wasn't cancelled")
+			}
+			if !errors.Is(ctx.Err(), tt.wantErr) {
+				t.Errorf("ctx.Err() = %v, want %v", ctx.Err(), tt.wantErr)
+			}
+			done()
+			if m.Cancel("req") {
+				t.Error("Cancel() found the request after it finished")
+			}
+		})
+	}
+}
+
+func TestRunRequestDisconnect(t *testing.T) {
+	c := newTestCallClient(t)
+	c.eventChan = make(chan *Event, 1)
+	c.requestSlots = make(chan struct{}, 1)
+	c.requestSlots <- struct{}{} // all slots taken, the request waits
+
+	err := c.RunRequest(func(err error) {
+		if err == nil {
+			t.Error("request ran without a free slot")
+			return
+		}
+		c.FailRequest(&RequestFailedEvent{RequestID: "req", Error: err.Error()})
+	})
+	if err != nil {
+		t.Fatalf("RunRequest() error = %v", err)
+	}
+	c.requestsMu.Lock()
+	c.cancel()
+	c.requestsMu.Unlock()
+	c.requests.Wait()
+
+	select {
+	case evt := <-c.eventChan:
+		if evt.Type != EventTypeRequestFailed {
+			t.Errorf("event type = %s, want %s", evt.Type, EventTypeRequestFailed)
+		}
+	default:
+		t.Fatal("requestFailed wasn't queued after disconnect")
+	}
+	if err := c.RunRequest(func(error) { t.Error("request ran after disconnect") }); err == nil {
+		t.Error("RunRequest() after disconnect succeeded, want error")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
@@ -31,6 +32,9 @@ type Client struct {
 	Messagix    *messagix.Client
 	E2EE        *whatsmeow.Client
 	DeviceStore *DeviceStore
+	SQLStore    *SQLDeviceStore
+	Outbox      *Outbox
+	RateLimiter *RateLimiter
 	Logger      zerolog.Logger
 	FBID        int64
 	Platform    types.Platform
@@ -41,20 +45,56 @@ type Client struct {
 	mu                  sync.RWMutex
 	recentUnreactions   map[string]int64 // key: messageId+actorId, value: timestamp
 	recentUnreactionsMu sync.RWMutex
+	reactionsV2         *reactionV2Cache
+	pendingSends        map[string]*pendingSend // key: otid
+	pendingSendsMu      sync.Mutex
+	decryptFailures     map[string]*decryptFailure // key: E2EE message ID
+	decryptFailuresMu   sync.Mutex
+	disappearingTimers  map[string]*disappearingSetting // key: chat JID or thread key
+	disappearingMu      sync.Mutex
+	trustPolicy         TrustPolicy
+	identityStore       *policyIdentityStore
+	igIDs               *igIDMap
+	communities         *communityThreadMap
+	threadFolders       map[int64]string // key: thread key
+	threadFoldersMu     sync.Mutex
+	threadReadStates    map[int64]*threadReadState // key: thread key
+	threadReadStatesMu  sync.Mutex
+	blockedContacts     map[int64]bool // key: contact ID
+	blockedContactsMu   sync.Mutex
+	contacts            *contactDirectory
+	requests            sync.WaitGroup // running asynchronous requests
+	requestsMu          sync.Mutex
+	requestSlots        chan struct{}
+	manager             *ClientManager // nil for clients created without a manager
+	metrics             *Metrics       // nil when metrics are disabled
+	legacyDevicePath    string         // JSON device migrated into SQLStore on first E2EE connect
+	legacyDeviceData    string
 }
 
 // ClientConfig for creating a new client
 type ClientConfig struct {
-	Cookies        map[string]string `json:"cookies"`
-	Platform       string            `json:"platform"` // "facebook", "messenger", "instagram"
-	DevicePath     string            `json:"devicePath"`
-	DeviceData     string            `json:"deviceData,omitempty"`     // JSON string of device data (optional, takes priority over DevicePath)
-	E2EEMemoryOnly bool              `json:"e2eeMemoryOnly,omitempty"` // If true, E2EE state is stored in memory only (no file, no events)
-	LogLevel       string            `json:"logLevel"`
+	Cookies          map[string]string       `json:"cookies"`
+	Platform         string                  `json:"platform"` // "facebook", "messenger", "instagram"
+	DevicePath       string                  `json:"devicePath"`
+	DeviceData       string                  `json:"deviceData,omitempty"`       // JSON string of device data (optional, takes priority over DevicePath)
+	DeviceDBPath     string                  `json:"deviceDbPath,omitempty"`     // SQLite database for E2EE state (optional, takes priority over DevicePath and DeviceData)
+	E2EEMemoryOnly   bool                    `json:"e2eeMemoryOnly,omitempty"`   // If true, E2EE state is stored in memory only (no file, no events)
+	DeviceEncryption *DeviceEncryptionConfig `json:"deviceEncryption,omitempty"` // Encrypts the device file and deviceDataChanged data when set, can't be combined with DeviceDBPath
+	LogLevel         string                  `json:"logLevel"`
+	Outbox           *OutboxConfig           `json:"outbox,omitempty"`      // Enables the persistent outbox when set
+	RateLimit        *RateLimitConfig        `json:"rateLimit,omitempty"`   // Enables client-side rate limiting when set
+	TrustPolicy      string                  `json:"trustPolicy,omitempty"` // "tofu" (default), "always" or "strict"
+	Metrics          bool                    `json:"metrics,omitempty"`     // Enables the metrics registry
 }
 
 // NewClient creates a new messagix client
 func NewClient(cfg *ClientConfig) (*Client, error) {
+	return newClient(cfg, nil)
+}
+
+// newClient creates a client, using the shared resources of the manager if it's set
+func newClient(cfg *ClientConfig, manager *ClientManager) (*Client, error) {
 	// Parse platform
 	var platform types.Platform
 	switch cfg.Platform {
@@ -94,49 +134,92 @@ func NewClient(cfg *ClientConfig) (*Client, error) {
 	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
 
 	// Create messagix client
+	httpSettings := exhttp.ClientSettings{}
+	if manager != nil {
+		httpSettings = manager.httpSettings()
+	}
 	msgClient := messagix.NewClient(cks, logger, &messagix.Config{
-		ClientSettings: exhttp.ClientSettings{},
+		ClientSettings:  httpSettings,
+		MayConnectToDGW: platform.IsInstagram(), // DGW carries Instagram typing indicators
 	})
 
+	trustPolicy, err := ParseTrustPolicy(cfg.TrustPolicy)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create device store
+	if cfg.DeviceEncryption != nil && cfg.DeviceDBPath != "" && !cfg.E2EEMemoryOnly {
+		return nil, ErrDeviceEncryptionWithDB
+	}
+	deviceCipher, err := NewDeviceCipher(cfg.DeviceEncryption)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device encryption config: %w", err)
+	}
 	var deviceStore *DeviceStore
-	var err error
-	if cfg.E2EEMemoryOnly {
+	var sqlStore *SQLDeviceStore
+	if cfg.DeviceDBPath != "" && !cfg.E2EEMemoryOnly {
+		// SQLite mode - the device is loaded once the FBID is known (in ConnectE2EE)
+		sqlStore, err = NewSQLDeviceStore(context.Background(), cfg.DeviceDBPath, logger)
+	} else if cfg.E2EEMemoryOnly {
 		// Memory only mode - no persistence
 		deviceStore, err = NewDeviceStoreMemoryOnly()
 	} else if cfg.DeviceData != "" {
 		// Use provided device data (no file I/O)
-		deviceStore, err = NewDeviceStoreFromData(cfg.DeviceData)
+		deviceStore, err = NewDeviceStoreFromData(cfg.DeviceData, deviceCipher)
 	} else {
 		// Use file path
 		devicePath := cfg.DevicePath
 		if devicePath == "" {
 			devicePath = "e2ee_device.json"
 		}
-		deviceStore, err = NewDeviceStore(devicePath)
+		deviceStore, err = NewDeviceStore(devicePath, deviceCipher)
 	}
 	if err != nil {
 		return nil, err
 	}
 
 	// Set device on client
-	msgClient.SetDevice(deviceStore.Device)
+	if deviceStore != nil {
+		msgClient.SetDevice(deviceStore.Device)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	client := &Client{
-		Messagix:          msgClient,
-		DeviceStore:       deviceStore,
-		Logger:            logger,
-		Platform:          platform,
-		eventChan:         make(chan *Event, 100),
-		ctx:               ctx,
-		cancel:            cancel,
-		recentUnreactions: make(map[string]int64),
+		Messagix:           msgClient,
+		DeviceStore:        deviceStore,
+		SQLStore:           sqlStore,
+		Logger:             logger,
+		Platform:           platform,
+		eventChan:          make(chan *Event, 100),
+		ctx:                ctx,
+		cancel:             cancel,
+		recentUnreactions:  make(map[string]int64),
+		reactionsV2:        newReactionV2Cache(reactionsV2CacheLimit),
+		pendingSends:       make(map[string]*pendingSend),
+		decryptFailures:    make(map[string]*decryptFailure),
+		disappearingTimers: make(map[string]*disappearingSetting),
+		trustPolicy:        trustPolicy,
+		igIDs:              newIGIDMap(),
+		communities:        newCommunityThreadMap(),
+		threadFolders:      make(map[int64]string),
+		threadReadStates:   make(map[int64]*threadReadState),
+		blockedContacts:    make(map[int64]bool),
+		contacts:           newContactDirectory(sqlStore),
+		requestSlots:       make(chan struct{}, maxConcurrentRequests),
+		manager:            manager,
+	}
+	if sqlStore != nil {
+		if err := client.contacts.load(ctx); err != nil {
+			logger.Warn().Err(err).Msg("Failed to load saved contacts")
+		}
+		client.legacyDevicePath = cfg.DevicePath
+		client.legacyDeviceData = cfg.DeviceData
 	}
 
 	// Set callback for device data changes (only when using deviceData mode)
-	if cfg.DeviceData != "" {
+	if deviceStore != nil && cfg.DeviceData != "" {
 		deviceStore.onDataChanged = func(data string) {
 			client.emitEvent(EventDeviceDataChanged, map[string]interface{}{
 				"deviceData": data,
@@ -144,16 +227,54 @@ func NewClient(cfg *ClientConfig) (*Client, error) {
 		}
 	}
 
+	// Re-encrypt data that was stored in clear text or with the old key
+	if deviceStore != nil && deviceStore.needsRewrite {
+		if err := deviceStore.Save(); err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to re-encrypt device data: %w", err)
+		}
+		deviceStore.needsRewrite = false
+	}
+
+	if cfg.RateLimit != nil {
+		client.RateLimiter, err = NewRateLimiter(cfg.RateLimit)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	if cfg.Metrics {
+		client.metrics = NewMetrics()
+	}
+
+	// Set up outbox (optional)
+	if cfg.Outbox != nil {
+		client.Outbox, err = newOutbox(client, cfg.Outbox)
+		if err != nil {
+			cancel()
+			if sqlStore != nil {
+				sqlStore.Close()
+			}
+			return nil, err
+		}
+		go client.Outbox.run()
+	}
+	go client.pruneDecryptFailures()
+	if sqlStore != nil {
+		go client.saveContacts()
+	}
+
 	// Set event handler
 	msgClient.SetEventHandler(client.handleEvent)
 
 	return client, nil
 }
 
-// Connect connects to Messenger
-func (c *Client) Connect() (*UserInfo, *InitialData, error) {
+// Connect connects to Messenger. ctx only bounds the initial requests,
+// the socket stays connected until Disconnect.
+func (c *Client) Connect(ctx context.Context) (*UserInfo, *InitialData, error) {
 	// Load messages page
-	currentUser, initialTable, err := c.Messagix.LoadMessagesPage(c.ctx)
+	currentUser, initialTable, err := c.Messagix.LoadMessagesPage(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -164,6 +285,17 @@ func (c *Client) Connect() (*UserInfo, *InitialData, error) {
 		Username: currentUser.GetUsername(),
 		ID:       currentUser.GetFBID(),
 	}
+	if c.Platform.IsInstagram() {
+		// The current user object has the IG account, LightSpeed uses the linked FBID
+		if userInfo.ID, err = c.Messagix.Instagram.ExtractFBID(currentUser, initialTable); err != nil {
+			return nil, nil, err
+		}
+		if igUser, ok := currentUser.(interface{ GetUserId() string }); ok {
+			userInfo.IGID = igUser.GetUserId()
+		}
+		c.recordIGIDs(initialTable)
+		c.igIDs.putUser(userInfo.IGID, userInfo.ID)
+	}
 	c.FBID = userInfo.ID
 
 	// Connect socket
@@ -174,8 +306,14 @@ func (c *Client) Connect() (*UserInfo, *InitialData, error) {
 	// Extract initial data
 	initialData := &InitialData{}
 	if initialTable != nil {
+		c.recordCommunityThreads(initialTable)
+		c.handleThreadOrganisation(initialTable)
+		c.recordContacts(initialTable)
 		for _, t := range initialTable.LSDeleteThenInsertThread {
-			initialData.Threads = append(initialData.Threads, convertThread(t))
+			thread := convertThread(t)
+			thread.IGThreadID = c.igIDs.threadIGID(t.ThreadKey)
+			thread.ParentThreadID, thread.CommunityID = c.communities.lookup(t.ThreadKey)
+			initialData.Threads = append(initialData.Threads, thread)
 		}
 		for _, m := range initialTable.LSUpsertMessage {
 			initialData.Messages = append(initialData.Messages, convertMessage(m))
@@ -186,23 +324,49 @@ func (c *Client) Connect() (*UserInfo, *InitialData, error) {
 }
 
 // ConnectE2EE sets up and connects the E2EE client
-func (c *Client) ConnectE2EE() error {
+func (c *Client) ConnectE2EE(ctx context.Context) error {
 	if c.E2EE != nil && c.E2EE.IsConnected() {
 		return nil
 	}
 
+	// Load the device from the database now that the FBID is known
+	if c.SQLStore != nil && c.SQLStore.Device == nil {
+		device, err := c.SQLStore.LoadDevice(ctx, c.FBID, c.legacyDevicePath, c.legacyDeviceData)
+		if err != nil {
+			return err
+		}
+		c.Messagix.SetDevice(device)
+	}
+	if c.SQLStore != nil {
+		c.setupIdentityPolicy(c.SQLStore.Device, c.SQLStore)
+	} else {
+		c.setupIdentityPolicy(c.DeviceStore.Device, c.DeviceStore)
+	}
+
 	// Prepare E2EE client
 	e2eeClient, err := c.Messagix.PrepareE2EEClient()
 	if err != nil {
 		return err
 	}
 	c.E2EE = e2eeClient
+	// Changed identities are only reset automatically when the policy allows it
+	c.E2EE.AutoTrustIdentity = c.trustPolicy != TrustPolicyStrict
+	// Lets redelivered messages be handled again instead of failing with an old counter error.
+	// The buffer holds decrypted plaintext, so it's only enabled with the SQL store, where
+	// whatsmeow prunes it. The JSON store would keep it in memory without a bound.
+	c.E2EE.EnableDecryptedEventBuffer = c.SQLStore != nil
 
 	// Register E2EE
-	if err := c.Messagix.RegisterE2EE(c.ctx, c.FBID); err != nil {
+	if err := c.Messagix.RegisterE2EE(ctx, c.FBID); err != nil {
 		return err
 	}
-	c.DeviceStore.Save()
+	if c.SQLStore != nil {
+		if err := c.SQLStore.Save(ctx); err != nil {
+			return fmt.Errorf("failed to save device: %w", err)
+		}
+	} else {
+		c.DeviceStore.Save()
+	}
 
 	// Connect E2EE
 	if err := c.E2EE.Connect(); err != nil {
@@ -217,11 +381,24 @@ func (c *Client) ConnectE2EE() error {
 
 // Disconnect disconnects from Messenger
 func (c *Client) Disconnect() {
+	// RunRequest checks the context under the same lock, so no request starts after this
+	c.requestsMu.Lock()
 	c.cancel()
+	c.requestsMu.Unlock()
 	if c.E2EE != nil && c.E2EE.IsConnected() {
 		c.E2EE.Disconnect()
 	}
 	c.Messagix.Disconnect()
+	c.requests.Wait()
+	if c.Outbox != nil {
+		<-c.Outbox.done
+	}
+	if c.SQLStore != nil {
+		if err := c.contacts.flush(context.Background()); err != nil {
+			c.Logger.Warn().Err(err).Msg("Failed to save contacts")
+		}
+		c.SQLStore.Close()
+	}
 	close(c.eventChan)
 }
 
@@ -250,7 +427,19 @@ type DeviceStore struct {
 	preKeys       map[uint32]*keys.PreKey
 	senderKeys    map[string][]byte
 	nextPreKeyID  uint32
-	onDataChanged func(string) // callback when data changes (for deviceData mode)
+	onDataChanged func(string)  // callback when data changes (for deviceData mode)
+	cipher        *DeviceCipher // encrypts data at rest, nil = plain JSON
+	needsRewrite  bool          // loaded data was plain or used the old key
+
+	// In-memory only, see store.go
+	messageSecrets     map[string]messageSecret
+	messageSecretOrder []string // keys of messageSecrets, oldest first
+}
+
+type messageSecret struct {
+	secret     []byte
+	sender     waTypes.JID
+	insertedAt time.Time
 }
 
 // DeviceJSON for JSON serialization
@@ -273,9 +462,10 @@ type DeviceJSON struct {
 }
 
 // NewDeviceStore creates or loads a device store
-func NewDeviceStore(path string) (*DeviceStore, error) {
+func NewDeviceStore(path string, cipher *DeviceCipher) (*DeviceStore, error) {
 	ds := &DeviceStore{
 		path:         path,
+		cipher:       cipher,
 		identities:   make(map[string][32]byte),
 		sessions:     make(map[string][]byte),
 		preKeys:      make(map[uint32]*keys.PreKey),
@@ -284,6 +474,10 @@ func NewDeviceStore(path string) (*DeviceStore, error) {
 	}
 
 	if data, err := os.ReadFile(path); err == nil {
+		data, ds.needsRewrite, err = openDeviceData(data, cipher)
+		if err != nil {
+			return nil, err
+		}
 		var deviceJSON DeviceJSON
 		if err := json.Unmarshal(data, &deviceJSON); err != nil {
 			return nil, err
@@ -394,7 +588,7 @@ func NewDeviceStore(path string) (*DeviceStore, error) {
 	return ds, nil
 }
 
-// GetDeviceData returns the device data as a JSON string
+// GetDeviceData returns the device data as a JSON string, encrypted if a cipher is set
 func (ds *DeviceStore) GetDeviceData() (string, error) {
 	ds.mu.RLock()
 	defer ds.mu.RUnlock()
@@ -444,9 +638,23 @@ func (ds *DeviceStore) GetDeviceData() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if ds.cipher != nil {
+		data, err = ds.cipher.Seal(data)
+		if err != nil {
+			return "", err
+		}
+	}
 	return string(data), nil
 }
 
+// SetCipher changes the encryption key and rewrites the stored data with it
+func (ds *DeviceStore) SetCipher(cipher *DeviceCipher) error {
+	ds.mu.Lock()
+	ds.cipher = cipher
+	ds.mu.Unlock()
+	return ds.Save()
+}
+
 // Save saves the device store to disk (only if path is set)
 func (ds *DeviceStore) Save() error {
 	data, err := ds.GetDeviceData()
@@ -466,9 +674,10 @@ func (ds *DeviceStore) Save() error {
 }
 
 // NewDeviceStoreFromData creates a device store from JSON data string (no file I/O)
-func NewDeviceStoreFromData(dataStr string) (*DeviceStore, error) {
+func NewDeviceStoreFromData(dataStr string, cipher *DeviceCipher) (*DeviceStore, error) {
 	ds := &DeviceStore{
 		path:         "", // Empty path means no file saving
+		cipher:       cipher,
 		identities:   make(map[string][32]byte),
 		sessions:     make(map[string][]byte),
 		preKeys:      make(map[uint32]*keys.PreKey),
@@ -477,7 +686,12 @@ func NewDeviceStoreFromData(dataStr string) (*DeviceStore, error) {
 	}
 
 	var deviceJSON DeviceJSON
-	if err := json.Unmarshal([]byte(dataStr), &deviceJSON); err != nil {
+	data, needsRewrite, err := openDeviceData([]byte(dataStr), cipher)
+	if err != nil {
+		return nil, err
+	}
+	ds.needsRewrite = needsRewrite
+	if err := json.Unmarshal(data, &deviceJSON); err != nil {
 		return nil, err
 	}
 
@@ -666,10 +880,14 @@ func (c *Client) RegisterPushNotifications(ctx context.Context, opts *RegisterPu
 		return fmt.Errorf("invalid auth key: %w", err)
 	}
 
-	return c.Messagix.Facebook.RegisterPushNotifications(ctx, opts.Endpoint, messagix.PushKeys{
+	keys := messagix.PushKeys{
 		P256DH: p256dh,
 		Auth:   auth,
-	})
+	}
+	if c.Platform.IsInstagram() {
+		return c.Messagix.Instagram.RegisterPushNotifications(ctx, opts.Endpoint, keys)
+	}
+	return c.Messagix.Facebook.RegisterPushNotifications(ctx, opts.Endpoint, keys)
 }
 
 // Helper to convert thread
@@ -680,6 +898,7 @@ func convertThread(t *table.LSDeleteThenInsertThread) *Thread {
 		Name:                    t.ThreadName,
 		LastActivityTimestampMs: t.LastActivityTimestampMs,
 		Snippet:                 t.Snippet,
+		Folder:                  t.FolderName,
 	}
 }
 
@@ -0,0 +1,370 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mau.fi/mautrix-meta/pkg/messagix/socket"
+	"go.mau.fi/mautrix-meta/pkg/messagix/table"
+)
+
+const (
+	// Source of member list requests made by the web client's community member dialog
+	communityMemberListSource = 7
+	// Sync group used when fetching and deleting community threads
+	communitySyncGroup = 1
+	// Reference timestamp for fetching the newest threads of a range
+	communityThreadsNewest = 9999999999999
+)
+
+// CommunityChat is a chat inside a Facebook community
+type CommunityChat struct {
+	ThreadID                int64  `json:"threadId"`
+	CommunityID             int64  `json:"communityId"`
+	Type                    int    `json:"type"`
+	Name                    string `json:"name"`
+	LastActivityTimestampMs int64  `json:"lastActivityTimestampMs"`
+	Snippet                 string `json:"snippet,omitempty"`
+}
+
+// CommunityMember is a member of a community or community chat
+type CommunityMember struct {
+	UserID            int64  `json:"userId"`
+	CommunityID       int64  `json:"communityId"`
+	Name              string `json:"name"`
+	FirstName         string `json:"firstName,omitempty"`
+	Nickname          string `json:"nickname,omitempty"`
+	ProfilePictureURL string `json:"profilePictureUrl,omitempty"`
+	IsAdmin           bool   `json:"isAdmin,omitempty"`
+	IsModerator       bool   `json:"isModerator,omitempty"`
+	IsMuted           bool   `json:"isMuted,omitempty"`
+	IsBlocked         bool   `json:"isBlocked,omitempty"`
+}
+
+// ListCommunityChatsOptions for listing the chats of a community.
+// Pages go from the most recently active chat backwards; pass the
+// Next* values of the previous page to continue.
+type ListCommunityChatsOptions struct {
+	CommunityID               int64 `json:"communityId"`
+	BeforeThreadID            int64 `json:"beforeThreadId,omitempty"`
+	BeforeActivityTimestampMs int64 `json:"beforeActivityTimestampMs,omitempty"`
+	AdditionalPagesToFetch    int   `json:"additionalPagesToFetch,omitempty"`
+}
+
+// CommunityChatsPage is a range of community chats
+type CommunityChatsPage struct {
+	Chats                   []*CommunityChat `json:"chats"`
+	HasMore                 bool             `json:"hasMore"`
+	NextThreadID            int64            `json:"nextThreadId,omitempty"`
+	NextActivityTimestampMs int64            `json:"nextActivityTimestampMs,omitempty"`
+}
+
+// FetchCommunityMembersOptions for listing the members of a community.
+// If ThreadID is set, only members of that community chat are listed.
+type FetchCommunityMembersOptions struct {
+	CommunityID int64  `json:"communityId"`
+	ThreadID    int64  `json:"threadId,omitempty"`
+	Cursor      string `json:"cursor,omitempty"`
+	Query       string `json:"query,omitempty"`
+	AdminsOnly  bool   `json:"adminsOnly,omitempty"`
+}
+
+// CommunityMembersPage is a range of community members
+type CommunityMembersPage struct {
+	Members    []*CommunityMember `json:"members"`
+	HasMore    bool               `json:"hasMore"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+}
+
+// CreateCommunitySubThreadOptions for starting a sub-thread from a message in a community chat
+type CreateCommunitySubThreadOptions struct {
+	CommunityID int64  `json:"communityId"`
+	ThreadID    int64  `json:"threadId"`
+	MessageID   string `json:"messageId"`
+}
+
+// communityThreadMap tracks which community chats and sub-threads a thread belongs to
+type communityThreadMap struct {
+	mu         sync.RWMutex
+	chats      map[int64]int64 // key: community chat thread key, value: community ID
+	subThreads map[int64]int64 // key: sub-thread key, value: parent thread key
+}
+
+func newCommunityThreadMap() *communityThreadMap {
+	return &communityThreadMap{
+		chats:      make(map[int64]int64),
+		subThreads: make(map[int64]int64),
+	}
+}
+
+func (m *communityThreadMap) putThread(threadKey, parentThreadKey int64, threadType table.ThreadType) {
+	if threadKey == 0 || parentThreadKey <= 0 {
+		return
+	}
+	m.mu.Lock()
+	if threadType == table.COMMUNITY_SUB_THREAD {
+		m.subThreads[threadKey] = parentThreadKey
+	} else {
+		m.chats[threadKey] = parentThreadKey
+	}
+	m.mu.Unlock()
+}
+
+func (m *communityThreadMap) putSubThread(subThreadKey, parentThreadKey int64) {
+	if subThreadKey == 0 || parentThreadKey == 0 {
+		return
+	}
+	m.mu.Lock()
+	m.subThreads[subThreadKey] = parentThreadKey
+	m.mu.Unlock()
+}
+
+func (m *communityThreadMap) removeThread(threadKey int64) {
+	m.mu.Lock()
+	delete(m.chats, threadKey)
+	delete(m.subThreads, threadKey)
+	m.mu.Unlock()
+}
+
+// lookup returns the parent thread (for sub-threads) and community of a thread
+func (m *communityThreadMap) lookup(threadKey int64) (parentThreadKey, communityID int64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chatKey := threadKey
+	if parent, ok := m.subThreads[threadKey]; ok {
+		parentThreadKey = parent
+		chatKey = parent
+	}
+	communityID = m.chats[chatKey]
+	return
+}
+
+// recordCommunityThreads saves the community and sub-thread relations contained in a table
+func (c *Client) recordCommunityThreads(tbl *table.LSTable) {
+	if tbl == nil {
+		return
+	}
+	for _, t := range tbl.LSDeleteThenInsertThread {
+		c.communities.putThread(t.ThreadKey, t.ParentThreadKey, t.ThreadType)
+	}
+	for _, t := range tbl.LSUpdateOrInsertThread {
+		c.communities.putThread(t.ThreadKey, t.ParentThreadKey, t.ThreadType)
+	}
+	for _, t := range tbl.LSVerifyThreadExists {
+		c.communities.putThread(t.ThreadKey, t.ParentThreadKey, t.ThreadType)
+	}
+	for _, t := range tbl.LSUpdateSubThreadXMA {
+		c.communities.putSubThread(t.ThreadKey, t.ParentThreadKey)
+	}
+	for _, msg := range tbl.LSInsertMessage {
+		c.communities.putSubThread(msg.SubthreadKey, msg.ThreadKey)
+	}
+	for _, msg := range tbl.LSUpsertMessage {
+		c.communities.putSubThread(msg.SubthreadKey, msg.ThreadKey)
+	}
+	for _, t := range tbl.LSDeleteThread {
+		c.communities.removeThread(t.ThreadKey)
+	}
+}
+
+// ListCommunityChats fetches a range of the chats in a community
+func (c *Client) ListCommunityChats(ctx context.Context, opts *ListCommunityChatsOptions) (*CommunityChatsPage, error) {
+	if opts.CommunityID == 0 {
+		return nil, fmt.Errorf("communityId is required")
+	}
+	referenceTs := opts.BeforeActivityTimestampMs
+	if referenceTs == 0 {
+		referenceTs = communityThreadsNewest
+	}
+	task := &socket.FetchThreadsTask{
+		IsAfter:                    0,
+		ParentThreadKey:            opts.CommunityID,
+		ReferenceThreadKey:         opts.BeforeThreadID,
+		ReferenceActivityTimestamp: referenceTs,
+		AdditionalPagesToFetch:     opts.AdditionalPagesToFetch,
+		SyncGroup:                  communitySyncGroup,
+	}
+	tbl, err := c.executeTasks(ctx, RateLimitActionQuery, opts.CommunityID, task)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &CommunityChatsPage{Chats: []*CommunityChat{}}
+	if tbl == nil {
+		return page, nil
+	}
+	c.recordCommunityThreads(tbl)
+	for _, t := range tbl.LSDeleteThenInsertThread {
+		if t.ParentThreadKey != opts.CommunityID {
+			continue
+		}
+		page.Chats = append(page.Chats, &CommunityChat{
+			ThreadID:                t.ThreadKey,
+			CommunityID:             t.ParentThreadKey,
+			Type:                    int(t.ThreadType),
+			Name:                    t.ThreadName,
+			LastActivityTimestampMs: t.LastActivityTimestampMs,
+			Snippet:                 t.Snippet,
+		})
+	}
+	sort.Slice(page.Chats, func(i, j int) bool {
+		return page.Chats[i].LastActivityTimestampMs > page.Chats[j].LastActivityTimestampMs
+	})
+	for _, r := range tbl.LSUpsertSyncGroupThreadsRange {
+		if r.ParentThreadKey == opts.CommunityID {
+			page.HasMore = r.HasMoreBefore
+		}
+	}
+	for _, r := range tbl.LSUpdateThreadsRangesV2 {
+		if r.ParentThreadKey == opts.CommunityID {
+			page.NextThreadID = r.MinThreadKey
+			page.NextActivityTimestampMs = r.MinLastActivityTimestampMs
+		}
+	}
+	if page.NextThreadID == 0 && len(page.Chats) > 0 {
+		last := page.Chats[len(page.Chats)-1]
+		page.NextThreadID = last.ThreadID
+		page.NextActivityTimestampMs = last.LastActivityTimestampMs
+	}
+	return page, nil
+}
+
+// FetchCommunityMembers fetches a range of the members of a community or community chat
+func (c *Client) FetchCommunityMembers(ctx context.Context, opts *FetchCommunityMembersOptions) (*CommunityMembersPage, error) {
+	if opts.CommunityID == 0 {
+		return nil, fmt.Errorf("communityId is required")
+	}
+	task := &socket.FetchCommunityMemberList{
+		CommunityID: opts.CommunityID,
+		Roles:       []int{0},
+		Cursor:      opts.Cursor,
+		Source:      communityMemberListSource,
+		ThreadKey:   opts.ThreadID,
+		ThreadRoles: []int{},
+		RequestID:   time.Now().UnixMilli(),
+	}
+	if opts.AdminsOnly {
+		task.FetchAdminsOnly = 1
+	}
+	if opts.Query != "" {
+		task.SearchText = &opts.Query
+	}
+	tbl, err := c.executeTasks(ctx, RateLimitActionQuery, opts.ThreadID, task)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &CommunityMembersPage{Members: []*CommunityMember{}}
+	if tbl == nil {
+		return page, nil
+	}
+	page.Members = collectCommunityMembers(tbl, opts.CommunityID)
+	for _, r := range tbl.LSUpsertCommunityMemberRanges {
+		if r.CommunityID == opts.CommunityID {
+			page.HasMore = r.HasMoreAfter
+			page.NextCursor = r.NextPageCursor
+		}
+	}
+	return page, nil
+}
+
+// collectCommunityMembers returns the members of a community contained in a table, each user once
+func collectCommunityMembers(tbl *table.LSTable, communityID int64) []*CommunityMember {
+	// Both tables carry the same member fields at different indexes
+	rows := make([]*table.LSInsertCommunityMember, 0, len(tbl.LSInsertCommunityMember)+len(tbl.LSUpdateOrInsertCommunityMember))
+	rows = append(rows, tbl.LSInsertCommunityMember...)
+	for _, m := range tbl.LSUpdateOrInsertCommunityMember {
+		rows = append(rows, &table.LSInsertCommunityMember{
+			CommunityID:            m.CommunityID,
+			ContactID:              m.ContactID,
+			Name:                   m.Name,
+			FirstName:              m.FirstName,
+			Nickname:               m.Nickname,
+			ProfilePictureURL:      m.ProfilePictureURL,
+			IsAdmin:                m.IsAdmin,
+			IsModerator:            m.IsModerator,
+			IsMuted:                m.IsMuted,
+			IsBlocked:              m.IsBlocked,
+			IsBlockedFromCommunity: m.IsBlockedFromCommunity,
+		})
+	}
+
+	members := make([]*CommunityMember, 0, len(rows))
+	seen := make(map[int64]bool)
+	for _, m := range rows {
+		if m.CommunityID != communityID || seen[m.ContactID] {
+			continue
+		}
+		seen[m.ContactID] = true
+		members = append(members, &CommunityMember{
+			UserID:            m.ContactID,
+			CommunityID:       m.CommunityID,
+			Name:              m.Name,
+			FirstName:         m.FirstName,
+			Nickname:          m.Nickname,
+			ProfilePictureURL: m.ProfilePictureURL,
+			IsAdmin:           m.IsAdmin,
+			IsModerator:       m.IsModerator,
+			IsMuted:           m.IsMuted,
+			IsBlocked:         m.IsBlocked || m.IsBlockedFromCommunity,
+		})
+	}
+	return members
+}
+
+// CreateCommunitySubThread starts a sub-thread from a message in a community chat.
+// Returns the key of the new sub-thread, or 0 if Meta didn't include it in the
+// response, in which case it arrives with the next sync.
+func (c *Client) CreateCommunitySubThread(ctx context.Context, opts *CreateCommunitySubThreadOptions) (int64, error) {
+	if opts.ThreadID == 0 || opts.MessageID == "" {
+		return 0, fmt.Errorf("threadId and messageId are required")
+	}
+	communityID := opts.CommunityID
+	if communityID == 0 {
+		if _, communityID = c.communities.lookup(opts.ThreadID); communityID == 0 {
+			return 0, fmt.Errorf("unknown community of thread %d, communityId is required", opts.ThreadID)
+		}
+	}
+	task := &socket.CreateCommunitySubThread{
+		ClientMutationID: time.Now().UnixMilli(),
+		CommunityID:      communityID,
+		ParentMessageID:  opts.MessageID,
+		ParentThreadID:   opts.ThreadID,
+	}
+	tbl, err := c.executeTasks(ctx, RateLimitActionSend, opts.ThreadID, task)
+	if err != nil {
+		return 0, err
+	}
+	c.recordCommunityThreads(tbl)
+	if tbl != nil {
+		for _, t := range tbl.LSUpdateSubThreadXMA {
+			if t.ParentThreadKey == opts.ThreadID {
+				return t.ThreadKey, nil
+			}
+		}
+		for _, t := range tbl.LSDeleteThenInsertThread {
+			if t.ParentThreadKey == opts.ThreadID && t.ThreadType == table.COMMUNITY_SUB_THREAD {
+				return t.ThreadKey, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+// DeleteCommunitySubThread deletes a sub-thread of a community chat
+func (c *Client) DeleteCommunitySubThread(ctx context.Context, threadID int64) error {
+	task := &socket.DeleteCommunitySubThread{
+		ThreadKey: threadID,
+		ActorID:   c.FBID,
+		SyncGroup: communitySyncGroup,
+	}
+	_, err := c.executeTasks(ctx, RateLimitActionSend, threadID, task)
+	if err != nil {
+		return err
+	}
+	c.communities.removeThread(threadID)
+	return nil
+}
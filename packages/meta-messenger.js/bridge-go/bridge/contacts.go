@@ -0,0 +1,382 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/mautrix-meta/pkg/messagix/socket"
+	"go.mau.fi/mautrix-meta/pkg/messagix/table"
+)
+
+const (
+	// Cached contacts older than this are fetched again by GetUserInfo
+	contactCacheTTL = 6 * time.Hour
+	// Contacts fetched per request by GetUsersInfo
+	contactFetchBatchSize = 50
+	// How often changed contacts are written to the database
+	contactSaveInterval = 10 * time.Second
+)
+
+// ContactPresence is the last known presence of a contact
+type ContactPresence struct {
+	Status                int64 `json:"status"`
+	LastActiveTimestampMs int64 `json:"lastActiveTimestampMs,omitempty"`
+}
+
+// GetUsersInfoOptions for looking up many users at once
+type GetUsersInfoOptions struct {
+	UserIDs []int64 `json:"userIds"`
+	Refresh bool    `json:"refresh,omitempty"` // ignore the cache
+}
+
+// ListContactsOptions for listing the cached contact directory
+type ListContactsOptions struct {
+	Query string `json:"query,omitempty"` // case-insensitive match on name or username
+	Limit int    `json:"limit,omitempty"`
+}
+
+// contactDirectory caches every contact row seen during sync and lookups.
+// With a device database the directory is also saved there and survives restarts.
+// Changed contacts are saved in batches by flush rather than on every table.
+type contactDirectory struct {
+	mu       sync.RWMutex
+	contacts map[int64]*ContactInfo
+	dirty    map[int64]struct{} // contacts changed since the last flush
+	db       *SQLDeviceStore
+	saveMu   sync.Mutex
+}
+
+func newContactDirectory(db *SQLDeviceStore) *contactDirectory {
+	return &contactDirectory{
+		contacts: make(map[int64]*ContactInfo),
+		dirty:    make(map[int64]struct{}),
+		db:       db,
+	}
+}
+
+// clone returns a deep copy of a contact
+func (contact *ContactInfo) clone() *ContactInfo {
+	cp := *contact
+	if contact.Presence != nil {
+		presence := *contact.Presence
+		cp.Presence = &presence
+	}
+	return &cp
+}
+
+// contactEqual compares two contacts by value, including their presence
+func contactEqual(a, b *ContactInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if (a.Presence == nil) != (b.Presence == nil) ||
+		(a.Presence != nil && *a.Presence != *b.Presence) {
+		return false
+	}
+	x, y := *a, *b
+	x.Presence, y.Presence = nil, nil
+	return x == y
+}
+
+const createContactsTableQuery = `
+CREATE TABLE IF NOT EXISTS bridge_contacts (
+	id         BIGINT PRIMARY KEY,
+	updated_at BIGINT NOT NULL,
+	data       TEXT   NOT NULL
+);
+`
+
+// load reads the saved directory from the database
+func (d *contactDirectory) load(ctx context.Context) error {
+	if d.db == nil {
+		return nil
+	}
+	rows, err := d.db.db.QueryContext(ctx, `SELECT data FROM bridge_contacts`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		var contact ContactInfo
+		if err := json.Unmarshal([]byte(data), &contact); err != nil {
+			return err
+		}
+		d.contacts[contact.ID] = &contact
+	}
+	return rows.Err()
+}
+
+// save writes changed contacts to the database
+func (d *contactDirectory) save(ctx context.Context, contacts []*ContactInfo) error {
+	if d.db == nil || len(contacts) == 0 {
+		return nil
+	}
+	tx, err := d.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, contact := range contacts {
+		data, err := json.Marshal(contact)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO bridge_contacts (id, updated_at, data) VALUES ($1, $2, $3)
+			ON CONFLICT (id) DO UPDATE SET updated_at=excluded.updated_at, data=excluded.data`,
+			contact.ID, contact.UpdatedAtMs, string(data),
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// flush saves the contacts changed since the last flush. Contacts that fail to save are retried on the next flush.
+func (d *contactDirectory) flush(ctx context.Context) error {
+	if d.db == nil {
+		return nil
+	}
+	d.saveMu.Lock()
+	defer d.saveMu.Unlock()
+	d.mu.Lock()
+	contacts := make([]*ContactInfo, 0, len(d.dirty))
+	for id := range d.dirty {
+		contacts = append(contacts, d.contacts[id].clone())
+	}
+	clear(d.dirty)
+	d.mu.Unlock()
+	err := d.save(ctx, contacts)
+	if err != nil {
+		d.mu.Lock()
+		for _, contact := range contacts {
+			d.dirty[contact.ID] = struct{}{}
+		}
+		d.mu.Unlock()
+	}
+	return err
+}
+
+// get returns a copy of a cached contact
+func (d *contactDirectory) get(id int64) *ContactInfo {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if contact, ok := d.contacts[id]; ok {
+		return contact.clone()
+	}
+	return nil
+}
+
+// update changes a contact (creating it if needed) and returns the old and new copies.
+// Changed contacts are marked to be saved by the next flush.
+func (d *contactDirectory) update(id int64, fn func(contact *ContactInfo)) (prev, next *ContactInfo) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	contact, ok := d.contacts[id]
+	if ok {
+		prev = contact.clone()
+	} else {
+		contact = &ContactInfo{ID: id}
+		d.contacts[id] = contact
+	}
+	fn(contact)
+	next = contact.clone()
+	if !contactEqual(prev, next) {
+		d.dirty[id] = struct{}{}
+	}
+	return prev, next
+}
+
+func (d *contactDirectory) list() []*ContactInfo {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	contacts := make([]*ContactInfo, 0, len(d.contacts))
+	for _, contact := range d.contacts {
+		contacts = append(contacts, contact.clone())
+	}
+	return contacts
+}
+
+// avatarKey strips the signed query of a CDN URL, which changes without the picture changing
+func avatarKey(avatarURL string) string {
+	parsed, err := url.Parse(avatarURL)
+	if err != nil {
+		return avatarURL
+	}
+	return parsed.Host + parsed.Path
+}
+
+func contactChanged(prev, next *ContactInfo) bool {
+	return prev != nil && prev.Name != "" &&
+		(prev.Name != next.Name || avatarKey(prev.ProfilePictureUrl) != avatarKey(next.ProfilePictureUrl))
+}
+
+// recordContacts saves the contact rows of a table into the directory and
+// emits contactUpdated when the name or picture of a known contact changes
+func (c *Client) recordContacts(tbl *table.LSTable) {
+	if tbl == nil {
+		return
+	}
+	apply := func(id int64, fn func(contact *ContactInfo)) {
+		if id == 0 {
+			return
+		}
+		prev, next := c.contacts.update(id, fn)
+		if contactChanged(prev, next) {
+			c.emitEvent(EventTypeContactUpdated, next)
+		}
+	}
+	now := time.Now().UnixMilli()
+	for _, row := range tbl.LSDeleteThenInsertContact {
+		apply(row.Id, func(contact *ContactInfo) {
+			contact.Name = row.Name
+			contact.FirstName = row.FirstName
+			contact.Username = row.Username
+			if contact.Username == "" {
+				contact.Username = row.GetUsername()
+			}
+			contact.ProfilePictureUrl = row.GetAvatarURL()
+			contact.IsMessengerUser = row.IsMessengerUser
+			contact.Gender = int64(row.Gender)
+			contact.CanViewerMessage = row.CanViewerMessage
+			contact.IsBlocked = row.BlockedByViewerStatus != 0
+			contact.UpdatedAtMs = now
+		})
+	}
+	for _, row := range tbl.LSVerifyContactRowExists {
+		apply(row.ContactId, func(contact *ContactInfo) {
+			if row.Name != "" {
+				contact.Name = row.Name
+			}
+			if row.FirstName != "" {
+				contact.FirstName = row.FirstName
+			}
+			if row.SecondaryName != "" && contact.Username == "" {
+				contact.Username = row.SecondaryName
+			}
+			if row.ProfilePictureUrl != "" {
+				contact.ProfilePictureUrl = row.ProfilePictureUrl
+			}
+			contact.CanViewerMessage = row.CanViewerMessage
+			contact.IsBlocked = row.IsBlocked || row.BlockedByViewerStatus != 0
+		})
+	}
+	for _, row := range tbl.LSDeleteThenInsertIGContactInfo {
+		apply(row.ContactId, func(contact *ContactInfo) {
+			contact.IGID = row.IgId
+			contact.IsVerified = row.VerificationStatus != 0
+		})
+	}
+	for _, row := range tbl.LSDeleteThenInsertContactPresence {
+		apply(row.ContactId, func(contact *ContactInfo) {
+			contact.Presence = &ContactPresence{
+				Status:                row.Status,
+				LastActiveTimestampMs: row.LastActiveTimestampMs,
+			}
+		})
+	}
+}
+
+// saveContacts periodically writes changed contacts to the database
+func (c *Client) saveContacts() {
+	ticker := time.NewTicker(contactSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.contacts.flush(c.ctx); err != nil {
+				c.Logger.Warn().Err(err).Msg("Failed to save contacts")
+			}
+		}
+	}
+}
+
+func contactFresh(contact *ContactInfo) bool {
+	return contact != nil && contact.UpdatedAtMs != 0 &&
+		time.Since(time.UnixMilli(contact.UpdatedAtMs)) < contactCacheTTL
+}
+
+// fetchContacts requests the full contact rows of the given users
+func (c *Client) fetchContacts(ctx context.Context, ids []int64) error {
+	for start := 0; start < len(ids); start += contactFetchBatchSize {
+		end := min(start+contactFetchBatchSize, len(ids))
+		tasks := make([]socket.Task, 0, end-start)
+		for _, id := range ids[start:end] {
+			tasks = append(tasks, &socket.GetContactsFullTask{ContactID: id})
+		}
+		tbl, err := c.executeTasks(ctx, RateLimitActionQuery, 0, tasks...)
+		if err != nil {
+			return err
+		}
+		c.recordIGIDs(tbl)
+		c.recordContacts(tbl)
+	}
+	return nil
+}
+
+// GetUsersInfo looks up many users, only fetching the ones that aren't cached or are stale.
+// Users that can't be found are left out of the result.
+func (c *Client) GetUsersInfo(ctx context.Context, opts *GetUsersInfoOptions) ([]*ContactInfo, error) {
+	var missing []int64
+	for _, id := range opts.UserIDs {
+		if opts.Refresh || !contactFresh(c.contacts.get(id)) {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		if err := c.fetchContacts(ctx, missing); err != nil {
+			return nil, err
+		}
+	}
+	users := make([]*ContactInfo, 0, len(opts.UserIDs))
+	for _, id := range opts.UserIDs {
+		if contact := c.contacts.get(id); contact != nil && contact.Name != "" {
+			if contact.IGID == "" {
+				contact.IGID = c.igIDs.userIGID(id)
+			}
+			users = append(users, contact)
+		}
+	}
+	return users, nil
+}
+
+// ListContacts returns the cached contact directory sorted by name
+func (c *Client) ListContacts(opts *ListContactsOptions) []*ContactInfo {
+	query := strings.ToLower(opts.Query)
+	contacts := make([]*ContactInfo, 0)
+	for _, contact := range c.contacts.list() {
+		if contact.Name == "" {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(contact.Name), query) &&
+			!strings.Contains(strings.ToLower(contact.Username), query) {
+			continue
+		}
+		contacts = append(contacts, contact)
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		if contacts[i].Name != contacts[j].Name {
+			return contacts[i].Name < contacts[j].Name
+		}
+		return contacts[i].ID < contacts[j].ID
+	})
+	if opts.Limit > 0 && len(contacts) > opts.Limit {
+		contacts = contacts[:opts.Limit]
+	}
+	return contacts
+}
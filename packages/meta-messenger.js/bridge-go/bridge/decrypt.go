@@ -0,0 +1,109 @@
+package bridge
+
+import (
+	"strconv"
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// How long a decryption failure is remembered while waiting for the retried message
+const decryptFailureTTL = 24 * time.Hour
+
+// How often expired decryption failures are removed
+const decryptFailurePruneInterval = time.Hour
+
+// E2EEDecryptFailedEvent is emitted when an incoming E2EE message couldn't be decrypted.
+// whatsmeow sends a retry receipt automatically, so the message may still arrive later,
+// in which case an e2eeDecryptRecovered event with the same message ID follows.
+type E2EEDecryptFailedEvent struct {
+	MessageID       string `json:"messageId"`
+	ChatJID         string `json:"chatJid"`
+	SenderJID       string `json:"senderJid"`
+	SenderID        int64  `json:"senderId"`
+	TimestampMs     int64  `json:"timestampMs"`
+	IsUnavailable   bool   `json:"isUnavailable"`             // the sender didn't send a ciphertext for this device at all
+	UnavailableType string `json:"unavailableType,omitempty"` // e.g. "view_once"
+	Hidden          bool   `json:"hidden"`                    // the sender asked for the failure not to be shown
+}
+
+// E2EEDecryptRecoveredEvent is emitted when a message that previously failed to decrypt
+// was received successfully after a retry. The e2eeMessage event for it is emitted as usual.
+type E2EEDecryptRecoveredEvent struct {
+	MessageID  string `json:"messageId"`
+	ChatJID    string `json:"chatJid"`
+	SenderJID  string `json:"senderJid"`
+	RetryCount int    `json:"retryCount"`
+	FailedAtMs int64  `json:"failedAtMs"`
+}
+
+type decryptFailure struct {
+	FailedAt time.Time
+}
+
+// handleUndecryptable records a decryption failure and emits an e2eeDecryptFailed event
+func (c *Client) handleUndecryptable(e *events.UndecryptableMessage) {
+	now := time.Now()
+	c.decryptFailuresMu.Lock()
+	if _, exists := c.decryptFailures[e.Info.ID]; !exists {
+		c.decryptFailures[e.Info.ID] = &decryptFailure{FailedAt: now}
+	}
+	c.decryptFailuresMu.Unlock()
+
+	senderID, _ := strconv.ParseInt(e.Info.Sender.User, 10, 64)
+	c.emitEvent(EventTypeE2EEDecryptFailed, &E2EEDecryptFailedEvent{
+		MessageID:       e.Info.ID,
+		ChatJID:         e.Info.Chat.String(),
+		SenderJID:       e.Info.Sender.String(),
+		SenderID:        senderID,
+		TimestampMs:     e.Info.Timestamp.UnixMilli(),
+		IsUnavailable:   e.IsUnavailable,
+		UnavailableType: string(e.UnavailableType),
+		Hidden:          e.DecryptFailMode == events.DecryptFailHide,
+	})
+}
+
+// pruneDecryptFailures removes failures whose message never arrived, it stops when the client context is cancelled
+func (c *Client) pruneDecryptFailures() {
+	ticker := time.NewTicker(decryptFailurePruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.expireDecryptFailures(now)
+		}
+	}
+}
+
+func (c *Client) expireDecryptFailures(now time.Time) {
+	c.decryptFailuresMu.Lock()
+	defer c.decryptFailuresMu.Unlock()
+	for id, failure := range c.decryptFailures {
+		if now.Sub(failure.FailedAt) > decryptFailureTTL {
+			delete(c.decryptFailures, id)
+		}
+	}
+}
+
+// resolveDecryptFailure emits e2eeDecryptRecovered if the message previously failed to decrypt
+func (c *Client) resolveDecryptFailure(e *events.FBMessage) {
+	c.decryptFailuresMu.Lock()
+	failure, ok := c.decryptFailures[e.Info.ID]
+	if ok {
+		delete(c.decryptFailures, e.Info.ID)
+	}
+	c.decryptFailuresMu.Unlock()
+	if !ok {
+		return
+	}
+
+	c.emitEvent(EventTypeE2EEDecryptRecovered, &E2EEDecryptRecoveredEvent{
+		MessageID:  e.Info.ID,
+		ChatJID:    e.Info.Chat.String(),
+		SenderJID:  e.Info.Sender.String(),
+		RetryCount: e.RetryCount,
+		FailedAtMs: failure.FailedAt.UnixMilli(),
+	})
+}
@@ -0,0 +1,295 @@
+package bridge
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// DeviceEncryptionConfig enables encryption of the E2EE device data at rest.
+// Exactly one of Passphrase or KeyFile should be set. OldPassphrase/OldKeyFile
+// are only used to open data written with a previous key, which is then
+// re-encrypted with the current key (key rotation).
+type DeviceEncryptionConfig struct {
+	Passphrase    string `json:"passphrase,omitempty"`
+	KeyFile       string `json:"keyFile,omitempty"` // any file with at least 32 bytes of secret material
+	OldPassphrase string `json:"oldPassphrase,omitempty"`
+	OldKeyFile    string `json:"oldKeyFile,omitempty"`
+}
+
+const (
+	deviceEnvelopeVersion = 1
+	deviceKDFArgon2id     = "argon2id"
+	deviceKDFKeyFile      = "keyfile-sha256"
+	deviceCipherXChaCha   = "xchacha20poly1305"
+)
+
+// Argon2id parameters for passphrases, stored in the envelope so they can be raised later
+const (
+	deviceArgonTime    = 3
+	deviceArgonMemory  = 64 * 1024
+	deviceArgonThreads = 4
+
+	// Upper bounds for parameters read from an envelope, so a tampered file
+	// can't make opening it take minutes or gigabytes of memory
+	deviceArgonMaxTime   = 16
+	deviceArgonMaxMemory = 1024 * 1024 // KiB
+)
+
+// deviceEnvelope is the on-disk format of encrypted device data
+type deviceEnvelope struct {
+	Version    int    `json:"encrypted_device_version"`
+	Cipher     string `json:"cipher"`
+	KDF        string `json:"kdf"`
+	Salt       string `json:"salt,omitempty"`
+	Time       uint32 `json:"argon2_time,omitempty"`
+	Memory     uint32 `json:"argon2_memory,omitempty"`
+	Threads    uint8  `json:"argon2_threads,omitempty"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// ErrDeviceEncryptionWithDB is returned when DeviceEncryption is combined with DeviceDBPath.
+// The SQLite store keeps the identity and pre-keys unencrypted, so the database should be
+// protected with disk encryption instead. To migrate an encrypted device file, store it in
+// clear text first with RotateDeviceKey(nil).
+var ErrDeviceEncryptionWithDB = errors.New("deviceEncryption can't be used with deviceDbPath: the SQLite device store is not encrypted by the bridge")
+
+// ErrDeviceKeyUnsupported is returned by RotateDeviceKey when there is no device file to re-encrypt
+var ErrDeviceKeyUnsupported = errors.New("device key rotation is only available for the JSON device store (devicePath or deviceData), the SQLite store from deviceDbPath is not encrypted by the bridge")
+
+// DeviceKeyError is returned when encrypted device data can't be opened
+type DeviceKeyError struct {
+	Reason string `json:"reason"`
+}
+
+func (e *DeviceKeyError) Error() string {
+	return "failed to decrypt device data: " + e.Reason
+}
+
+// ErrorCode returns the machine-readable error code
+func (e *DeviceKeyError) ErrorCode() string {
+	return "device_key_invalid"
+}
+
+// deviceSecret is a passphrase or key file contents
+type deviceSecret struct {
+	passphrase []byte
+	keyFile    []byte
+}
+
+func loadDeviceSecret(passphrase, keyFile string) (*deviceSecret, error) {
+	if passphrase != "" && keyFile != "" {
+		return nil, errors.New("only one of passphrase and keyFile can be set")
+	}
+	if passphrase != "" {
+		return &deviceSecret{passphrase: []byte(passphrase)}, nil
+	}
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read device key file: %w", err)
+		}
+		data = bytes.TrimSpace(data)
+		if len(data) < 32 {
+			return nil, errors.New("device key file must contain at least 32 bytes")
+		}
+		return &deviceSecret{keyFile: data}, nil
+	}
+	return nil, nil
+}
+
+func (s *deviceSecret) kdf() string {
+	if s.keyFile != nil {
+		return deviceKDFKeyFile
+	}
+	return deviceKDFArgon2id
+}
+
+// deriveKey derives the AEAD key using the parameters of the envelope
+func (s *deviceSecret) deriveKey(env *deviceEnvelope) ([]byte, error) {
+	switch env.KDF {
+	case deviceKDFKeyFile:
+		if s.keyFile == nil {
+			return nil, &DeviceKeyError{Reason: "data was encrypted with a key file but a passphrase is configured"}
+		}
+		sum := sha256.Sum256(s.keyFile)
+		return sum[:], nil
+	case deviceKDFArgon2id:
+		if s.passphrase == nil {
+			return nil, &DeviceKeyError{Reason: "data was encrypted with a passphrase but a key file is configured"}
+		}
+		salt, err := base64.StdEncoding.DecodeString(env.Salt)
+		if err != nil || len(salt) == 0 {
+			return nil, &DeviceKeyError{Reason: "invalid salt"}
+		}
+		if env.Time < 1 || env.Time > deviceArgonMaxTime || env.Threads < 1 || env.Memory > deviceArgonMaxMemory {
+			return nil, &DeviceKeyError{Reason: "invalid kdf parameters"}
+		}
+		return argon2.IDKey(s.passphrase, salt, env.Time, env.Memory, env.Threads, chacha20poly1305.KeySize), nil
+	default:
+		return nil, &DeviceKeyError{Reason: fmt.Sprintf("unsupported kdf %q", env.KDF)}
+	}
+}
+
+// DeviceCipher encrypts and decrypts device data
+type DeviceCipher struct {
+	current *deviceSecret
+	old     *deviceSecret
+
+	// The derived key is cached so frequent saves don't rerun argon2
+	mu       sync.Mutex
+	template *deviceEnvelope
+	key      []byte
+}
+
+// NewDeviceCipher creates a cipher from the config, returns nil if encryption isn't configured
+func NewDeviceCipher(cfg *DeviceEncryptionConfig) (*DeviceCipher, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	current, err := loadDeviceSecret(cfg.Passphrase, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	} else if current == nil {
+		return nil, errors.New("device encryption requires a passphrase or keyFile")
+	}
+	old, err := loadDeviceSecret(cfg.OldPassphrase, cfg.OldKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("old key: %w", err)
+	}
+	return &DeviceCipher{current: current, old: old}, nil
+}
+
+// sealKey returns the envelope template and key used for new writes
+func (dc *DeviceCipher) sealKey() (*deviceEnvelope, []byte, error) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if dc.key != nil {
+		return dc.template, dc.key, nil
+	}
+	env := &deviceEnvelope{
+		Version: deviceEnvelopeVersion,
+		Cipher:  deviceCipherXChaCha,
+		KDF:     dc.current.kdf(),
+	}
+	if env.KDF == deviceKDFArgon2id {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, nil, err
+		}
+		env.Salt = base64.StdEncoding.EncodeToString(salt)
+		env.Time = deviceArgonTime
+		env.Memory = deviceArgonMemory
+		env.Threads = deviceArgonThreads
+	}
+	key, err := dc.current.deriveKey(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	dc.template = env
+	dc.key = key
+	return env, key, nil
+}
+
+// Seal encrypts plaintext device JSON into an envelope
+func (dc *DeviceCipher) Seal(plaintext []byte) ([]byte, error) {
+	template, key, err := dc.sealKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	env := *template
+	env.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	// The header is authenticated so the KDF parameters can't be swapped
+	env.Ciphertext = ""
+	ad, _ := json.Marshal(&env)
+	env.Ciphertext = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, ad))
+	return json.MarshalIndent(&env, "", "  ")
+}
+
+// openDeviceEnvelope decrypts an envelope with a single secret
+func openDeviceEnvelope(env *deviceEnvelope, secret *deviceSecret) ([]byte, error) {
+	if env.Cipher != deviceCipherXChaCha {
+		return nil, &DeviceKeyError{Reason: fmt.Sprintf("unsupported cipher %q", env.Cipher)}
+	}
+	key, err := secret.deriveKey(env)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, &DeviceKeyError{Reason: "invalid nonce"}
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(env.Ciphertext)
+	if err != nil {
+		return nil, &DeviceKeyError{Reason: "invalid ciphertext"}
+	}
+	header := *env
+	header.Ciphertext = ""
+	ad, _ := json.Marshal(&header)
+	plaintext, err := aead.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, &DeviceKeyError{Reason: "wrong key or corrupted data"}
+	}
+	return plaintext, nil
+}
+
+// openDeviceData returns plaintext device JSON. needsRewrite is true when the data
+// should be saved again, because it was stored in clear text or with the old key.
+func openDeviceData(data []byte, dc *DeviceCipher) (plaintext []byte, needsRewrite bool, err error) {
+	var env deviceEnvelope
+	if json.Unmarshal(data, &env) != nil || env.Version == 0 {
+		// Plain DeviceJSON
+		return data, dc != nil, nil
+	}
+	if env.Version != deviceEnvelopeVersion {
+		return nil, false, &DeviceKeyError{Reason: fmt.Sprintf("unsupported version %d", env.Version)}
+	}
+	if dc == nil {
+		return nil, false, &DeviceKeyError{Reason: "device data is encrypted but no deviceEncryption key is configured"}
+	}
+	plaintext, err = openDeviceEnvelope(&env, dc.current)
+	if err == nil {
+		return plaintext, false, nil
+	}
+	if dc.old != nil {
+		if plaintext, oldErr := openDeviceEnvelope(&env, dc.old); oldErr == nil {
+			return plaintext, true, nil
+		}
+	}
+	return nil, false, err
+}
+
+// RotateDeviceKey re-encrypts the device data with a new key.
+// A nil config stores the data in clear text again.
+func (c *Client) RotateDeviceKey(cfg *DeviceEncryptionConfig) error {
+	if c.DeviceStore == nil {
+		return ErrDeviceKeyUnsupported
+	}
+	cipher, err := NewDeviceCipher(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid device encryption config: %w", err)
+	}
+	return c.DeviceStore.SetCipher(cipher)
+}
@@ -0,0 +1,183 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeKeyFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func mustDeviceCipher(t *testing.T, cfg *DeviceEncryptionConfig) *DeviceCipher {
+	t.Helper()
+	dc, err := NewDeviceCipher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dc
+}
+
+func TestDeviceCipherRoundTrip(t *testing.T) {
+	plaintext := []byte(`{"registration_id":1234,"identity_key":"c2VjcmV0"}`)
+	keyFile := writeKeyFile(t, "device.key", "0123456789abcdef0123456789abcdef\n")
+	tests := []struct {
+		name string
+		cfg  *DeviceEncryptionConfig
+	}{
+		{name: "passphrase", cfg: &DeviceEncryptionConfig{Passphrase: "correct horse battery staple"}},
+		{name: "key file", cfg: &DeviceEncryptionConfig{KeyFile: keyFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := mustDeviceCipher(t, tt.cfg)
+			sealed, err := dc.Seal(plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(sealed, []byte("registration_id")) {
+				t.Fatal("sealed data contains plaintext")
+			}
+			opened, needsRewrite, err := openDeviceData(sealed, dc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				t.Errorf("openDeviceData() = %s, want %s", opened, plaintext)
+			}
+			if needsRewrite {
+				t.Error("needsRewrite = true for data sealed with the current key")
+			}
+		})
+	}
+}
+
+func TestDeviceCipherOpenErrors(t *testing.T) {
+	plaintext := []byte(`{"registration_id":1234}`)
+	keyFile := writeKeyFile(t, "device.key", "0123456789abcdef0123456789abcdef")
+	otherKeyFile := writeKeyFile(t, "other.key", "fedcba9876543210fedcba9876543210")
+	sealed := func(cfg *DeviceEncryptionConfig) []byte {
+		data, err := mustDeviceCipher(t, cfg).Seal(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	withPassphrase := sealed(&DeviceEncryptionConfig{Passphrase: "first"})
+	withKeyFile := sealed(&DeviceEncryptionConfig{KeyFile: keyFile})
+
+	var tampered map[string]any
+	if err := json.Unmarshal(withPassphrase, &tampered); err != nil {
+		t.Fatal(err)
+	}
+	withParams := func(key string, value any) []byte {
+		env := make(map[string]any, len(tampered))
+		for k, v := range tampered {
+			env[k] = v
+		}
+		env[key] = value
+		data, _ := json.Marshal(env)
+		return data
+	}
+	tamperedData := withParams("argon2_time", 1)
+
+	tests := []struct {
+		name         string
+		data         []byte
+		cfg          *DeviceEncryptionConfig
+		wantKeyErr   bool
+		wantRewrite  bool
+		wantOpenData bool
+	}{
+		{name: "wrong passphrase", data: withPassphrase, cfg: &DeviceEncryptionConfig{Passphrase: "second"}, wantKeyErr: true},
+		{name: "wrong key file", data: withKeyFile, cfg: &DeviceEncryptionConfig{KeyFile: otherKeyFile}, wantKeyErr: true},
+		{name: "key file for passphrase data", data: withPassphrase, cfg: &DeviceEncryptionConfig{KeyFile: keyFile}, wantKeyErr: true},
+		{name: "passphrase for key file data", data: withKeyFile, cfg: &DeviceEncryptionConfig{Passphrase: "first"}, wantKeyErr: true},
+		{name: "no key configured", data: withPassphrase, cfg: nil, wantKeyErr: true},
+		{name: "tampered header", data: tamperedData, cfg: &DeviceEncryptionConfig{Passphrase: "first"}, wantKeyErr: true},
+		{name: "zero kdf time", data: withParams("argon2_time", 0), cfg: &DeviceEncryptionConfig{Passphrase: "first"}, wantKeyErr: true},
+		{name: "zero kdf threads", data: withParams("argon2_threads", 0), cfg: &DeviceEncryptionConfig{Passphrase: "first"}, wantKeyErr: true},
+		{name: "huge kdf memory", data: withParams("argon2_memory", 1<<31), cfg: &DeviceEncryptionConfig{Passphrase: "first"}, wantKeyErr: true},
+		{name: "old passphrase", data: withPassphrase, cfg: &DeviceEncryptionConfig{Passphrase: "second", OldPassphrase: "first"}, wantRewrite: true, wantOpenData: true},
+		{name: "old key file", data: withKeyFile, cfg: &DeviceEncryptionConfig{Passphrase: "second", OldKeyFile: keyFile}, wantRewrite: true, wantOpenData: true},
+		{name: "clear text with key", data: plaintext, cfg: &DeviceEncryptionConfig{Passphrase: "first"}, wantRewrite: true, wantOpenData: true},
+		{name: "clear text without key", data: plaintext, cfg: nil, wantOpenData: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dc *DeviceCipher
+			if tt.cfg != nil {
+				dc = mustDeviceCipher(t, tt.cfg)
+			}
+			opened, needsRewrite, err := openDeviceData(tt.data, dc)
+			var keyErr *DeviceKeyError
+			if tt.wantKeyErr {
+				if !errors.As(err, &keyErr) {
+					t.Fatalf("openDeviceData() error = %v, want DeviceKeyError", err)
+				}
+				if keyErr.ErrorCode() != "device_key_invalid" {
+					t.Errorf("ErrorCode() = %q", keyErr.ErrorCode())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantOpenData && !bytes.Equal(opened, plaintext) {
+				t.Errorf("openDeviceData() = %s, want %s", opened, plaintext)
+			}
+			if needsRewrite != tt.wantRewrite {
+				t.Errorf("needsRewrite = %v, want %v", needsRewrite, tt.wantRewrite)
+			}
+		})
+	}
+}
+
+func TestNewDeviceCipherConfig(t *testing.T) {
+	shortKey := writeKeyFile(t, "short.key", "too short")
+	tests := []struct {
+		name    string
+		cfg     *DeviceEncryptionConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "disabled", cfg: nil, wantNil: true},
+		{name: "no secret", cfg: &DeviceEncryptionConfig{}, wantErr: true},
+		{name: "both secrets", cfg: &DeviceEncryptionConfig{Passphrase: "a", KeyFile: "b"}, wantErr: true},
+		{name: "short key file", cfg: &DeviceEncryptionConfig{KeyFile: shortKey}, wantErr: true},
+		{name: "missing key file", cfg: &DeviceEncryptionConfig{KeyFile: filepath.Join(t.TempDir(), "missing")}, wantErr: true},
+		{name: "bad old key", cfg: &DeviceEncryptionConfig{Passphrase: "a", OldKeyFile: shortKey}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc, err := NewDeviceCipher(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewDeviceCipher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (dc == nil) != tt.wantNil {
+				t.Errorf("NewDeviceCipher() = %v, wantNil %v", dc, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestNewClientRejectsEncryptedDB(t *testing.T) {
+	_, err := NewClient(&ClientConfig{
+		Cookies:          map[string]string{"c_user": "1", "xs": "x"},
+		DeviceDBPath:     filepath.Join(t.TempDir(), "device.db"),
+		DeviceEncryption: &DeviceEncryptionConfig{Passphrase: "secret"},
+		LogLevel:         "none",
+	})
+	if !errors.Is(err, ErrDeviceEncryptionWithDB) {
+		t.Errorf("NewClient() error = %v, want ErrDeviceEncryptionWithDB", err)
+	}
+}
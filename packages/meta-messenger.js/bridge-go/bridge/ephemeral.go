@@ -0,0 +1,280 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waConsumerApplication"
+	"go.mau.fi/whatsmeow/proto/waMsgApplication"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/mautrix-meta/pkg/messagix/table"
+)
+
+// Disappearing timer types
+const (
+	DisappearingTypeNone      = "none"
+	DisappearingTypeAfterSend = "afterSend"
+	DisappearingTypeAfterRead = "afterRead"
+)
+
+// DisappearingTimerChangedEvent is emitted when the disappearing message timer of a chat changes.
+// Either ChatJID (E2EE chats) or ThreadID (regular threads) is set.
+type DisappearingTimerChangedEvent struct {
+	ChatJID      string `json:"chatJid,omitempty"`
+	ThreadID     int64  `json:"threadId,omitempty"`
+	SenderID     int64  `json:"senderId,omitempty"`
+	TimerSeconds uint32 `json:"timerSeconds"` // 0 means disappearing messages are off
+	Type         string `json:"type"`
+	TimestampMs  int64  `json:"timestampMs"`
+}
+
+// UnsupportedError is returned when a feature isn't available for a chat
+type UnsupportedError struct {
+	Feature string `json:"feature"`
+	Reason  string `json:"reason"`
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s is not supported: %s", e.Feature, e.Reason)
+}
+
+// ErrorCode returns the machine-readable error code
+func (e *UnsupportedError) ErrorCode() string {
+	return "unsupported"
+}
+
+// requireE2EEChat rejects JIDs that aren't encrypted chats. Regular threads only report
+// view-once media and disappearing timers, there's no task to send or change them.
+func requireE2EEChat(chatJID waTypes.JID, feature string) error {
+	if chatJID.Server == waTypes.MessengerServer || chatJID.Server == waTypes.GroupServer {
+		return nil
+	}
+	return &UnsupportedError{Feature: feature, Reason: "only available in E2EE chats"}
+}
+
+// disappearingSetting is the last known disappearing timer of a chat
+type disappearingSetting struct {
+	Seconds   uint32
+	Type      waMsgApplication.MessageApplication_EphemeralSetting_EphemeralityType
+	Timestamp int64 // unix seconds
+}
+
+func disappearingTypeName(seconds uint32, typ waMsgApplication.MessageApplication_EphemeralSetting_EphemeralityType) string {
+	if seconds == 0 {
+		return DisappearingTypeNone
+	}
+	switch typ {
+	case waMsgApplication.MessageApplication_EphemeralSetting_SEEN_BASED_WITH_TIMER,
+		waMsgApplication.MessageApplication_EphemeralSetting_SEEN_ONCE:
+		return DisappearingTypeAfterRead
+	default:
+		return DisappearingTypeAfterSend
+	}
+}
+
+// updateDisappearingSetting stores the timer of a chat and reports whether it changed.
+// The first setting seen for a chat is only recorded, as it may be old state from a sync.
+func (c *Client) updateDisappearingSetting(key string, setting *disappearingSetting) bool {
+	c.disappearingMu.Lock()
+	defer c.disappearingMu.Unlock()
+	prev, known := c.disappearingTimers[key]
+	if known && setting.Timestamp != 0 && prev.Timestamp > setting.Timestamp {
+		return false
+	}
+	c.disappearingTimers[key] = setting
+	return known && (prev.Seconds != setting.Seconds || prev.Type != setting.Type)
+}
+
+// e2eeEphemeralSetting returns the chat timer carried in the metadata of an E2EE message.
+// It's either set for the chat directly or as an entry naming the chat.
+func e2eeEphemeralSetting(e *events.FBMessage) *waMsgApplication.MessageApplication_EphemeralSetting {
+	metadata := e.FBApplication.GetMetadata()
+	if setting := metadata.GetChatEphemeralSetting(); setting != nil {
+		return setting
+	}
+	return metadata.GetEphemeralSettingList().GetEphemeralSetting()
+}
+
+// hasE2EEContent reports whether an E2EE message carries anything besides its metadata
+func hasE2EEContent(e *events.FBMessage) bool {
+	if e.Message == nil {
+		return false
+	}
+	ca, ok := e.Message.(*waConsumerApplication.ConsumerApplication)
+	return !ok || ca.GetPayload().GetSignal() == nil
+}
+
+// handleE2EEDisappearingSetting tracks the chat timer carried by an E2EE message.
+// Returns true if the message is only a timer change without any content.
+func (c *Client) handleE2EEDisappearingSetting(e *events.FBMessage, senderID int64) bool {
+	settingOnly := !hasE2EEContent(e)
+	ephemeral := e2eeEphemeralSetting(e)
+	if ephemeral == nil {
+		return settingOnly
+	}
+	setting := &disappearingSetting{
+		Seconds:   ephemeral.GetEphemeralExpiration(),
+		Type:      ephemeral.GetEphemeralityType(),
+		Timestamp: ephemeral.GetEphemeralSettingTimestamp(),
+	}
+	if ephemeral.GetIsEphemeralSettingReset() {
+		setting.Seconds, setting.Type = 0, waMsgApplication.MessageApplication_EphemeralSetting_UNKNOWN
+	} else if setting.Type == waMsgApplication.MessageApplication_EphemeralSetting_SEEN_ONCE && setting.Seconds == 0 {
+		setting.Seconds = uint32((5 * time.Minute).Seconds())
+	}
+	changed := c.updateDisappearingSetting(e.Info.Chat.String(), setting)
+	if changed || settingOnly {
+		c.emitEvent(EventTypeDisappearingTimerChanged, &DisappearingTimerChangedEvent{
+			ChatJID:      e.Info.Chat.String(),
+			SenderID:     senderID,
+			TimerSeconds: setting.Seconds,
+			Type:         disappearingTypeName(setting.Seconds, setting.Type),
+			TimestampMs:  e.Info.Timestamp.UnixMilli(),
+		})
+	}
+	return settingOnly
+}
+
+// e2eeExpiresAt returns when an E2EE message disappears, or 0 if unknown.
+// Timers that start when the message is read can't be known in advance.
+func e2eeExpiresAt(e *events.FBMessage) int64 {
+	ephemeral := e2eeEphemeralSetting(e)
+	if ephemeral.GetIsEphemeralSettingReset() || ephemeral.GetEphemeralExpiration() == 0 ||
+		disappearingTypeName(ephemeral.GetEphemeralExpiration(), ephemeral.GetEphemeralityType()) != DisappearingTypeAfterSend {
+		return 0
+	}
+	return e.Info.Timestamp.Add(time.Duration(ephemeral.GetEphemeralExpiration()) * time.Second).UnixMilli()
+}
+
+// handleThreadDisappearingSetting tracks the timer of a regular thread from a thread update
+func (c *Client) handleThreadDisappearingSetting(threadKey, ttl, updatedTs, updatedBy int64) {
+	if threadKey == 0 || updatedTs == 0 {
+		return
+	}
+	setting := &disappearingSetting{Seconds: uint32(ttl), Timestamp: updatedTs / 1000}
+	if !c.updateDisappearingSetting(strconv.FormatInt(threadKey, 10), setting) {
+		return
+	}
+	c.emitEvent(EventTypeDisappearingTimerChanged, &DisappearingTimerChangedEvent{
+		ThreadID:     threadKey,
+		SenderID:     updatedBy,
+		TimerSeconds: setting.Seconds,
+		Type:         disappearingTypeName(setting.Seconds, setting.Type),
+		TimestampMs:  updatedTs,
+	})
+}
+
+// isViewOnceAttachment checks whether a legacy attachment is view-once or replayable media
+func isViewOnceAttachment(att *table.LSInsertAttachment) bool {
+	if att.EphemeralMediaState == table.EphemeralMediaStatePermanent &&
+		att.AttachmentType != table.AttachmentTypeEphemeralImage &&
+		att.AttachmentType != table.AttachmentTypeEphemeralVideo {
+		return false
+	}
+	return att.EphemeralMediaViewMode == table.EphemeralMediaViewOnce || att.EphemeralMediaViewMode == table.EphemeralMediaReplayable
+}
+
+// SetE2EEDisappearingTimer changes the disappearing message timer of an encrypted chat.
+// Groups are updated on the server. In 1:1 chats a message without content carrying the
+// new setting is sent, and the timer is attached to every following message like native
+// clients do. The disappearingTimerChanged event is emitted once the server accepted the change.
+func (c *Client) SetE2EEDisappearingTimer(ctx context.Context, chatJIDStr string, seconds uint32) error {
+	if c.E2EE == nil || !c.E2EE.IsConnected() {
+		return ErrE2EENotConnected
+	}
+	chatJID, err := parseJID(chatJIDStr)
+	if err != nil {
+		return err
+	}
+	if err := requireE2EEChat(chatJID, "disappearing messages"); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	setting := &disappearingSetting{Seconds: seconds, Timestamp: now.Unix()}
+	if seconds != 0 {
+		setting.Type = waMsgApplication.MessageApplication_EphemeralSetting_SEND_BASED_WITH_TIMER
+	}
+	if chatJID.Server == waTypes.GroupServer {
+		err = c.E2EE.SetDisappearingTimer(ctx, chatJID, time.Duration(seconds)*time.Second, now)
+	} else {
+		err = c.sendE2EEDisappearingSetting(ctx, chatJID, setting)
+	}
+	if err != nil {
+		return err
+	}
+	c.disappearingMu.Lock()
+	c.disappearingTimers[chatJID.String()] = setting
+	c.disappearingMu.Unlock()
+
+	c.emitEvent(EventTypeDisappearingTimerChanged, &DisappearingTimerChangedEvent{
+		ChatJID:      chatJID.String(),
+		SenderID:     c.FBID,
+		TimerSeconds: seconds,
+		Type:         disappearingTypeName(seconds, setting.Type),
+		TimestampMs:  now.UnixMilli(),
+	})
+	return nil
+}
+
+// sendE2EEDisappearingSetting sends a message without content that only changes the chat timer
+func (c *Client) sendE2EEDisappearingSetting(ctx context.Context, chatJID waTypes.JID, setting *disappearingSetting) error {
+	ephemeralSetting := &waMsgApplication.MessageApplication_EphemeralSetting{
+		EphemeralExpiration:       proto.Uint32(setting.Seconds),
+		EphemeralSettingTimestamp: proto.Int64(setting.Timestamp),
+		EphemeralityType:          setting.Type.Enum(),
+	}
+	if setting.Seconds == 0 {
+		ephemeralSetting.IsEphemeralSettingReset = proto.Bool(true)
+	}
+	_, err := c.sendFBMessage(ctx, RateLimitActionManage, chatJID, &waConsumerApplication.ConsumerApplication{
+		Payload: &waConsumerApplication.ConsumerApplication_Payload{
+			Payload: &waConsumerApplication.ConsumerApplication_Payload_Signal{
+				Signal: &waConsumerApplication.ConsumerApplication_Signal{},
+			},
+		},
+	}, &waMsgApplication.MessageApplication_Metadata{
+		Ephemeral: &waMsgApplication.MessageApplication_Metadata_ChatEphemeralSetting{
+			ChatEphemeralSetting: ephemeralSetting,
+		},
+	}, whatsmeow.SendRequestExtra{})
+	return err
+}
+
+// withDisappearingSetting adds the chat's disappearing timer to the metadata of outgoing content
+func (c *Client) withDisappearingSetting(
+	chatJID waTypes.JID,
+	message any,
+	metadata *waMsgApplication.MessageApplication_Metadata,
+) *waMsgApplication.MessageApplication_Metadata {
+	ca, ok := message.(*waConsumerApplication.ConsumerApplication)
+	if !ok || ca.GetPayload().GetContent() == nil || ca.GetPayload().GetContent().GetEditMessage() != nil {
+		return metadata
+	}
+	c.disappearingMu.Lock()
+	setting, ok := c.disappearingTimers[chatJID.String()]
+	c.disappearingMu.Unlock()
+	if !ok || setting.Seconds == 0 {
+		return metadata
+	}
+	if metadata == nil {
+		metadata = &waMsgApplication.MessageApplication_Metadata{}
+	}
+	ephemeralSetting := &waMsgApplication.MessageApplication_EphemeralSetting{
+		EphemeralExpiration: proto.Uint32(setting.Seconds),
+		EphemeralityType:    setting.Type.Enum(),
+	}
+	if setting.Timestamp != 0 {
+		ephemeralSetting.EphemeralSettingTimestamp = proto.Int64(setting.Timestamp)
+	}
+	metadata.Ephemeral = &waMsgApplication.MessageApplication_Metadata_ChatEphemeralSetting{
+		ChatEphemeralSetting: ephemeralSetting,
+	}
+	return metadata
+}
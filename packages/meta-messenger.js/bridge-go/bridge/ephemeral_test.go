@@ -0,0 +1,33 @@
+package bridge
+
+import (
+	"errors"
+	"testing"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+)
+
+func TestRequireE2EEChat(t *testing.T) {
+	tests := []struct {
+		jid     string
+		wantErr bool
+	}{
+		{jid: "100@msgr"},
+		{jid: "200@g.us"},
+		{jid: "12345", wantErr: true},
+		{jid: "100@s.whatsapp.net", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.jid, func(t *testing.T) {
+			jid, err := waTypes.ParseJID(tt.jid)
+			if err != nil {
+				t.Fatalf("ParseJID() error = %v", err)
+			}
+			err = requireE2EEChat(jid, "disappearing messages")
+			var unsupported *UnsupportedError
+			if got := errors.As(err, &unsupported); got != tt.wantErr {
+				t.Errorf("requireE2EEChat() error = %v, want unsupported %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -13,9 +13,11 @@ import (
 	"go.mau.fi/whatsmeow/proto/waArmadilloXMA"
 	"go.mau.fi/whatsmeow/proto/waCommon"
 	"go.mau.fi/whatsmeow/proto/waConsumerApplication"
+	waTypes "go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
 
 	"go.mau.fi/mautrix-meta/pkg/messagix"
+	"go.mau.fi/mautrix-meta/pkg/messagix/dgw"
 	"go.mau.fi/mautrix-meta/pkg/messagix/table"
 )
 
@@ -51,6 +53,29 @@ const (
 	EventTypeE2EEReaction  EventType = "e2eeReaction"
 	EventTypeE2EEReceipt   EventType = "e2eeReceipt"
 	EventDeviceDataChanged EventType = "deviceDataChanged"
+
+	EventTypeMessageSendConfirmed EventType = "messageSendConfirmed"
+	EventTypeMessageSendFailed    EventType = "messageSendFailed"
+	EventTypeOutboxStatus         EventType = "outboxStatus"
+
+	EventTypeE2EEGroupJoined     EventType = "e2eeGroupJoined"
+	EventTypeE2EEGroupMembership EventType = "e2eeGroupMembership"
+	EventTypeE2EEGroupSubject    EventType = "e2eeGroupSubject"
+
+	EventTypeE2EEDecryptFailed    EventType = "e2eeDecryptFailed"
+	EventTypeE2EEDecryptRecovered EventType = "e2eeDecryptRecovered"
+
+	EventTypeDisappearingTimerChanged EventType = "disappearingTimerChanged"
+	EventTypeIdentityChanged          EventType = "identityChanged"
+
+	EventTypeThreadFolder EventType = "threadFolder"
+	EventTypeThreadUnread EventType = "threadUnread"
+	EventTypeBlockStatus  EventType = "blockStatus"
+
+	EventTypeContactUpdated EventType = "contactUpdated"
+
+	EventTypeRequestCompleted EventType = "requestCompleted"
+	EventTypeRequestFailed    EventType = "requestFailed"
 )
 
 // Event represents a generic event
@@ -65,6 +90,7 @@ type UserInfo struct {
 	ID       int64  `json:"id"`
 	Name     string `json:"name"`
 	Username string `json:"username"`
+	IGID     string `json:"igId,omitempty"` // Instagram user ID, only on instagram
 }
 
 // InitialData holds initial sync data
@@ -80,6 +106,10 @@ type Thread struct {
 	Name                    string `json:"name"`
 	LastActivityTimestampMs int64  `json:"lastActivityTimestampMs"`
 	Snippet                 string `json:"snippet"`
+	IGThreadID              string `json:"igThreadId,omitempty"`     // Instagram thread ID, only on instagram
+	ParentThreadID          int64  `json:"parentThreadId,omitempty"` // set for community sub-threads
+	CommunityID             int64  `json:"communityId,omitempty"`    // set for community chats and their sub-threads
+	Folder                  string `json:"folder,omitempty"`
 }
 
 // Attachment represents a media attachment
@@ -131,6 +161,12 @@ type Message struct {
 	ReplyTo     *ReplyTo      `json:"replyTo,omitempty"`
 	Mentions    []*Mention    `json:"mentions,omitempty"`
 	IsAdminMsg  bool          `json:"isAdminMsg,omitempty"`
+	ViewOnce    bool          `json:"viewOnce,omitempty"`
+	ExpiresAt   int64         `json:"expiresAt,omitempty"` // unix ms, set for disappearing messages
+	// Set for messages in community chats and their sub-threads
+	ParentThreadID int64 `json:"parentThreadId,omitempty"`
+	CommunityID    int64 `json:"communityId,omitempty"`
+	SubThreadID    int64 `json:"subThreadId,omitempty"` // set on the message a sub-thread was started from
 }
 
 // MessageEditEvent represents a message edit
@@ -157,6 +193,16 @@ type ReactionEvent struct {
 	ActorID     int64  `json:"actorId"`
 	Reaction    string `json:"reaction"`
 	TimestampMs int64  `json:"timestampMs"`
+	// Set for reactions V2, where a message can have several reactions per user
+	ReactionFBID int64 `json:"reactionFbid,omitempty"`
+	Count        int64 `json:"count,omitempty"`
+}
+
+// MessageSendConfirmedEvent is emitted when a pending send gets its real message ID
+type MessageSendConfirmedEvent struct {
+	OTID      string `json:"otid"`
+	MessageID string `json:"messageId"`
+	ThreadID  int64  `json:"threadId"`
 }
 
 // TypingEvent represents a typing event
@@ -164,6 +210,12 @@ type TypingEvent struct {
 	ThreadID int64 `json:"threadId"`
 	SenderID int64 `json:"senderId"`
 	IsTyping bool  `json:"isTyping"`
+	// Set for typing in E2EE chats
+	ChatJID string `json:"chatJid,omitempty"`
+	// Set for Instagram typing indicators from the DGW socket.
+	// ThreadID and SenderID are 0 if the FBID isn't known yet.
+	IGThreadID string `json:"igThreadId,omitempty"`
+	IGSenderID string `json:"igSenderId,omitempty"`
 }
 
 // ErrorEvent represents an error event
@@ -203,6 +255,8 @@ type E2EEMessage struct {
 	Attachments []*Attachment `json:"attachments,omitempty"`
 	ReplyTo     *ReplyTo      `json:"replyTo,omitempty"`
 	Mentions    []*Mention    `json:"mentions,omitempty"`
+	ViewOnce    bool          `json:"viewOnce,omitempty"`
+	ExpiresAt   int64         `json:"expiresAt,omitempty"` // unix ms, set for disappearing messages with a send-based timer
 }
 
 // getEventTypeName returns the type name of an event
@@ -233,7 +287,11 @@ func (c *Client) handleEvent(ctx context.Context, evt any) {
 		})
 
 	case *messagix.Event_Reconnected:
+		c.metrics.reconnected()
 		c.emitEvent(EventTypeReconnected, nil)
+		if c.Outbox != nil {
+			c.Outbox.Kick()
+		}
 
 	case *messagix.Event_SocketError:
 		c.emitEvent(EventTypeError, &ErrorEvent{
@@ -250,11 +308,18 @@ func (c *Client) handleEvent(ctx context.Context, evt any) {
 		if e.Table != nil {
 			c.handleTable(e.Table)
 		}
+
+	case *dgw.DGWEvent:
+		c.handleDGWEvent(e)
 	}
 }
 
 // handleTable processes a table from publish response
 func (c *Client) handleTable(tbl *table.LSTable) {
+	c.recordIGIDs(tbl)
+	c.recordCommunityThreads(tbl)
+	c.recordContacts(tbl)
+
 	// Process wrapped messages (includes attachments info)
 	// upsert = sync/backfill messages (should NOT emit events)
 	// insert = new real-time messages (should emit events)
@@ -283,13 +348,16 @@ func (c *Client) handleTable(tbl *table.LSTable) {
 		if handledMsgIds[msg.MessageId] {
 			continue
 		}
-		c.emitEvent(EventTypeMessage, &Message{
+		m := &Message{
 			ID:          msg.MessageId,
 			ThreadID:    msg.ThreadKey,
 			SenderID:    msg.SenderId,
 			Text:        msg.Text,
 			TimestampMs: msg.TimestampMs,
-		})
+			SubThreadID: msg.SubthreadKey,
+		}
+		m.ParentThreadID, m.CommunityID = c.communities.lookup(msg.ThreadKey)
+		c.emitEvent(EventTypeMessage, m)
 	}
 
 	// Handle message edits
@@ -388,6 +456,20 @@ func (c *Client) handleTable(tbl *table.LSTable) {
 		})
 	}
 
+	// Handle reactions V2 (newer threads only send these)
+	c.handleReactionsV2(tbl)
+
+	// Handle folder moves and block status changes
+	c.handleThreadOrganisation(tbl)
+
+	// Handle disappearing timer changes
+	for _, thread := range tbl.LSDeleteThenInsertThread {
+		c.handleThreadDisappearingSetting(thread.ThreadKey, thread.DisappearingSettingTtl, thread.DisappearingSettingUpdatedTs, thread.DisappearingSettingUpdatedBy)
+	}
+	for _, thread := range tbl.LSUpdateOrInsertThread {
+		c.handleThreadDisappearingSetting(thread.ThreadKey, thread.DisappearingSettingTtl, thread.DisappearingSettingUpdatedTs, thread.DisappearingSettingUpdatedBy)
+	}
+
 	// Handle typing indicators
 	for _, typing := range tbl.LSUpdateTypingIndicator {
 		c.emitEvent(EventTypeTyping, &TypingEvent{
@@ -396,6 +478,9 @@ func (c *Client) handleTable(tbl *table.LSTable) {
 			IsTyping: typing.IsTyping,
 		})
 	}
+
+	// Resolve sends that were accepted but not confirmed in their response
+	c.resolvePendingSends(tbl)
 }
 
 // parseMentions parses comma-separated mention strings into Mention structs
@@ -478,6 +563,20 @@ func (c *Client) convertWrappedMessage(msg *table.WrappedMessage) *Message {
 		IsAdminMsg:  msg.IsAdminMessage,
 		Attachments: []*Attachment{},
 		Mentions:    []*Mention{},
+		SubThreadID: msg.SubthreadKey,
+	}
+	m.ParentThreadID, m.CommunityID = c.communities.lookup(msg.ThreadKey)
+
+	// Handle disappearing messages
+	if msg.EphemeralExpirationTs != 0 {
+		m.ExpiresAt = msg.EphemeralExpirationTs
+	} else if msg.EphemeralDurationInSec != 0 {
+		m.ExpiresAt = msg.TimestampMs + msg.EphemeralDurationInSec*1000
+	}
+	for _, att := range msg.Attachments {
+		if isViewOnceAttachment(att) {
+			m.ViewOnce = true
+		}
 	}
 
 	// Handle reply
@@ -565,6 +664,16 @@ func (c *Client) convertWrappedMessage(msg *table.WrappedMessage) *Message {
 			continue
 		}
 
+		// Community sub-thread start notices point to the new sub-thread
+		if parsedURL, err := url.Parse(xma.ActionUrl); err == nil && parsedURL.Scheme == "fb-messenger" && parsedURL.Host == "community_subthread" {
+			c.communities.putSubThread(xma.TargetId, msg.ThreadKey)
+			m.SubThreadID = xma.TargetId
+			if m.Text == "" {
+				m.Text = xma.TitleText
+			}
+			continue
+		}
+
 		// Get the actual URL from CTA ActionUrl or fallback to xma.ActionUrl
 		var linkURL string
 		if xma.CTA != nil && xma.CTA.ActionUrl != "" {
@@ -662,18 +771,37 @@ func (c *Client) handleE2EEEvent(evt interface{}) {
 	switch e := evt.(type) {
 	case *events.Connected:
 		c.emitEvent(EventTypeE2EEConnected, nil)
+		if c.Outbox != nil {
+			c.Outbox.Kick()
+		}
 
 	case *events.Disconnected:
 		c.emitEvent(EventTypeDisconnected, map[string]any{
 			"isE2EE": true,
 		})
 
+	case *events.JoinedGroup:
+		c.emitEvent(EventTypeE2EEGroupJoined, convertGroupInfo(&e.GroupInfo))
+
+	case *events.GroupInfo:
+		c.handleE2EEGroupInfo(e)
+
+	case *events.UndecryptableMessage:
+		c.handleUndecryptable(e)
+
 	case *events.FBMessage:
+		c.resolveDecryptFailure(e)
+
 		var senderID int64
 		if e.Info.Sender.User != "" {
 			senderID, _ = strconv.ParseInt(e.Info.Sender.User, 10, 64)
 		}
 
+		// Track the disappearing timer, messages without content only change the timer
+		if c.handleE2EEDisappearingSetting(e, senderID) {
+			return
+		}
+
 		// Check if it's a reaction message (including unreaction)
 		if isE2EEReactionMessage(e) {
 			reaction := extractE2EEReaction(e)
@@ -723,6 +851,9 @@ func (c *Client) handleE2EEEvent(evt interface{}) {
 		}
 		c.emitEvent(EventTypeE2EEMessage, msg)
 
+	case *events.ChatPresence:
+		c.handleE2EEChatPresence(e)
+
 	case *events.Receipt:
 		c.emitEvent(EventTypeE2EEReceipt, map[string]any{
 			"type":       string(e.Type),
@@ -733,17 +864,57 @@ func (c *Client) handleE2EEEvent(evt interface{}) {
 	}
 }
 
+// handleE2EEChatPresence emits typing events for E2EE chats
+func (c *Client) handleE2EEChatPresence(e *events.ChatPresence) {
+	var threadID, senderID int64
+	if e.Chat.User != "" {
+		threadID, _ = strconv.ParseInt(e.Chat.User, 10, 64)
+	}
+	if e.Sender.User != "" {
+		senderID, _ = strconv.ParseInt(e.Sender.User, 10, 64)
+	}
+	c.emitEvent(EventTypeTyping, &TypingEvent{
+		ThreadID: threadID,
+		SenderID: senderID,
+		IsTyping: e.State == waTypes.ChatPresenceComposing,
+		ChatJID:  e.Chat.String(),
+	})
+}
+
 // emitEvent emits an event to the channel
 func (c *Client) emitEvent(eventType EventType, data interface{}) {
-	select {
-	case c.eventChan <- &Event{
+	c.deliverEvent(eventType, data, false)
+}
+
+// emitEventWait is like emitEvent, but waits for room in a full queue instead of dropping
+// the event, for a few seconds at most once the client disconnects. Must not be called from event handlers.
+func (c *Client) emitEventWait(eventType EventType, data interface{}) {
+	c.deliverEvent(eventType, data, true)
+}
+
+func (c *Client) deliverEvent(eventType EventType, data interface{}, wait bool) {
+	evt := &Event{
 		Type:      eventType,
 		Data:      data,
 		Timestamp: timeNowMs(),
-	}:
-	default:
-		c.Logger.Warn().Str("type", string(eventType)).Msg("Event channel full, dropping event")
 	}
+	c.metrics.eventEmitted()
+	if c.manager != nil && c.manager.dispatch(c, evt, wait) {
+		return
+	}
+	if wait {
+		if sendWait(c, c.eventChan, evt) {
+			return
+		}
+	} else {
+		select {
+		case c.eventChan <- evt:
+			return
+		default:
+		}
+	}
+	c.metrics.eventDropped()
+	c.Logger.Warn().Str("type", string(eventType)).Msg("Event channel full, dropping event")
 }
 
 // extractE2EEText extracts text from an E2EE message
@@ -1062,6 +1233,7 @@ func (c *Client) extractE2EEMessage(e *events.FBMessage, senderID int64) *E2EEMe
 		TimestampMs: e.Info.Timestamp.UnixMilli(),
 		Attachments: []*Attachment{},
 		Mentions:    []*Mention{},
+		ExpiresAt:   e2eeExpiresAt(e),
 	}
 
 	// Extract reply info from FBApplication metadata
@@ -1104,6 +1276,26 @@ func (c *Client) extractE2EEMessage(e *events.FBMessage, senderID int64) *E2EEMe
 					}
 				}
 
+				// Check for view-once image/video
+				if viewOnce, ok := content.GetContent().(*waConsumerApplication.ConsumerApplication_Content_ViewOnceMessage); ok {
+					msg.ViewOnce = true
+					var caption *waCommon.MessageText
+					switch media := viewOnce.ViewOnceMessage.GetViewOnceContent().(type) {
+					case *waConsumerApplication.ConsumerApplication_ViewOnceMessage_ImageMessage:
+						msg.Attachments = append(msg.Attachments, c.extractE2EEImageAttachment(media.ImageMessage))
+						caption = media.ImageMessage.GetCaption()
+					case *waConsumerApplication.ConsumerApplication_ViewOnceMessage_VideoMessage:
+						msg.Attachments = append(msg.Attachments, c.extractE2EEVideoAttachment(media.VideoMessage))
+						caption = media.VideoMessage.GetCaption()
+					}
+					if caption != nil {
+						msg.Text = caption.GetText()
+						if mentions := extractE2EEMentions(caption); mentions != nil {
+							msg.Mentions = mentions
+						}
+					}
+				}
+
 				// Check for audio/voice
 				if audio, ok := content.GetContent().(*waConsumerApplication.ConsumerApplication_Content_AudioMessage); ok {
 					att := c.extractE2EEAudioAttachment(audio.AudioMessage)
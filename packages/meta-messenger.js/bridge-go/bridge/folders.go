@@ -0,0 +1,193 @@
+package bridge
+
+import (
+	"context"
+
+	"go.mau.fi/mautrix-meta/pkg/messagix/socket"
+	"go.mau.fi/mautrix-meta/pkg/messagix/table"
+)
+
+// Thread folders, as named by Meta
+const (
+	ThreadFolderInbox    = "inbox"
+	ThreadFolderPending  = "pending" // message requests
+	ThreadFolderOther    = "other"
+	ThreadFolderSpam     = "spam"
+	ThreadFolderArchived = "archived"
+)
+
+// ThreadFolderEvent is emitted when a thread moves to another folder
+type ThreadFolderEvent struct {
+	ThreadID int64  `json:"threadId"`
+	Folder   string `json:"folder"`
+}
+
+// ThreadUnreadEvent is emitted when a thread is marked unread
+type ThreadUnreadEvent struct {
+	ThreadID                 int64 `json:"threadId"`
+	ReadWatermarkTimestampMs int64 `json:"readWatermarkTimestampMs,omitempty"`
+}
+
+// BlockStatusEvent is emitted when a user is blocked or unblocked
+type BlockStatusEvent struct {
+	UserID  int64 `json:"userId"`
+	Blocked bool  `json:"blocked"`
+}
+
+// MarkUnreadOptions for marking a thread unread
+type MarkUnreadOptions struct {
+	ThreadID int64 `json:"threadId"`
+	// Timestamp of the last message, which becomes unread. Without it the whole thread is unread.
+	LastMessageTimestampMs int64 `json:"lastMessageTimestampMs,omitempty"`
+}
+
+// setThreadFolder records the folder of a thread and emits an event if it changed.
+// Folders seen for the first time are only emitted if force is set.
+func (c *Client) setThreadFolder(threadID int64, folder string, force bool) {
+	if threadID == 0 || folder == "" {
+		return
+	}
+	c.threadFoldersMu.Lock()
+	prev, known := c.threadFolders[threadID]
+	c.threadFolders[threadID] = folder
+	c.threadFoldersMu.Unlock()
+	if prev == folder || (!known && !force) {
+		return
+	}
+	c.emitEvent(EventTypeThreadFolder, &ThreadFolderEvent{
+		ThreadID: threadID,
+		Folder:   folder,
+	})
+}
+
+// threadReadState is the last known read state of a thread, used to tell when a thread is marked unread
+type threadReadState struct {
+	LastActivityMs  int64
+	ReadWatermarkMs int64
+	HasWatermark    bool
+}
+
+// setThreadActivity records the newest activity seen in a thread
+func (c *Client) setThreadActivity(threadID, timestampMs int64) {
+	if threadID == 0 || timestampMs == 0 {
+		return
+	}
+	c.threadReadStatesMu.Lock()
+	defer c.threadReadStatesMu.Unlock()
+	state, ok := c.threadReadStates[threadID]
+	if !ok {
+		state = &threadReadState{}
+		c.threadReadStates[threadID] = state
+	}
+	state.LastActivityMs = max(state.LastActivityMs, timestampMs)
+}
+
+// setThreadReadWatermark records the read watermark of a thread. A thread was marked unread
+// when its watermark moved back behind its last activity, new messages don't move the watermark.
+// If emit is set, a threadUnread event is emitted for that, markedUnread skips the checks.
+func (c *Client) setThreadReadWatermark(threadID, watermarkMs int64, markedUnread, emit bool) {
+	c.threadReadStatesMu.Lock()
+	state, ok := c.threadReadStates[threadID]
+	if !ok {
+		state = &threadReadState{}
+		c.threadReadStates[threadID] = state
+	}
+	movedBack := state.HasWatermark && watermarkMs < state.ReadWatermarkMs && watermarkMs < state.LastActivityMs
+	state.ReadWatermarkMs = watermarkMs
+	state.HasWatermark = true
+	c.threadReadStatesMu.Unlock()
+	if !emit || (!markedUnread && !movedBack) {
+		return
+	}
+	c.emitEvent(EventTypeThreadUnread, &ThreadUnreadEvent{
+		ThreadID:                 threadID,
+		ReadWatermarkTimestampMs: watermarkMs,
+	})
+}
+
+// setContactBlocked records whether a contact is blocked and emits an event if it changed.
+// Contacts seen for the first time aren't emitted.
+func (c *Client) setContactBlocked(userID int64, blocked bool) {
+	if userID == 0 {
+		return
+	}
+	c.blockedContactsMu.Lock()
+	prev, known := c.blockedContacts[userID]
+	c.blockedContacts[userID] = blocked
+	c.blockedContactsMu.Unlock()
+	if !known || prev == blocked {
+		return
+	}
+	c.emitEvent(EventTypeBlockStatus, &BlockStatusEvent{
+		UserID:  userID,
+		Blocked: blocked,
+	})
+}
+
+// handleThreadOrganisation emits folder, unread and block status changes from a table
+func (c *Client) handleThreadOrganisation(tbl *table.LSTable) {
+	// Read watermarks in this table, the parent folder update only carries the thread key
+	watermarks := make(map[int64]int64)
+	for _, t := range tbl.LSDeleteThenInsertThread {
+		c.setThreadFolder(t.ThreadKey, t.FolderName, false)
+		c.setThreadActivity(t.ThreadKey, t.LastActivityTimestampMs)
+		watermarks[t.ThreadKey] = t.LastReadWatermarkTimestampMs
+	}
+	for _, t := range tbl.LSUpdateOrInsertThread {
+		c.setThreadFolder(t.ThreadKey, t.FolderName, false)
+		c.setThreadActivity(t.ThreadKey, t.LastActivityTimestampMs)
+		watermarks[t.ThreadKey] = t.LastReadWatermarkTimestampMs
+	}
+	for _, msg := range tbl.LSInsertMessage {
+		c.setThreadActivity(msg.ThreadKey, msg.TimestampMs)
+	}
+	for _, msg := range tbl.LSUpsertMessage {
+		c.setThreadActivity(msg.ThreadKey, msg.TimestampMs)
+	}
+	for _, read := range tbl.LSMarkThreadRead {
+		watermarks[read.ThreadKey] = read.LastReadWatermarkTimestampMs
+	}
+	for _, read := range tbl.LSMarkThreadReadV2 {
+		watermarks[read.ThreadKey] = read.LastReadWatermarkTimestampMs
+	}
+	// Meta sends a parent folder read watermark update when the read state of a thread is changed,
+	// watermarks from other rows only update the known state
+	updated := make(map[int64]bool, len(tbl.LSUpdateParentFolderReadWatermark))
+	for _, update := range tbl.LSUpdateParentFolderReadWatermark {
+		updated[update.ThreadKey] = true
+	}
+	for threadID, watermark := range watermarks {
+		c.setThreadReadWatermark(threadID, watermark, false, updated[threadID])
+	}
+	for _, t := range tbl.LSMoveThreadToArchivedFolder {
+		c.setThreadFolder(t.ThreadKey, ThreadFolderArchived, true)
+	}
+	for _, t := range tbl.LSMoveThreadToInboxAndUpdateParent {
+		c.setThreadFolder(t.ThreadKey, ThreadFolderInbox, true)
+	}
+	for _, contact := range tbl.LSDeleteThenInsertContact {
+		c.setContactBlocked(contact.Id, contact.BlockedByViewerStatus != 0)
+	}
+	for _, contact := range tbl.LSVerifyContactRowExists {
+		c.setContactBlocked(contact.ContactId, contact.IsBlocked || contact.BlockedByViewerStatus != 0)
+	}
+}
+
+// MarkUnread marks a thread unread by moving the read watermark before the last message
+func (c *Client) MarkUnread(ctx context.Context, opts *MarkUnreadOptions) error {
+	var watermarkTs int64
+	if opts.LastMessageTimestampMs > 0 {
+		watermarkTs = opts.LastMessageTimestampMs - 1
+	}
+	task := &socket.ThreadMarkReadTask{
+		ThreadId:            opts.ThreadID,
+		LastReadWatermarkTs: watermarkTs,
+		SyncGroup:           1,
+	}
+	_, err := c.executeTasks(ctx, RateLimitActionManage, opts.ThreadID, task)
+	if err != nil {
+		return err
+	}
+	c.setThreadReadWatermark(opts.ThreadID, watermarkTs, true, true)
+	return nil
+}
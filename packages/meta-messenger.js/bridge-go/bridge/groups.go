@@ -0,0 +1,217 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"go.mau.fi/whatsmeow"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// E2EEGroupParticipant is a member of an encrypted group
+type E2EEGroupParticipant struct {
+	JID          string `json:"jid"`
+	UserID       int64  `json:"userId,omitempty"`
+	IsAdmin      bool   `json:"isAdmin"`
+	IsSuperAdmin bool   `json:"isSuperAdmin"`
+	Error        int    `json:"error,omitempty"` // set when adding/removing this participant failed
+}
+
+// E2EEGroupInfo describes an encrypted group chat
+type E2EEGroupInfo struct {
+	JID               string                  `json:"jid"`
+	Name              string                  `json:"name"`
+	Topic             string                  `json:"topic,omitempty"`
+	OwnerJID          string                  `json:"ownerJid,omitempty"`
+	IsLocked          bool                    `json:"isLocked"`
+	IsAnnounce        bool                    `json:"isAnnounce"`
+	IsEphemeral       bool                    `json:"isEphemeral"`
+	DisappearingTimer uint32                  `json:"disappearingTimer,omitempty"`
+	CreatedAtMs       int64                   `json:"createdAtMs,omitempty"`
+	Participants      []*E2EEGroupParticipant `json:"participants"`
+}
+
+// E2EEGroupMembershipEvent is emitted when members join, leave, or change admin status
+type E2EEGroupMembershipEvent struct {
+	ChatJID     string   `json:"chatJid"`
+	SenderJID   string   `json:"senderJid,omitempty"`
+	Joined      []string `json:"joined,omitempty"`
+	Left        []string `json:"left,omitempty"`
+	Promoted    []string `json:"promoted,omitempty"`
+	Demoted     []string `json:"demoted,omitempty"`
+	TimestampMs int64    `json:"timestampMs"`
+}
+
+// E2EEGroupSubjectEvent is emitted when the name or topic of a group changes
+type E2EEGroupSubjectEvent struct {
+	ChatJID     string  `json:"chatJid"`
+	SenderJID   string  `json:"senderJid,omitempty"`
+	Name        *string `json:"name,omitempty"`
+	Topic       *string `json:"topic,omitempty"`
+	TimestampMs int64   `json:"timestampMs"`
+}
+
+func convertGroupParticipant(p *waTypes.GroupParticipant) *E2EEGroupParticipant {
+	userID, _ := strconv.ParseInt(p.JID.User, 10, 64)
+	return &E2EEGroupParticipant{
+		JID:          p.JID.String(),
+		UserID:       userID,
+		IsAdmin:      p.IsAdmin,
+		IsSuperAdmin: p.IsSuperAdmin,
+		Error:        p.Error,
+	}
+}
+
+func convertGroupInfo(info *waTypes.GroupInfo) *E2EEGroupInfo {
+	result := &E2EEGroupInfo{
+		JID:               info.JID.String(),
+		Name:              info.Name,
+		Topic:             info.Topic,
+		IsLocked:          info.IsLocked,
+		IsAnnounce:        info.IsAnnounce,
+		IsEphemeral:       info.IsEphemeral,
+		DisappearingTimer: info.DisappearingTimer,
+		Participants:      make([]*E2EEGroupParticipant, 0, len(info.Participants)),
+	}
+	if !info.OwnerJID.IsEmpty() {
+		result.OwnerJID = info.OwnerJID.String()
+	}
+	if !info.GroupCreated.IsZero() {
+		result.CreatedAtMs = info.GroupCreated.UnixMilli()
+	}
+	for i := range info.Participants {
+		result.Participants = append(result.Participants, convertGroupParticipant(&info.Participants[i]))
+	}
+	return result
+}
+
+func jidStrings(jids []waTypes.JID) []string {
+	if len(jids) == 0 {
+		return nil
+	}
+	result := make([]string, len(jids))
+	for i, jid := range jids {
+		result[i] = jid.String()
+	}
+	return result
+}
+
+// parseGroupJID parses a group JID, defaulting to the group server for bare IDs
+func parseGroupJID(jidStr string) (waTypes.JID, error) {
+	jid, err := parseJID(jidStr)
+	if err != nil {
+		return jid, err
+	}
+	if jid.Server == "" {
+		jid.Server = waTypes.GroupServer
+	}
+	if jid.Server != waTypes.GroupServer {
+		return jid, fmt.Errorf("not a group JID: %s", jidStr)
+	}
+	return jid, nil
+}
+
+// GetE2EEGroupInfo fetches the info and member list of an encrypted group
+func (c *Client) GetE2EEGroupInfo(ctx context.Context, chatJIDStr string) (*E2EEGroupInfo, error) {
+	if c.E2EE == nil || !c.E2EE.IsConnected() {
+		return nil, ErrE2EENotConnected
+	}
+	chatJID, err := parseGroupJID(chatJIDStr)
+	if err != nil {
+		return nil, err
+	}
+	info, err := c.E2EE.GetGroupInfo(ctx, chatJID)
+	if err != nil {
+		return nil, err
+	}
+	return convertGroupInfo(info), nil
+}
+
+// CreateE2EEGroup creates an encrypted group with the given Facebook user IDs
+func (c *Client) CreateE2EEGroup(ctx context.Context, name string, userIDs []int64) (*E2EEGroupInfo, error) {
+	if c.E2EE == nil || !c.E2EE.IsConnected() {
+		return nil, ErrE2EENotConnected
+	}
+	participants := make([]waTypes.JID, len(userIDs))
+	for i, id := range userIDs {
+		participants[i] = waTypes.NewJID(strconv.FormatInt(id, 10), waTypes.MessengerServer)
+	}
+	info, err := c.E2EE.CreateGroup(ctx, whatsmeow.ReqCreateGroup{
+		Name:         name,
+		Participants: participants,
+		CreateKey:    c.E2EE.GenerateMessageID(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return convertGroupInfo(info), nil
+}
+
+// AddE2EEGroupParticipants adds users to an encrypted group.
+// The result contains one entry per user, with Error set if the change failed for that user.
+func (c *Client) AddE2EEGroupParticipants(ctx context.Context, chatJIDStr string, userIDs []int64) ([]*E2EEGroupParticipant, error) {
+	return c.updateE2EEGroupParticipants(ctx, chatJIDStr, userIDs, whatsmeow.ParticipantChangeAdd)
+}
+
+// RemoveE2EEGroupParticipants removes users from an encrypted group
+func (c *Client) RemoveE2EEGroupParticipants(ctx context.Context, chatJIDStr string, userIDs []int64) ([]*E2EEGroupParticipant, error) {
+	return c.updateE2EEGroupParticipants(ctx, chatJIDStr, userIDs, whatsmeow.ParticipantChangeRemove)
+}
+
+func (c *Client) updateE2EEGroupParticipants(ctx context.Context, chatJIDStr string, userIDs []int64, action whatsmeow.ParticipantChange) ([]*E2EEGroupParticipant, error) {
+	if c.E2EE == nil || !c.E2EE.IsConnected() {
+		return nil, ErrE2EENotConnected
+	}
+	chatJID, err := parseGroupJID(chatJIDStr)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]waTypes.JID, len(userIDs))
+	for i, id := range userIDs {
+		changes[i] = waTypes.NewJID(strconv.FormatInt(id, 10), waTypes.MessengerServer)
+	}
+	resp, err := c.E2EE.UpdateGroupParticipants(ctx, chatJID, changes, action)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*E2EEGroupParticipant, len(resp))
+	for i := range resp {
+		result[i] = convertGroupParticipant(&resp[i])
+	}
+	return result, nil
+}
+
+// handleE2EEGroupInfo emits membership and subject events for a group change
+func (c *Client) handleE2EEGroupInfo(e *events.GroupInfo) {
+	var senderJID string
+	if e.Sender != nil {
+		senderJID = e.Sender.String()
+	}
+	if len(e.Join) > 0 || len(e.Leave) > 0 || len(e.Promote) > 0 || len(e.Demote) > 0 {
+		c.emitEvent(EventTypeE2EEGroupMembership, &E2EEGroupMembershipEvent{
+			ChatJID:     e.JID.String(),
+			SenderJID:   senderJID,
+			Joined:      jidStrings(e.Join),
+			Left:        jidStrings(e.Leave),
+			Promoted:    jidStrings(e.Promote),
+			Demoted:     jidStrings(e.Demote),
+			TimestampMs: e.Timestamp.UnixMilli(),
+		})
+	}
+	if e.Name != nil || e.Topic != nil {
+		evt := &E2EEGroupSubjectEvent{
+			ChatJID:     e.JID.String(),
+			SenderJID:   senderJID,
+			TimestampMs: e.Timestamp.UnixMilli(),
+		}
+		if e.Name != nil {
+			evt.Name = &e.Name.Name
+		}
+		if e.Topic != nil {
+			evt.Topic = &e.Topic.Topic
+		}
+		c.emitEvent(EventTypeE2EEGroupSubject, evt)
+	}
+}
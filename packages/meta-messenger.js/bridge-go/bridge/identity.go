@@ -0,0 +1,350 @@
+package bridge
+
+import (
+	"context"
+	"crypto/sha512"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/libsignal/ecc"
+	"go.mau.fi/libsignal/fingerprint"
+	"go.mau.fi/whatsmeow/store"
+)
+
+// TrustPolicy decides how changed identity keys of contacts are handled
+type TrustPolicy string
+
+const (
+	// TrustPolicyTOFU trusts the first key seen and accepts changed keys, emitting identityChanged (default)
+	TrustPolicyTOFU TrustPolicy = "tofu"
+	// TrustPolicyAlways never checks identity keys, changes are still reported
+	TrustPolicyAlways TrustPolicy = "always"
+	// TrustPolicyStrict rejects changed keys until they're approved with TrustE2EEIdentity
+	TrustPolicyStrict TrustPolicy = "strict"
+)
+
+// Signal's numeric fingerprint parameters (version 0, as used for safety numbers)
+const (
+	fingerprintIterations = 5200
+	fingerprintVersion    = 0
+)
+
+// IdentityChangedEvent is emitted when the identity key of a contact's device changes
+type IdentityChangedEvent struct {
+	Address        string `json:"address"` // Signal address, "<userId>:<device>"
+	UserID         int64  `json:"userId"`
+	Device         int    `json:"device"`
+	OldFingerprint string `json:"oldFingerprint,omitempty"`
+	NewFingerprint string `json:"newFingerprint"`
+	Trusted        bool   `json:"trusted"` // false if the key was rejected by the strict policy
+	Policy         string `json:"policy"`
+	TimestampMs    int64  `json:"timestampMs"`
+}
+
+// SafetyNumber is the number two users can compare to verify their E2EE chat
+type SafetyNumber struct {
+	ContactID         int64  `json:"contactId"`
+	SafetyNumber      string `json:"safetyNumber"` // 60 digits
+	LocalFingerprint  string `json:"localFingerprint"`
+	RemoteFingerprint string `json:"remoteFingerprint"`
+	Devices           int    `json:"devices"` // number of contact devices included
+}
+
+// identityLookup reads stored identity keys, which the whatsmeow IdentityStore interface can't do
+type identityLookup interface {
+	getIdentity(ctx context.Context, address string) ([32]byte, bool, error)
+	getAllIdentities(ctx context.Context) (map[string][32]byte, error)
+}
+
+func (ds *DeviceStore) getIdentity(_ context.Context, address string) ([32]byte, bool, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	key, ok := ds.identities[address]
+	return key, ok, nil
+}
+
+func (ds *DeviceStore) getAllIdentities(_ context.Context) (map[string][32]byte, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	result := make(map[string][32]byte, len(ds.identities))
+	for address, key := range ds.identities {
+		result[address] = key
+	}
+	return result, nil
+}
+
+func (s *SQLDeviceStore) getIdentity(ctx context.Context, address string) ([32]byte, bool, error) {
+	var key []byte
+	err := s.db.QueryRowContext(ctx,
+		`SELECT identity FROM whatsmeow_identity_keys WHERE our_jid=$1 AND their_id=$2`,
+		s.Device.ID.String(), address,
+	).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return [32]byte{}, false, nil
+	} else if err != nil {
+		return [32]byte{}, false, err
+	} else if len(key) != 32 {
+		return [32]byte{}, false, fmt.Errorf("invalid identity key length %d", len(key))
+	}
+	return [32]byte(key), true, nil
+}
+
+func (s *SQLDeviceStore) getAllIdentities(ctx context.Context) (map[string][32]byte, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT their_id, identity FROM whatsmeow_identity_keys WHERE our_jid=$1`, s.Device.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[string][32]byte)
+	for rows.Next() {
+		var address string
+		var key []byte
+		if err := rows.Scan(&address, &key); err != nil {
+			return nil, err
+		}
+		if len(key) == 32 {
+			result[address] = [32]byte(key)
+		}
+	}
+	return result, rows.Err()
+}
+
+// policyIdentityStore wraps the device identity store to apply the trust policy
+// and report identity key changes
+type policyIdentityStore struct {
+	store.IdentityStore
+	client *Client
+	lookup identityLookup
+	policy TrustPolicy
+
+	mu sync.Mutex
+	// Keys deleted by whatsmeow when it resets an untrusted identity, so the
+	// following PutIdentity can report the change
+	deleted map[string][32]byte
+	// Keys rejected by the strict policy, waiting for TrustE2EEIdentity
+	rejected map[string][32]byte
+}
+
+func (ps *policyIdentityStore) DeleteIdentity(ctx context.Context, address string) error {
+	if old, ok, err := ps.lookup.getIdentity(ctx, address); err == nil && ok {
+		ps.mu.Lock()
+		ps.deleted[address] = old
+		ps.mu.Unlock()
+	}
+	return ps.IdentityStore.DeleteIdentity(ctx, address)
+}
+
+func (ps *policyIdentityStore) PutIdentity(ctx context.Context, address string, key [32]byte) error {
+	old, known, err := ps.lookup.getIdentity(ctx, address)
+	if err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	if deleted, ok := ps.deleted[address]; ok && !known {
+		old, known = deleted, true
+	}
+	delete(ps.deleted, address)
+	ps.mu.Unlock()
+	if known && old != key {
+		ps.client.emitIdentityChanged(address, &old, key, true, ps.policy)
+	}
+	return ps.IdentityStore.PutIdentity(ctx, address, key)
+}
+
+func (ps *policyIdentityStore) IsTrustedIdentity(ctx context.Context, address string, key [32]byte) (bool, error) {
+	if ps.policy == TrustPolicyAlways {
+		return true, nil
+	}
+	trusted, err := ps.IdentityStore.IsTrustedIdentity(ctx, address, key)
+	if err != nil || trusted || ps.policy != TrustPolicyStrict {
+		return trusted, err
+	}
+
+	ps.mu.Lock()
+	prev, alreadyRejected := ps.rejected[address]
+	ps.rejected[address] = key
+	ps.mu.Unlock()
+	if !alreadyRejected || prev != key {
+		var oldPtr *[32]byte
+		if old, ok, _ := ps.lookup.getIdentity(ctx, address); ok {
+			oldPtr = &old
+		}
+		ps.client.emitIdentityChanged(address, oldPtr, key, false, ps.policy)
+	}
+	return false, nil
+}
+
+// parseSignalAddress splits "<user>[_<agent>]:<device>" into the user ID and device
+func parseSignalAddress(address string) (int64, int) {
+	name, deviceStr, _ := strings.Cut(address, ":")
+	name, _, _ = strings.Cut(name, "_")
+	userID, _ := strconv.ParseInt(name, 10, 64)
+	device, _ := strconv.Atoi(deviceStr)
+	return userID, device
+}
+
+func (c *Client) emitIdentityChanged(address string, old *[32]byte, key [32]byte, trusted bool, policy TrustPolicy) {
+	userID, device := parseSignalAddress(address)
+	evt := &IdentityChangedEvent{
+		Address:        address,
+		UserID:         userID,
+		Device:         device,
+		NewFingerprint: identityFingerprint(userID, [][32]byte{key}),
+		Trusted:        trusted,
+		Policy:         string(policy),
+		TimestampMs:    time.Now().UnixMilli(),
+	}
+	if old != nil {
+		evt.OldFingerprint = identityFingerprint(userID, [][32]byte{*old})
+	}
+	c.Logger.Warn().
+		Str("address", address).
+		Bool("trusted", trusted).
+		Msg("Identity key changed")
+	c.emitEvent(EventTypeIdentityChanged, evt)
+}
+
+// numericFingerprint computes one half of a safety number like Signal's NumericFingerprintGenerator.
+// Multiple keys (one per device) are sorted and concatenated.
+func numericFingerprint(stableID string, identityKeys [][32]byte) []byte {
+	serialized := make([][]byte, len(identityKeys))
+	for i, key := range identityKeys {
+		serialized[i] = append([]byte{ecc.DjbType}, key[:]...)
+	}
+	sort.Slice(serialized, func(i, j int) bool { return string(serialized[i]) < string(serialized[j]) })
+	var publicKeys []byte
+	for _, key := range serialized {
+		publicKeys = append(publicKeys, key...)
+	}
+
+	hash := append([]byte{0, fingerprintVersion}, publicKeys...)
+	hash = append(hash, stableID...)
+	for i := 0; i < fingerprintIterations; i++ {
+		h := sha512.New()
+		h.Write(hash)
+		h.Write(publicKeys)
+		hash = h.Sum(nil)
+	}
+	return hash[:30]
+}
+
+// identityFingerprint returns the 30 digit fingerprint of a user's identity keys
+func identityFingerprint(userID int64, identityKeys [][32]byte) string {
+	fp := numericFingerprint(strconv.FormatInt(userID, 10), identityKeys)
+	// The display of one side is the second half of a display with itself on both sides
+	return fingerprint.NewDisplay(fp, fp).DisplayText()[:30]
+}
+
+// setupIdentityPolicy installs the trust policy on the device before the E2EE client is created
+func (c *Client) setupIdentityPolicy(device *store.Device, lookup identityLookup) {
+	if ps, ok := device.Identities.(*policyIdentityStore); ok {
+		// Reconnect, the device was already wrapped
+		c.identityStore = ps
+		return
+	}
+	ps := &policyIdentityStore{
+		IdentityStore: device.Identities,
+		client:        c,
+		lookup:        lookup,
+		policy:        c.trustPolicy,
+		deleted:       make(map[string][32]byte),
+		rejected:      make(map[string][32]byte),
+	}
+	device.Identities = ps
+	c.identityStore = ps
+}
+
+// GetE2EESafetyNumber computes the safety number with a contact from this device's identity
+// key and all known identity keys of the contact's devices
+func (c *Client) GetE2EESafetyNumber(ctx context.Context, contactID int64) (*SafetyNumber, error) {
+	if c.E2EE == nil || c.identityStore == nil {
+		return nil, ErrE2EENotConnected
+	}
+	all, err := c.identityStore.lookup.getAllIdentities(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity keys: %w", err)
+	}
+	var remoteKeys [][32]byte
+	for address, key := range all {
+		if userID, _ := parseSignalAddress(address); userID == contactID {
+			remoteKeys = append(remoteKeys, key)
+		}
+	}
+	if len(remoteKeys) == 0 {
+		return nil, fmt.Errorf("no identity keys known for %d, a message must be exchanged first", contactID)
+	}
+
+	local := numericFingerprint(strconv.FormatInt(c.FBID, 10), [][32]byte{*c.E2EE.Store.IdentityKey.Pub})
+	remote := numericFingerprint(strconv.FormatInt(contactID, 10), remoteKeys)
+	display := fingerprint.NewDisplay(local, remote)
+	return &SafetyNumber{
+		ContactID:         contactID,
+		SafetyNumber:      display.DisplayText(),
+		LocalFingerprint:  identityFingerprint(c.FBID, [][32]byte{*c.E2EE.Store.IdentityKey.Pub}),
+		RemoteFingerprint: identityFingerprint(contactID, remoteKeys),
+		Devices:           len(remoteKeys),
+	}, nil
+}
+
+// CompareE2EESafetyNumber checks a safety number the contact read out or scanned.
+// Whitespace in the input is ignored.
+func (c *Client) CompareE2EESafetyNumber(ctx context.Context, contactID int64, safetyNumber string) (bool, error) {
+	current, err := c.GetE2EESafetyNumber(ctx, contactID)
+	if err != nil {
+		return false, err
+	}
+	normalized := strings.Join(strings.Fields(safetyNumber), "")
+	return normalized == current.SafetyNumber, nil
+}
+
+// TrustE2EEIdentity approves the changed identity keys of a contact that were rejected by
+// the strict trust policy. The old keys and sessions are removed, so the next message
+// establishes a new session with the current keys.
+func (c *Client) TrustE2EEIdentity(ctx context.Context, contactID int64) error {
+	if c.E2EE == nil || c.identityStore == nil {
+		return ErrE2EENotConnected
+	}
+	ps := c.identityStore
+	ps.mu.Lock()
+	var addresses []string
+	for address := range ps.rejected {
+		if userID, _ := parseSignalAddress(address); userID == contactID {
+			addresses = append(addresses, address)
+			delete(ps.rejected, address)
+		}
+	}
+	ps.mu.Unlock()
+	if len(addresses) == 0 {
+		return fmt.Errorf("no rejected identity keys for %d", contactID)
+	}
+	for _, address := range addresses {
+		// Delete through the inner store so the approval isn't reported as another change
+		if err := ps.IdentityStore.DeleteIdentity(ctx, address); err != nil {
+			return fmt.Errorf("failed to delete identity: %w", err)
+		}
+		if err := c.E2EE.Store.Sessions.DeleteSession(ctx, address); err != nil {
+			return fmt.Errorf("failed to delete session: %w", err)
+		}
+	}
+	return nil
+}
+
+// ParseTrustPolicy validates a trust policy name, empty means the default
+func ParseTrustPolicy(name string) (TrustPolicy, error) {
+	switch TrustPolicy(name) {
+	case "":
+		return TrustPolicyTOFU, nil
+	case TrustPolicyTOFU, TrustPolicyAlways, TrustPolicyStrict:
+		return TrustPolicy(name), nil
+	default:
+		return "", fmt.Errorf("invalid trust policy %q", name)
+	}
+}
+
+var _ store.IdentityStore = (*policyIdentityStore)(nil)
@@ -0,0 +1,335 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"go.mau.fi/mautrix-meta/pkg/messagix/data/responses"
+)
+
+// Instagram media types
+const (
+	InstagramMediaTypeImage    = "image"
+	InstagramMediaTypeVideo    = "video"
+	InstagramMediaTypeCarousel = "carousel"
+)
+
+// InstagramUser is the author of Instagram media
+type InstagramUser struct {
+	ID            string `json:"id"`
+	Username      string `json:"username"`
+	FullName      string `json:"fullName,omitempty"`
+	ProfilePicURL string `json:"profilePicUrl,omitempty"`
+	IsVerified    bool   `json:"isVerified,omitempty"`
+	IsPrivate     bool   `json:"isPrivate,omitempty"`
+}
+
+// InstagramMediaItem is a resolved Instagram post, reel or story item
+type InstagramMediaItem struct {
+	ID           string                `json:"id"`
+	Shortcode    string                `json:"shortcode,omitempty"`
+	Type         string                `json:"type"`                   // "image", "video", "carousel"
+	URL          string                `json:"url,omitempty"`          // best quality video, or image if there is no video
+	ThumbnailURL string                `json:"thumbnailUrl,omitempty"` // best quality image
+	Width        int                   `json:"width,omitempty"`
+	Height       int                   `json:"height,omitempty"`
+	Duration     float64               `json:"duration,omitempty"` // in seconds for videos
+	Caption      string                `json:"caption,omitempty"`
+	Author       *InstagramUser        `json:"author,omitempty"`
+	TakenAtMs    int64                 `json:"takenAtMs,omitempty"`
+	Items        []*InstagramMediaItem `json:"items,omitempty"`    // carousel children
+	FilePath     string                `json:"filePath,omitempty"` // set when downloaded
+}
+
+// InstagramReel is a story reel or highlight
+type InstagramReel struct {
+	ID     string                `json:"id"`
+	Title  string                `json:"title,omitempty"`
+	Author *InstagramUser        `json:"author,omitempty"`
+	Items  []*InstagramMediaItem `json:"items"`
+}
+
+// InstagramProfile is the public profile of an Instagram user
+type InstagramProfile struct {
+	InstagramUser
+	FBID           string `json:"fbid,omitempty"`
+	Biography      string `json:"biography,omitempty"`
+	FollowerCount  int    `json:"followerCount"`
+	FollowingCount int    `json:"followingCount"`
+	PostCount      int    `json:"postCount"`
+	IsBusiness     bool   `json:"isBusiness,omitempty"`
+}
+
+// FetchInstagramMediaOptions for resolving a post or reel.
+// Either MediaID or Shortcode (from instagram.com/p/<shortcode>) must be set.
+type FetchInstagramMediaOptions struct {
+	MediaID     string `json:"mediaId,omitempty"`
+	Shortcode   string `json:"shortcode,omitempty"`
+	DownloadDir string `json:"downloadDir,omitempty"` // downloads the media files into this directory when set
+}
+
+// FetchInstagramReelOptions for resolving stories and highlights.
+// Highlight IDs have the format "highlight:<id>".
+type FetchInstagramReelOptions struct {
+	ReelIDs     []string `json:"reelIds"`
+	MediaID     string   `json:"mediaId,omitempty"`
+	DownloadDir string   `json:"downloadDir,omitempty"`
+}
+
+const instagramShortcodeAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// instagramShortcodeToMediaID decodes the media ID a shortcode is derived from
+func instagramShortcodeToMediaID(shortcode string) (string, error) {
+	// Private posts append extra characters after the 11 characters of the ID
+	if len(shortcode) > 11 {
+		shortcode = shortcode[:11]
+	}
+	id := new(big.Int)
+	for _, ch := range shortcode {
+		idx := strings.IndexRune(instagramShortcodeAlphabet, ch)
+		if idx < 0 {
+			return "", fmt.Errorf("invalid instagram shortcode: %s", shortcode)
+		}
+		id.Lsh(id, 6).Or(id, big.NewInt(int64(idx)))
+	}
+	return id.String(), nil
+}
+
+// bestInstagramVideo returns the highest resolution video version
+func bestInstagramVideo(item *responses.Items) (best responses.VideoVersions) {
+	for _, ver := range item.VideoVersions {
+		if ver.Width*ver.Height > best.Width*best.Height {
+			best = ver
+		}
+	}
+	return
+}
+
+// bestInstagramImage returns the highest resolution image candidate
+func bestInstagramImage(item *responses.Items) (best responses.Candidates) {
+	for _, ver := range item.ImageVersions2.Candidates {
+		if ver.Width*ver.Height > best.Width*best.Height {
+			best = ver
+		}
+	}
+	return
+}
+
+func convertInstagramUser(user *responses.User) *InstagramUser {
+	if user == nil || (user.Pk == "" && user.Username == "") {
+		return nil
+	}
+	id := user.Pk
+	if id == "" {
+		id = user.ID
+	}
+	profilePic := user.ProfilePicURL
+	if user.HdProfilePicURLInfo.URL != "" {
+		profilePic = user.HdProfilePicURLInfo.URL
+	}
+	return &InstagramUser{
+		ID:            id,
+		Username:      user.Username,
+		FullName:      user.FullName,
+		ProfilePicURL: profilePic,
+		IsVerified:    user.IsVerified,
+		IsPrivate:     user.IsPrivate,
+	}
+}
+
+func convertInstagramMedia(item *responses.Items) *InstagramMediaItem {
+	media := &InstagramMediaItem{
+		ID:        item.Pk,
+		Shortcode: item.Code,
+		Type:      InstagramMediaTypeImage,
+		Caption:   item.Caption.Text,
+		Author:    convertInstagramUser(&item.User),
+		TakenAtMs: int64(item.TakenAt) * 1000,
+		Width:     item.OriginalWidth,
+		Height:    item.OriginalHeight,
+	}
+	if media.ID == "" {
+		media.ID = item.ID
+	}
+	image := bestInstagramImage(item)
+	media.URL = image.URL
+	media.ThumbnailURL = image.URL
+	if video := bestInstagramVideo(item); video.URL != "" {
+		media.Type = InstagramMediaTypeVideo
+		media.URL = video.URL
+		media.Duration = item.VideoDuration
+		if media.Width == 0 {
+			media.Width, media.Height = video.Width, video.Height
+		}
+	} else if media.Width == 0 {
+		media.Width, media.Height = image.Width, image.Height
+	}
+	if len(item.CarouselMedia) > 0 {
+		media.Type = InstagramMediaTypeCarousel
+		for _, child := range item.CarouselMedia {
+			media.Items = append(media.Items, convertInstagramMedia(child))
+		}
+	}
+	return media
+}
+
+// downloadInstagramMedia saves the media (or all carousel items) into dir
+func (c *Client) downloadInstagramMedia(ctx context.Context, media *InstagramMediaItem, dir string) error {
+	if media.Type == InstagramMediaTypeCarousel {
+		for _, child := range media.Items {
+			if err := c.downloadInstagramMedia(ctx, child, dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if media.URL == "" {
+		return fmt.Errorf("no URL for instagram media %s", media.ID)
+	}
+	// The ID comes from Meta's response and becomes the file name
+	if !isSafeFileName(media.ID) {
+		return fmt.Errorf("invalid instagram media ID %q", media.ID)
+	}
+	data, err := c.DownloadMedia(ctx, media.URL)
+	if err != nil {
+		return fmt.Errorf("failed to download instagram media %s: %w", media.ID, err)
+	}
+	ext := ".jpg"
+	if media.Type == InstagramMediaTypeVideo {
+		ext = ".mp4"
+	}
+	if parsed, err := url.Parse(media.URL); err == nil && isSafeFileName(path.Ext(parsed.Path)) {
+		ext = path.Ext(parsed.Path)
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	filePath := filepath.Join(dir, media.ID+ext)
+	if err = os.WriteFile(filePath, data, 0644); err != nil {
+		return err
+	}
+	media.FilePath = filePath
+	return nil
+}
+
+// isSafeFileName checks that name can't point outside the directory it's joined with
+func isSafeFileName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && !strings.Contains(name, "..")
+}
+
+// FetchInstagramMedia resolves a post or reel with its best quality URLs
+func (c *Client) FetchInstagramMedia(ctx context.Context, opts *FetchInstagramMediaOptions) (*InstagramMediaItem, error) {
+	if !c.Platform.IsInstagram() {
+		return nil, ErrNotInstagram
+	}
+	mediaID := opts.MediaID
+	if mediaID == "" {
+		if opts.Shortcode == "" {
+			return nil, fmt.Errorf("mediaId or shortcode is required")
+		}
+		var err error
+		if mediaID, err = instagramShortcodeToMediaID(opts.Shortcode); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.Messagix.Instagram.FetchMedia(ctx, mediaID, opts.Shortcode)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Items) == 0 {
+		return nil, fmt.Errorf("instagram media not found: %s", mediaID)
+	}
+	media := convertInstagramMedia(resp.Items[0])
+	if opts.DownloadDir != "" {
+		if err = c.downloadInstagramMedia(ctx, media, opts.DownloadDir); err != nil {
+			return nil, err
+		}
+	}
+	return media, nil
+}
+
+// FetchInstagramReel resolves story reels or highlights. If MediaID is set,
+// only that item is returned from each reel.
+func (c *Client) FetchInstagramReel(ctx context.Context, opts *FetchInstagramReelOptions) ([]*InstagramReel, error) {
+	if !c.Platform.IsInstagram() {
+		return nil, ErrNotInstagram
+	}
+	if len(opts.ReelIDs) == 0 {
+		return nil, fmt.Errorf("reelIds is required")
+	}
+	resp, err := c.Messagix.Instagram.FetchReel(ctx, opts.ReelIDs, opts.MediaID)
+	if err != nil {
+		return nil, err
+	}
+	reels := make([]*InstagramReel, 0, len(opts.ReelIDs))
+	for _, reelID := range opts.ReelIDs {
+		info, ok := resp.Reels[reelID]
+		if !ok {
+			continue
+		}
+		reel := &InstagramReel{
+			ID:     reelID,
+			Title:  info.Title,
+			Author: convertInstagramUser(info.User),
+			Items:  []*InstagramMediaItem{},
+		}
+		for _, item := range info.Items {
+			if opts.MediaID != "" && item.Pk != opts.MediaID {
+				continue
+			}
+			media := convertInstagramMedia(&item.Items)
+			if media.Author == nil {
+				media.Author = reel.Author
+			}
+			if opts.DownloadDir != "" {
+				if err = c.downloadInstagramMedia(ctx, media, opts.DownloadDir); err != nil {
+					return nil, err
+				}
+			}
+			reel.Items = append(reel.Items, media)
+		}
+		reels = append(reels, reel)
+	}
+	return reels, nil
+}
+
+// FetchInstagramProfile fetches the public profile of a user by username
+func (c *Client) FetchInstagramProfile(ctx context.Context, username string) (*InstagramProfile, error) {
+	if !c.Platform.IsInstagram() {
+		return nil, ErrNotInstagram
+	}
+	resp, err := c.Messagix.Instagram.FetchProfile(ctx, strings.TrimPrefix(username, "@"))
+	if err != nil {
+		return nil, err
+	}
+	user := resp.Data.User
+	if user.ID == "" {
+		return nil, fmt.Errorf("instagram user not found: %s", username)
+	}
+	profilePic := user.ProfilePicURLHd
+	if profilePic == "" {
+		profilePic = user.ProfilePicURL
+	}
+	return &InstagramProfile{
+		InstagramUser: InstagramUser{
+			ID:            user.ID,
+			Username:      user.Username,
+			FullName:      user.FullName,
+			ProfilePicURL: profilePic,
+			IsVerified:    user.IsVerified,
+			IsPrivate:     user.IsPrivate,
+		},
+		FBID:           user.Fbid,
+		Biography:      user.Biography,
+		FollowerCount:  user.EdgeFollowedBy.Count,
+		FollowingCount: user.EdgeFollow.Count,
+		PostCount:      user.EdgeOwnerToTimelineMedia.Count,
+		IsBusiness:     user.IsBusinessAccount,
+	}, nil
+}
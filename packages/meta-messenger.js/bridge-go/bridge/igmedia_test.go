@@ -0,0 +1,54 @@
+package bridge
+
+import "testing"
+
+func TestInstagramShortcodeToMediaID(t *testing.T) {
+	tests := []struct {
+		name      string
+		shortcode string
+		want      string
+		wantErr   bool
+	}{
+		{name: "first letter", shortcode: "A", want: "0"},
+		{name: "single digit", shortcode: "B", want: "1"},
+		{name: "two digits", shortcode: "BA", want: "64"},
+		{name: "url safe characters", shortcode: "C-_zzA", want: "3204398272"},
+		{name: "post", shortcode: "BfCXkCChLKf", want: "1712034439514862239"},
+		{name: "larger than int64", shortcode: "___________", want: "73786976294838206463"},
+		{name: "private post suffix", shortcode: "BfCXkCChLKfAbCdEfGhIjKlMnOpQrStUvWxYz", want: "1712034439514862239"},
+		{name: "invalid character", shortcode: "BfC+kCC", wantErr: true},
+		{name: "standard base64 padding", shortcode: "BfC=", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := instagramShortcodeToMediaID(tt.shortcode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("instagramShortcodeToMediaID(%q) error = %v, wantErr %v", tt.shortcode, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("instagramShortcodeToMediaID(%q) = %s, want %s", tt.shortcode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSafeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "3204398272_1", want: true},
+		{name: ".jpg", want: true},
+		{name: "", want: false},
+		{name: "..", want: false},
+		{name: "../evil", want: false},
+		{name: "a/b", want: false},
+		{name: `a\b`, want: false},
+		{name: `.\..\evil`, want: false},
+	}
+	for _, tt := range tests {
+		if got := isSafeFileName(tt.name); got != tt.want {
+			t.Errorf("isSafeFileName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
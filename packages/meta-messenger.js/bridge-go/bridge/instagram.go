@@ -0,0 +1,136 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"go.mau.fi/mautrix-meta/pkg/messagix/dgw"
+	"go.mau.fi/mautrix-meta/pkg/messagix/table"
+)
+
+// ErrNotInstagram error when an Instagram-only method is used on another platform
+var ErrNotInstagram = fmt.Errorf("only available on instagram")
+
+// igIDMap maps Instagram thread and user IDs to the FBIDs used by LightSpeed
+type igIDMap struct {
+	mu             sync.RWMutex
+	threads        map[string]int64 // key: IG thread ID
+	threadsReverse map[int64]string
+	users          map[string]int64 // key: IG user ID
+	usersReverse   map[int64]string
+}
+
+func newIGIDMap() *igIDMap {
+	return &igIDMap{
+		threads:        make(map[string]int64),
+		threadsReverse: make(map[int64]string),
+		users:          make(map[string]int64),
+		usersReverse:   make(map[int64]string),
+	}
+}
+
+func (m *igIDMap) putThread(igid string, fbid int64) {
+	if igid == "" || fbid == 0 {
+		return
+	}
+	m.mu.Lock()
+	m.threads[igid] = fbid
+	m.threadsReverse[fbid] = igid
+	m.mu.Unlock()
+}
+
+func (m *igIDMap) putUser(igid string, fbid int64) {
+	if igid == "" || fbid == 0 {
+		return
+	}
+	m.mu.Lock()
+	m.users[igid] = fbid
+	m.usersReverse[fbid] = igid
+	m.mu.Unlock()
+}
+
+func (m *igIDMap) threadFBID(igid string) int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.threads[igid]
+}
+
+func (m *igIDMap) threadIGID(fbid int64) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.threadsReverse[fbid]
+}
+
+func (m *igIDMap) userFBID(igid string) int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.users[igid]
+}
+
+func (m *igIDMap) userIGID(fbid int64) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.usersReverse[fbid]
+}
+
+// recordIGIDs saves the IG <-> FB ID mappings contained in a table
+func (c *Client) recordIGIDs(tbl *table.LSTable) {
+	if tbl == nil {
+		return
+	}
+	for _, info := range tbl.LSDeleteThenInsertIgThreadInfo {
+		c.igIDs.putThread(info.IgThreadId, info.ThreadKey)
+	}
+	for _, info := range tbl.LSDeleteThenInsertIGContactInfo {
+		c.igIDs.putUser(info.IgId, info.ContactId)
+	}
+}
+
+// handleDGWEvent handles events from the Instagram DGW socket
+func (c *Client) handleDGWEvent(e *dgw.DGWEvent) {
+	switch evt := e.Event.(type) {
+	case dgw.DGWTypingActivityIndicator:
+		igUserID := strconv.FormatInt(evt.InstagramUserID, 10)
+		threadID := c.igIDs.threadFBID(evt.InstagramThreadID)
+		senderID := c.igIDs.userFBID(igUserID)
+		if threadID == 0 {
+			c.Logger.Debug().Str("ig_thread_id", evt.InstagramThreadID).Msg("Got typing indicator for thread with unknown FBID")
+		}
+		c.emitEvent(EventTypeTyping, &TypingEvent{
+			ThreadID:   threadID,
+			SenderID:   senderID,
+			IsTyping:   evt.IsTyping,
+			IGThreadID: evt.InstagramThreadID,
+			IGSenderID: igUserID,
+		})
+	}
+}
+
+// InstagramIDs holds both forms of an Instagram thread or user ID
+type InstagramIDs struct {
+	ThreadID   int64  `json:"threadId,omitempty"`
+	IGThreadID string `json:"igThreadId,omitempty"`
+	UserID     int64  `json:"userId,omitempty"`
+	IGUserID   string `json:"igUserId,omitempty"`
+}
+
+// ResolveInstagramIDs fills in the missing form of the given thread and user IDs.
+// Only IDs seen in synced threads, contacts or user lookups can be resolved.
+func (c *Client) ResolveInstagramIDs(ids *InstagramIDs) (*InstagramIDs, error) {
+	if !c.Platform.IsInstagram() {
+		return nil, ErrNotInstagram
+	}
+	result := *ids
+	if result.ThreadID == 0 && result.IGThreadID != "" {
+		result.ThreadID = c.igIDs.threadFBID(result.IGThreadID)
+	} else if result.IGThreadID == "" && result.ThreadID != 0 {
+		result.IGThreadID = c.igIDs.threadIGID(result.ThreadID)
+	}
+	if result.UserID == 0 && result.IGUserID != "" {
+		result.UserID = c.igIDs.userFBID(result.IGUserID)
+	} else if result.IGUserID == "" && result.UserID != 0 {
+		result.IGUserID = c.igIDs.userIGID(result.UserID)
+	}
+	return &result, nil
+}
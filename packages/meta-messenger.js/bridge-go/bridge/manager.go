@@ -0,0 +1,471 @@
+package bridge
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mau.fi/util/exhttp"
+)
+
+const (
+	// Default size of the media cache shared by all accounts
+	defaultMediaCacheBytes = 64 << 20
+	// Media larger than this fraction of the cache isn't cached
+	mediaCacheMaxItemFraction = 4
+	// Default size of the multiplexed event queue
+	defaultManagerEventBuffer = 1000
+	// Idle connections kept per host by the shared transport
+	sharedTransportIdleConnsPerHost = 32
+)
+
+// ErrTooManyClients error when the manager's account limit is reached
+var ErrTooManyClients = fmt.Errorf("too many clients")
+
+// ManagerConfig configures the resources and limits shared by all accounts
+type ManagerConfig struct {
+	MaxClients      int   `json:"maxClients,omitempty"`      // 0 = unlimited
+	MediaCacheBytes int64 `json:"mediaCacheBytes,omitempty"` // 0 = 64 MiB, -1 disables the cache
+	// When set, events of all clients are delivered through PollEvents with
+	// their handle attached instead of each client's own queue
+	MultiplexEvents bool `json:"multiplexEvents,omitempty"`
+	EventBufferSize int  `json:"eventBufferSize,omitempty"` // size of the multiplexed queue, default 1000, fixed once clients exist
+	// Per-account rate limits for clients created without their own rateLimit
+	RateLimit *RateLimitConfig `json:"rateLimit,omitempty"`
+	Metrics   bool             `json:"metrics,omitempty"` // enables metrics for all clients created afterwards
+}
+
+// ManagedEvent is an event of one of the manager's clients
+type ManagedEvent struct {
+	Handle uint64 `json:"handle"`
+	*Event
+}
+
+// ClientInfo describes a managed client
+type ClientInfo struct {
+	Handle        uint64 `json:"handle"`
+	Platform      string `json:"platform"`
+	UserID        int64  `json:"userId,omitempty"` // set once connected
+	Connected     bool   `json:"connected"`
+	E2EEConnected bool   `json:"e2eeConnected"`
+	CreatedAtMs   int64  `json:"createdAtMs"`
+	QueuedEvents  int    `json:"queuedEvents"` // events waiting in the client's own queue
+}
+
+// ClientManager owns a set of clients and the HTTP transport, media cache and
+// event queue they share
+type ClientManager struct {
+	mu        sync.RWMutex
+	cfg       ManagerConfig
+	clients   map[uint64]*Client
+	createdAt map[uint64]int64
+	nextID    atomic.Uint64
+
+	transport *http.Transport
+	http      *http.Client
+	media     *mediaCache
+
+	events        chan *ManagedEvent
+	eventHandler  atomic.Pointer[func(*ManagedEvent)]
+	handlerEvents chan *ManagedEvent // events waiting for the event handler
+	handlerOnce   sync.Once
+
+	metricsServer *http.Server
+	calls         *callRegistry
+}
+
+// NewClientManager creates a manager with the given config (nil for defaults)
+func NewClientManager(cfg *ManagerConfig) *ClientManager {
+	transport := exhttp.ClientSettings{}.Configure(&http.Transport{})
+	transport.MaxIdleConnsPerHost = sharedTransportIdleConnsPerHost
+	m := &ClientManager{
+		clients:   make(map[uint64]*Client),
+		createdAt: make(map[uint64]int64),
+		transport: transport,
+		http:      &http.Client{Transport: transport, Timeout: 5 * time.Minute},
+		calls:     newCallRegistry(),
+	}
+	if cfg == nil {
+		cfg = &ManagerConfig{}
+	}
+	if err := m.Configure(cfg); err != nil {
+		// Fall back to the defaults if the config is invalid
+		_ = m.Configure(&ManagerConfig{})
+	}
+	return m
+}
+
+// Configure changes the manager's config. Limits apply to clients created
+// afterwards, the event queue and media cache are replaced if their size changes.
+func (m *ClientManager) Configure(cfg *ManagerConfig) error {
+	if cfg.RateLimit != nil {
+		if err := cfg.RateLimit.Validate(); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bufferSize := cfg.EventBufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultManagerEventBuffer
+	}
+	if m.events == nil || cap(m.events) != bufferSize {
+		// Clients send to the queue without holding the lock, so it's only replaced without them
+		if len(m.clients) > 0 {
+			return fmt.Errorf("eventBufferSize can't be changed while clients exist")
+		}
+		if len(m.events) > bufferSize {
+			return fmt.Errorf("%d queued events don't fit in eventBufferSize %d", len(m.events), bufferSize)
+		}
+		events := make(chan *ManagedEvent, bufferSize)
+		// Keep the events of removed clients that weren't polled yet
+		for len(m.events) > 0 {
+			events <- <-m.events
+		}
+		m.events = events
+	}
+	cacheBytes := cfg.MediaCacheBytes
+	if cacheBytes == 0 {
+		cacheBytes = defaultMediaCacheBytes
+	}
+	if cacheBytes < 0 {
+		m.media = nil
+	} else if m.media == nil || m.media.maxBytes != cacheBytes {
+		m.media = newMediaCache(cacheBytes)
+	}
+	m.cfg = *cfg
+	return nil
+}
+
+// httpSettings makes messagix clients use the shared transport unless they need a proxy
+func (m *ClientManager) httpSettings() exhttp.ClientSettings {
+	return exhttp.ClientSettings{
+		TransportOverride: func(cs exhttp.ClientSettings) http.RoundTripper {
+			if cs.ProxyAddress != "" {
+				return cs.Configure(&http.Transport{})
+			}
+			return m.transport
+		},
+	}
+}
+
+// mediaResources returns the shared HTTP client and media cache (nil if disabled)
+func (m *ClientManager) mediaResources() (*http.Client, *mediaCache) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.http, m.media
+}
+
+// NewClient creates a client that uses the manager's shared resources
+func (m *ClientManager) NewClient(cfg *ClientConfig) (*Client, error) {
+	m.mu.RLock()
+	maxClients := m.cfg.MaxClients
+	count := len(m.clients)
+	defaultRateLimit := m.cfg.RateLimit
+	metrics := m.cfg.Metrics
+	m.mu.RUnlock()
+	if maxClients > 0 && count >= maxClients {
+		return nil, fmt.Errorf("%w: limit is %d", ErrTooManyClients, maxClients)
+	}
+	if (cfg.RateLimit == nil && defaultRateLimit != nil) || (metrics && !cfg.Metrics) {
+		cp := *cfg
+		if cp.RateLimit == nil {
+			cp.RateLimit = defaultRateLimit
+		}
+		cp.Metrics = cp.Metrics || metrics
+		cfg = &cp
+	}
+
+	client, err := newClient(cfg, m)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Checked again in case other clients were added while this one was created
+	if m.cfg.MaxClients > 0 && len(m.clients) >= m.cfg.MaxClients {
+		client.Disconnect()
+		return nil, fmt.Errorf("%w: limit is %d", ErrTooManyClients, m.cfg.MaxClients)
+	}
+	client.ID = m.nextID.Add(1)
+	m.clients[client.ID] = client
+	m.createdAt[client.ID] = time.Now().UnixMilli()
+	return client, nil
+}
+
+// Get returns the client with the given handle, or nil
+func (m *ClientManager) Get(handle uint64) *Client {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.clients[handle]
+}
+
+// Remove disconnects and forgets a client. Returns false if the handle is unknown.
+func (m *ClientManager) Remove(handle uint64) bool {
+	m.mu.Lock()
+	client := m.clients[handle]
+	delete(m.clients, handle)
+	delete(m.createdAt, handle)
+	m.mu.Unlock()
+	if client == nil {
+		return false
+	}
+	client.Disconnect()
+	return true
+}
+
+// ClientInfo describes the client with the given handle
+func (m *ClientManager) ClientInfo(handle uint64) (*ClientInfo, error) {
+	m.mu.RLock()
+	client := m.clients[handle]
+	createdAt := m.createdAt[handle]
+	m.mu.RUnlock()
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+	return &ClientInfo{
+		Handle:        handle,
+		Platform:      client.Platform.String(),
+		UserID:        client.FBID,
+		Connected:     client.Messagix != nil && client.Messagix.IsConnected(),
+		E2EEConnected: client.IsE2EEConnected(),
+		CreatedAtMs:   createdAt,
+		QueuedEvents:  len(client.eventChan),
+	}, nil
+}
+
+// ListClients describes all clients, ordered by handle
+func (m *ClientManager) ListClients() []*ClientInfo {
+	m.mu.RLock()
+	handles := make([]uint64, 0, len(m.clients))
+	for handle := range m.clients {
+		handles = append(handles, handle)
+	}
+	m.mu.RUnlock()
+	sort.Slice(handles, func(i, j int) bool { return handles[i] < handles[j] })
+	infos := make([]*ClientInfo, 0, len(handles))
+	for _, handle := range handles {
+		// The client may have been removed in the meantime
+		if info, err := m.ClientInfo(handle); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// Metrics returns the metrics of all clients that have them enabled, ordered by handle
+func (m *ClientManager) Metrics() []*MetricsSnapshot {
+	m.mu.RLock()
+	clients := make([]*Client, 0, len(m.clients))
+	for _, client := range m.clients {
+		clients = append(clients, client)
+	}
+	m.mu.RUnlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	snaps := make([]*MetricsSnapshot, 0, len(clients))
+	for _, client := range clients {
+		if snap := client.Metrics(); snap != nil {
+			snaps = append(snaps, snap)
+		}
+	}
+	return snaps
+}
+
+// ServeMetrics serves the metrics of all clients in the Prometheus text format on
+// addr (default 127.0.0.1:0) and returns the address that is listened on.
+// A server that is already running is stopped first.
+func (m *ClientManager) ServeMetrics(addr string) (string, error) {
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	m.StopMetrics()
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writePrometheus(w, m.Metrics())
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	m.mu.Lock()
+	m.metricsServer = server
+	m.mu.Unlock()
+	go server.Serve(listener)
+	return listener.Addr().String(), nil
+}
+
+// StopMetrics stops the metrics server, if one is running
+func (m *ClientManager) StopMetrics() {
+	m.mu.Lock()
+	server := m.metricsServer
+	m.metricsServer = nil
+	m.mu.Unlock()
+	if server != nil {
+		server.Close()
+	}
+}
+
+// SetEventHandler makes multiplexed events go to fn instead of the queue read by PollEvents.
+// fn is called on its own goroutine, one event at a time, so a slow handler never blocks
+// the clients. Events are buffered like in the queue and dropped once the buffer is full,
+// except request results, which wait for room.
+func (m *ClientManager) SetEventHandler(fn func(*ManagedEvent)) {
+	if fn == nil {
+		m.eventHandler.Store(nil)
+		return
+	}
+	m.handlerOnce.Do(func() {
+		m.mu.RLock()
+		m.handlerEvents = make(chan *ManagedEvent, cap(m.events))
+		m.mu.RUnlock()
+		go m.runEventHandler()
+	})
+	m.eventHandler.Store(&fn)
+}
+
+// runEventHandler passes buffered events to the event handler. Once the handler is
+// removed, the remaining events go to the queue read by PollEvents.
+func (m *ClientManager) runEventHandler() {
+	for evt := range m.handlerEvents {
+		if fn := m.eventHandler.Load(); fn != nil {
+			(*fn)(evt)
+			continue
+		}
+		m.mu.RLock()
+		events := m.events
+		c := m.clients[evt.Handle]
+		m.mu.RUnlock()
+		if evt.Type == EventTypeRequestCompleted || evt.Type == EventTypeRequestFailed {
+			events <- evt
+			continue
+		}
+		select {
+		case events <- evt:
+		default:
+			if c != nil {
+				c.multiplexedEventDropped(evt.Event)
+			}
+		}
+	}
+}
+
+// multiplexedEventDropped counts and logs an event that didn't fit in the manager's buffer
+func (c *Client) multiplexedEventDropped(evt *Event) {
+	c.metrics.eventDropped()
+	c.Logger.Warn().Str("type", string(evt.Type)).Uint64("handle", c.ID).Msg("Multiplexed event queue full, dropping event")
+}
+
+// PollEvents waits up to timeout for the next multiplexed event. Returns nil on timeout.
+func (m *ClientManager) PollEvents(timeout time.Duration) *ManagedEvent {
+	m.mu.RLock()
+	events := m.events
+	m.mu.RUnlock()
+	if timeout <= 0 {
+		select {
+		case evt := <-events:
+			return evt
+		default:
+			return nil
+		}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case evt := <-events:
+		return evt
+	case <-timer.C:
+		return nil
+	}
+}
+
+// dispatch delivers an event of a client if events are multiplexed. With wait set,
+// a full queue is waited on until the client disconnects instead of dropping the event.
+// Returns false if the client should queue the event itself.
+func (m *ClientManager) dispatch(c *Client, evt *Event, wait bool) bool {
+	m.mu.RLock()
+	multiplex := m.cfg.MultiplexEvents
+	events := m.events
+	m.mu.RUnlock()
+	if !multiplex {
+		return false
+	}
+	managed := &ManagedEvent{Handle: c.ID, Event: evt}
+	if m.eventHandler.Load() != nil {
+		events = m.handlerEvents
+	}
+	if wait {
+		if sendWait(c, events, managed) {
+			return true
+		}
+	} else {
+		select {
+		case events <- managed:
+			return true
+		default:
+		}
+	}
+	c.multiplexedEventDropped(evt)
+	return true
+}
+
+// mediaCache is a size-limited LRU cache of downloaded media, keyed by URL
+type mediaCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	items    map[string]*list.Element
+	order    *list.List // front = most recently used
+}
+
+type mediaCacheItem struct {
+	url  string
+	data []byte
+}
+
+func newMediaCache(maxBytes int64) *mediaCache {
+	return &mediaCache{
+		maxBytes: maxBytes,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (mc *mediaCache) get(url string) ([]byte, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	elem, ok := mc.items[url]
+	if !ok {
+		return nil, false
+	}
+	mc.order.MoveToFront(elem)
+	return elem.Value.(*mediaCacheItem).data, true
+}
+
+func (mc *mediaCache) put(url string, data []byte) {
+	size := int64(len(data))
+	if size > mc.maxBytes/mediaCacheMaxItemFraction {
+		return
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if _, ok := mc.items[url]; ok {
+		return
+	}
+	mc.items[url] = mc.order.PushFront(&mediaCacheItem{url: url, data: data})
+	mc.size += size
+	for mc.size > mc.maxBytes {
+		oldest := mc.order.Back()
+		item := oldest.Value.(*mediaCacheItem)
+		mc.order.Remove(oldest)
+		delete(mc.items, item.url)
+		mc.size -= int64(len(item.data))
+	}
+}
@@ -0,0 +1,175 @@
+package bridge
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestMediaCache(t *testing.T) {
+	item := func(size int) []byte {
+		return bytes.Repeat([]byte{1}, size)
+	}
+	tests := []struct {
+		name     string
+		maxBytes int64
+		put      []string // URLs put in order, each 10 bytes
+		get      []string // URLs read after the puts
+		thenPut  []string
+		want     []string // URLs still cached
+		wantGone []string
+		wantSize int64
+	}{
+		{
+			name:     "under limit",
+			maxBytes: 40,
+			put:      []string{"a", "b", "c"},
+			want:     []string{"a", "b", "c"},
+			wantSize: 30,
+		},
+		{
+			name:     "evicts oldest",
+			maxBytes: 40,
+			put:      []string{"a", "b", "c", "d", "e"},
+			want:     []string{"b", "c", "d", "e"},
+			wantGone: []string{"a"},
+			wantSize: 40,
+		},
+		{
+			name:     "get refreshes entry",
+			maxBytes: 40,
+			put:      []string{"a", "b", "c", "d"},
+			get:      []string{"a"},
+			thenPut:  []string{"e"},
+			want:     []string{"a", "c", "d", "e"},
+			wantGone: []string{"b"},
+			wantSize: 40,
+		},
+		{
+			name:     "duplicate put is ignored",
+			maxBytes: 40,
+			put:      []string{"a", "a", "b"},
+			want:     []string{"a", "b"},
+			wantSize: 20,
+		},
+		{
+			name:     "item larger than a quarter is not cached",
+			maxBytes: 39,
+			put:      []string{"a"},
+			wantGone: []string{"a"},
+			wantSize: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := newMediaCache(tt.maxBytes)
+			for _, url := range tt.put {
+				mc.put(url, item(10))
+			}
+			for _, url := range tt.get {
+				if _, ok := mc.get(url); !ok {
+					t.Fatalf("get(%q) missed before eviction", url)
+				}
+			}
+			for _, url := range tt.thenPut {
+				mc.put(url, item(10))
+			}
+			for _, url := range tt.want {
+				if data, ok := mc.get(url); !ok || len(data) != 10 {
+					t.Errorf("get(%q) = %d bytes, %v, want cached", url, len(data), ok)
+				}
+			}
+			for _, url := range tt.wantGone {
+				if _, ok := mc.get(url); ok {
+					t.Errorf("get(%q) hit, want evicted", url)
+				}
+			}
+			if mc.size != tt.wantSize {
+				t.Errorf("size = %d, want %d", mc.size, tt.wantSize)
+			}
+			if len(mc.items) != mc.order.Len() {
+				t.Errorf("%d items but %d in LRU order", len(mc.items), mc.order.Len())
+			}
+		})
+	}
+}
+
+func TestConfigureEventBuffer(t *testing.T) {
+	m := NewClientManager(&ManagerConfig{EventBufferSize: 4})
+	for i := range 3 {
+		m.events <- &ManagedEvent{Handle: uint64(i)}
+	}
+
+	if err := m.Configure(&ManagerConfig{EventBufferSize: 2}); err == nil {
+		t.Error("Configure() shrinking below the queued events succeeded, want error")
+	}
+	if err := m.Configure(&ManagerConfig{EventBufferSize: 8}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+	if cap(m.events) != 8 {
+		t.Errorf("buffer size = %d, want 8", cap(m.events))
+	}
+	for i := range 3 {
+		if evt := m.PollEvents(0); evt == nil || evt.Handle != uint64(i) {
+			t.Fatalf("PollEvents() = %+v, want event of handle %d", evt, i)
+		}
+	}
+
+	m.clients[1] = &Client{}
+	if err := m.Configure(&ManagerConfig{EventBufferSize: 16}); err == nil {
+		t.Error("Configure() resizing with clients succeeded, want error")
+	}
+	if err := m.Configure(&ManagerConfig{EventBufferSize: 8, MaxClients: 5}); err != nil {
+		t.Errorf("Configure() keeping the buffer size error = %v", err)
+	}
+}
+
+func TestEventHandlerBuffer(t *testing.T) {
+	m := NewClientManager(&ManagerConfig{MultiplexEvents: true, EventBufferSize: 1})
+	c := newTestCallClient(t)
+	c.ID = 1
+	c.Logger = zerolog.Nop()
+	c.metrics = NewMetrics()
+
+	release := make(chan struct{})
+	received := make(chan *ManagedEvent, 10)
+	m.SetEventHandler(func(evt *ManagedEvent) {
+		<-release
+		received <- evt
+	})
+
+	// Once the handler is blocked on the first event, the next one fills the buffer
+	deadline := time.Now().Add(time.Second)
+	for len(m.handlerEvents) == 0 && time.Now().Before(deadline) {
+		m.dispatch(c, &Event{Type: EventTypeReady}, false)
+	}
+	m.dispatch(c, &Event{Type: EventTypeReady}, false)
+	if dropped := c.metrics.eventsDropped.Load(); dropped == 0 {
+		t.Error("events beyond the buffer weren't counted as dropped")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.dispatch(c, &Event{Type: EventTypeRequestCompleted}, true)
+		close(done)
+	}()
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("request event wasn't delivered")
+	}
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case evt := <-received:
+			if evt.Type == EventTypeRequestCompleted {
+				return
+			}
+		case <-timeout:
+			t.Fatal("handler didn't receive the request event")
+		}
+	}
+}
@@ -29,25 +29,36 @@ type UploadMediaOptions struct {
 	MimeType string `json:"mimeType"`
 	Data     []byte `json:"data"`
 	IsVoice  bool   `json:"isVoice"`
+	Probe    bool   `json:"probe"` // probe dimensions and duration into the result
 }
 
 // UploadMediaResult result of uploading media
 type UploadMediaResult struct {
-	FbID     int64  `json:"fbId"`
-	Filename string `json:"filename"`
+	FbID     int64      `json:"fbId"`
+	Filename string     `json:"filename"`
+	Info     *MediaInfo `json:"info,omitempty"` // locally probed dimensions and duration, if requested
 }
 
 // UploadMedia uploads media to Messenger
-func (c *Client) UploadMedia(opts *UploadMediaOptions) (*UploadMediaResult, error) {
+func (c *Client) UploadMedia(ctx context.Context, opts *UploadMediaOptions) (*UploadMediaResult, error) {
 	media := &messagix.MercuryUploadMedia{
 		Filename:    opts.Filename,
 		MimeType:    opts.MimeType,
 		MediaData:   opts.Data,
 		IsVoiceClip: opts.IsVoice,
 	}
+	if opts.IsVoice {
+		media.WaveformData = c.mercuryWaveform(ctx, opts.Data, opts.MimeType)
+	}
 
-	resp, err := c.Messagix.SendMercuryUploadRequest(c.ctx, opts.ThreadID, media)
+	if err := c.throttle(ctx, RateLimitActionUpload, strconv.FormatInt(opts.ThreadID, 10)); err != nil {
+		c.metrics.sendFailed(RateLimitActionUpload)
+		return nil, err
+	}
+	c.metrics.observeUpload("mercury", len(opts.Data))
+	resp, err := c.Messagix.SendMercuryUploadRequest(ctx, opts.ThreadID, media)
 	if err != nil {
+		c.metrics.sendFailed(RateLimitActionUpload)
 		return nil, err
 	}
 
@@ -56,10 +67,14 @@ func (c *Client) UploadMedia(opts *UploadMediaOptions) (*UploadMediaResult, erro
 		fbid = resp.Payload.RealMetadata.GetFbId()
 	}
 
-	return &UploadMediaResult{
+	result := &UploadMediaResult{
 		FbID:     fbid,
 		Filename: opts.Filename,
-	}, nil
+	}
+	if opts.Probe {
+		result.Info = c.probeMedia(ctx, opts.Data, opts.MimeType, false)
+	}
+	return result, nil
 }
 
 // SendMediaOptions for sending media
@@ -71,8 +86,8 @@ type SendMediaOptions struct {
 }
 
 // SendMedia sends media that has been uploaded
-func (c *Client) SendMedia(opts *SendMediaOptions) (*SendMessageResult, error) {
-	return c.SendMessage(&SendMessageOptions{
+func (c *Client) SendMedia(ctx context.Context, opts *SendMediaOptions) (*SendMessageResult, error) {
+	return c.SendMessage(ctx, &SendMessageOptions{
 		ThreadID:        opts.ThreadID,
 		Text:            opts.Caption,
 		AttachmentFbIds: opts.MediaFbIds,
@@ -88,8 +103,8 @@ type SendStickerOptions struct {
 }
 
 // SendSticker sends a sticker
-func (c *Client) SendSticker(opts *SendStickerOptions) (*SendMessageResult, error) {
-	return c.SendMessage(&SendMessageOptions{
+func (c *Client) SendSticker(ctx context.Context, opts *SendStickerOptions) (*SendMessageResult, error) {
+	return c.SendMessage(ctx, &SendMessageOptions{
 		ThreadID:  opts.ThreadID,
 		StickerID: opts.StickerID,
 		ReplyToID: opts.ReplyToID,
@@ -106,7 +121,7 @@ type SendImageOptions struct {
 }
 
 // SendImage sends an image
-func (c *Client) SendImage(opts *SendImageOptions) (*SendMessageResult, error) {
+func (c *Client) SendImage(ctx context.Context, opts *SendImageOptions) (*SendMessageResult, error) {
 	mimeType := "image/jpeg"
 	if strings.HasSuffix(strings.ToLower(opts.Filename), ".png") {
 		mimeType = "image/png"
@@ -116,7 +131,7 @@ func (c *Client) SendImage(opts *SendImageOptions) (*SendMessageResult, error) {
 		mimeType = "image/webp"
 	}
 
-	uploadResult, err := c.UploadMedia(&UploadMediaOptions{
+	uploadResult, err := c.UploadMedia(ctx, &UploadMediaOptions{
 		ThreadID: opts.ThreadID,
 		Filename: opts.Filename,
 		MimeType: mimeType,
@@ -127,7 +142,7 @@ func (c *Client) SendImage(opts *SendImageOptions) (*SendMessageResult, error) {
 		return nil, err
 	}
 
-	return c.SendMedia(&SendMediaOptions{
+	return c.SendMedia(ctx, &SendMediaOptions{
 		ThreadID:   opts.ThreadID,
 		MediaFbIds: []int64{uploadResult.FbID},
 		Caption:    opts.Caption,
@@ -145,8 +160,8 @@ type SendVideoOptions struct {
 }
 
 // SendVideo sends a video
-func (c *Client) SendVideo(opts *SendVideoOptions) (*SendMessageResult, error) {
-	uploadResult, err := c.UploadMedia(&UploadMediaOptions{
+func (c *Client) SendVideo(ctx context.Context, opts *SendVideoOptions) (*SendMessageResult, error) {
+	uploadResult, err := c.UploadMedia(ctx, &UploadMediaOptions{
 		ThreadID: opts.ThreadID,
 		Filename: opts.Filename,
 		MimeType: "video/mp4",
@@ -157,7 +172,7 @@ func (c *Client) SendVideo(opts *SendVideoOptions) (*SendMessageResult, error) {
 		return nil, err
 	}
 
-	return c.SendMedia(&SendMediaOptions{
+	return c.SendMedia(ctx, &SendMediaOptions{
 		ThreadID:   opts.ThreadID,
 		MediaFbIds: []int64{uploadResult.FbID},
 		Caption:    opts.Caption,
@@ -170,23 +185,39 @@ type SendVoiceOptions struct {
 	ThreadID  int64  `json:"threadId"`
 	Data      []byte `json:"data"`
 	Filename  string `json:"filename"`
+	MimeType  string `json:"mimeType,omitempty"`  // defaults to audio/mpeg
+	Transcode bool   `json:"transcode,omitempty"` // convert to MP4/AAC with ffmpeg if needed
 	ReplyToID string `json:"replyToId,omitempty"`
 }
 
 // SendVoice sends a voice message
-func (c *Client) SendVoice(opts *SendVoiceOptions) (*SendMessageResult, error) {
-	uploadResult, err := c.UploadMedia(&UploadMediaOptions{
+func (c *Client) SendVoice(ctx context.Context, opts *SendVoiceOptions) (*SendMessageResult, error) {
+	data, mimeType, filename := opts.Data, opts.MimeType, opts.Filename
+	if opts.Transcode {
+		var err error
+		data, mimeType, err = c.transcodeVoice(ctx, data, mimeType, mercuryVoiceFormat)
+		if err != nil {
+			return nil, err
+		}
+		if mimeType == mercuryVoiceMimeType {
+			filename = voiceFilename(filename, mercuryVoiceFormat)
+		}
+	} else if mimeType == "" {
+		mimeType = "audio/mpeg"
+	}
+
+	uploadResult, err := c.UploadMedia(ctx, &UploadMediaOptions{
 		ThreadID: opts.ThreadID,
-		Filename: opts.Filename,
-		MimeType: "audio/mpeg",
-		Data:     opts.Data,
+		Filename: filename,
+		MimeType: mimeType,
+		Data:     data,
 		IsVoice:  true,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return c.SendMedia(&SendMediaOptions{
+	return c.SendMedia(ctx, &SendMediaOptions{
 		ThreadID:   opts.ThreadID,
 		MediaFbIds: []int64{uploadResult.FbID},
 		ReplyToID:  opts.ReplyToID,
@@ -204,8 +235,8 @@ type SendFileOptions struct {
 }
 
 // SendFile sends a file
-func (c *Client) SendFile(opts *SendFileOptions) (*SendMessageResult, error) {
-	uploadResult, err := c.UploadMedia(&UploadMediaOptions{
+func (c *Client) SendFile(ctx context.Context, opts *SendFileOptions) (*SendMessageResult, error) {
+	uploadResult, err := c.UploadMedia(ctx, &UploadMediaOptions{
 		ThreadID: opts.ThreadID,
 		Filename: opts.Filename,
 		MimeType: opts.MimeType,
@@ -216,7 +247,7 @@ func (c *Client) SendFile(opts *SendFileOptions) (*SendMessageResult, error) {
 		return nil, err
 	}
 
-	return c.SendMedia(&SendMediaOptions{
+	return c.SendMedia(ctx, &SendMediaOptions{
 		ThreadID:   opts.ThreadID,
 		MediaFbIds: []int64{uploadResult.FbID},
 		Caption:    opts.Caption,
@@ -225,13 +256,37 @@ func (c *Client) SendFile(opts *SendFileOptions) (*SendMessageResult, error) {
 }
 
 // DownloadMedia downloads media from a URL
-func (c *Client) DownloadMedia(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+func (c *Client) DownloadMedia(ctx context.Context, url string) ([]byte, error) {
+	httpClient := http.DefaultClient
+	var cache *mediaCache
+	if c.manager != nil {
+		httpClient, cache = c.manager.mediaResources()
+	}
+	if cache != nil {
+		if data, ok := cache.get(url); ok {
+			return data, nil
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	return io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("bad status code: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil && resp.StatusCode == http.StatusOK {
+		cache.put(url, data)
+	}
+	return data, nil
 }
 
 // ForwardMessageOptions for forwarding messages
@@ -241,8 +296,8 @@ type ForwardMessageOptions struct {
 }
 
 // ForwardMessage forwards a message to another thread
-func (c *Client) ForwardMessage(opts *ForwardMessageOptions) (*SendMessageResult, error) {
-	return c.SendMessage(&SendMessageOptions{
+func (c *Client) ForwardMessage(ctx context.Context, opts *ForwardMessageOptions) (*SendMessageResult, error) {
+	return c.SendMessage(ctx, &SendMessageOptions{
 		ThreadID: opts.ToThreadID,
 		Text:     "", // Will use ForwardedMsgId
 	})
@@ -256,14 +311,14 @@ type CreatePollOptions struct {
 }
 
 // CreatePoll creates a poll in a thread
-func (c *Client) CreatePoll(opts *CreatePollOptions) error {
+func (c *Client) CreatePoll(ctx context.Context, opts *CreatePollOptions) error {
 	task := &socket.CreatePollTask{
 		ThreadKey:    opts.ThreadID,
 		QuestionText: opts.Question,
 		Options:      opts.Options,
 		SyncGroup:    1,
 	}
-	_, err := c.Messagix.ExecuteTasks(c.ctx, task)
+	_, err := c.executeTasks(ctx, RateLimitActionSend, opts.ThreadID, task)
 	return err
 }
 
@@ -275,14 +330,14 @@ type UpdatePollOptions struct {
 }
 
 // UpdatePoll votes on a poll
-func (c *Client) UpdatePoll(opts *UpdatePollOptions) error {
+func (c *Client) UpdatePoll(ctx context.Context, opts *UpdatePollOptions) error {
 	task := &socket.UpdatePollTask{
 		ThreadKey:       opts.ThreadID,
 		PollID:          opts.PollID,
 		SelectedOptions: opts.SelectedOptions,
 		SyncGroup:       1,
 	}
-	_, err := c.Messagix.ExecuteTasks(c.ctx, task)
+	_, err := c.executeTasks(ctx, RateLimitActionReact, opts.ThreadID, task)
 	return err
 }
 
@@ -293,13 +348,13 @@ type MuteThreadOptions struct {
 }
 
 // MuteThread mutes a thread
-func (c *Client) MuteThread(opts *MuteThreadOptions) error {
+func (c *Client) MuteThread(ctx context.Context, opts *MuteThreadOptions) error {
 	task := &socket.MuteThreadTask{
 		ThreadKey:        opts.ThreadID,
 		MuteExpireTimeMS: opts.MuteSeconds * 1000,
 		SyncGroup:        1,
 	}
-	_, err := c.Messagix.ExecuteTasks(c.ctx, task)
+	_, err := c.executeTasks(ctx, RateLimitActionManage, opts.ThreadID, task)
 	return err
 }
 
@@ -311,7 +366,7 @@ type SetGroupPhotoOptions struct {
 }
 
 // SetGroupPhoto sets the group photo/avatar
-func (c *Client) SetGroupPhoto(opts *SetGroupPhotoOptions) error {
+func (c *Client) SetGroupPhoto(ctx context.Context, opts *SetGroupPhotoOptions) error {
 	// Upload the image first
 	media := &messagix.MercuryUploadMedia{
 		Filename:  "group_photo.jpg",
@@ -319,8 +374,14 @@ func (c *Client) SetGroupPhoto(opts *SetGroupPhotoOptions) error {
 		MediaData: opts.Data,
 	}
 
-	resp, err := c.Messagix.SendMercuryUploadRequest(c.ctx, opts.ThreadID, media)
+	if err := c.throttle(ctx, RateLimitActionUpload, strconv.FormatInt(opts.ThreadID, 10)); err != nil {
+		c.metrics.sendFailed(RateLimitActionUpload)
+		return err
+	}
+	c.metrics.observeUpload("mercury", len(opts.Data))
+	resp, err := c.Messagix.SendMercuryUploadRequest(ctx, opts.ThreadID, media)
 	if err != nil {
+		c.metrics.sendFailed(RateLimitActionUpload)
 		return fmt.Errorf("failed to upload group photo: %w", err)
 	}
 
@@ -338,7 +399,7 @@ func (c *Client) SetGroupPhoto(opts *SetGroupPhotoOptions) error {
 		ImageID:   imageID,
 		SyncGroup: 1,
 	}
-	_, err = c.Messagix.ExecuteTasks(c.ctx, task)
+	_, err = c.executeTasks(ctx, RateLimitActionManage, opts.ThreadID, task)
 	return err
 }
 
@@ -349,13 +410,20 @@ type RenameThreadOptions struct {
 }
 
 // RenameThread renames a group thread
-func (c *Client) RenameThread(opts *RenameThreadOptions) error {
+func (c *Client) RenameThread(ctx context.Context, opts *RenameThreadOptions) error {
+	if c.Platform.IsInstagram() {
+		threadKey := strconv.FormatInt(opts.ThreadID, 10)
+		if err := c.throttle(ctx, RateLimitActionManage, threadKey); err != nil {
+			return err
+		}
+		return c.Messagix.Instagram.EditGroupTitle(ctx, threadKey, opts.NewName)
+	}
 	task := &socket.RenameThreadTask{
 		ThreadKey:  opts.ThreadID,
 		ThreadName: opts.NewName,
 		SyncGroup:  1,
 	}
-	_, err := c.Messagix.ExecuteTasks(c.ctx, task)
+	_, err := c.executeTasks(ctx, RateLimitActionManage, opts.ThreadID, task)
 	return err
 }
 
@@ -365,12 +433,19 @@ type DeleteThreadOptions struct {
 }
 
 // DeleteThread deletes a thread
-func (c *Client) DeleteThread(opts *DeleteThreadOptions) error {
+func (c *Client) DeleteThread(ctx context.Context, opts *DeleteThreadOptions) error {
+	if c.Platform.IsInstagram() {
+		threadKey := strconv.FormatInt(opts.ThreadID, 10)
+		if err := c.throttle(ctx, RateLimitActionManage, threadKey); err != nil {
+			return err
+		}
+		return c.Messagix.Instagram.DeleteThread(ctx, threadKey)
+	}
 	task := &socket.DeleteThreadTask{
 		ThreadKey: opts.ThreadID,
 		SyncGroup: 1,
 	}
-	_, err := c.Messagix.ExecuteTasks(c.ctx, task)
+	_, err := c.executeTasks(ctx, RateLimitActionManage, opts.ThreadID, task)
 	return err
 }
 
@@ -387,13 +462,13 @@ type SearchUser struct {
 }
 
 // SearchUsers searches for users
-func (c *Client) SearchUsers(opts *SearchUsersOptions) ([]*SearchUser, error) {
+func (c *Client) SearchUsers(ctx context.Context, opts *SearchUsersOptions) ([]*SearchUser, error) {
 	task := &socket.SearchUserTask{
 		Query:          opts.Query,
 		SupportedTypes: []table.SearchType{table.SearchTypeContact, table.SearchTypeNonContact},
 		SurfaceType:    15,
 	}
-	tbl, err := c.Messagix.ExecuteTasks(c.ctx, task)
+	tbl, err := c.executeTasks(ctx, RateLimitActionQuery, 0, task)
 	if err != nil {
 		return nil, err
 	}
@@ -423,7 +498,7 @@ type CreateThreadResult struct {
 }
 
 // CreateThread creates a 1:1 thread with a user
-func (c *Client) CreateThread(opts *CreateThreadOptions) (*CreateThreadResult, error) {
+func (c *Client) CreateThread(ctx context.Context, opts *CreateThreadOptions) (*CreateThreadResult, error) {
 	task := &socket.CreateThreadTask{
 		ThreadFBID:                opts.UserID,
 		ForceUpsert:               1,
@@ -432,7 +507,7 @@ func (c *Client) CreateThread(opts *CreateThreadOptions) (*CreateThreadResult, e
 		MetadataOnly:              0,
 		PreviewOnly:               0,
 	}
-	tbl, err := c.Messagix.ExecuteTasks(c.ctx, task)
+	tbl, err := c.executeTasks(ctx, RateLimitActionManage, 0, task)
 	if err != nil {
 		return nil, err
 	}
@@ -450,47 +525,46 @@ func (c *Client) CreateThread(opts *CreateThreadOptions) (*CreateThreadResult, e
 
 // ContactInfo represents detailed user/contact information
 type ContactInfo struct {
-	ID                int64  `json:"id"`
-	Name              string `json:"name"`
-	FirstName         string `json:"firstName,omitempty"`
-	Username          string `json:"username,omitempty"`
-	ProfilePictureUrl string `json:"profilePictureUrl,omitempty"`
-	IsMessengerUser   bool   `json:"isMessengerUser,omitempty"`
-	IsVerified        bool   `json:"isVerified,omitempty"`
-	Gender            int64  `json:"gender,omitempty"`
-	CanViewerMessage  bool   `json:"canViewerMessage,omitempty"`
+	ID                int64            `json:"id"`
+	Name              string           `json:"name"`
+	FirstName         string           `json:"firstName,omitempty"`
+	Username          string           `json:"username,omitempty"`
+	ProfilePictureUrl string           `json:"profilePictureUrl,omitempty"`
+	IsMessengerUser   bool             `json:"isMessengerUser,omitempty"`
+	IsVerified        bool             `json:"isVerified,omitempty"`
+	Gender            int64            `json:"gender,omitempty"`
+	CanViewerMessage  bool             `json:"canViewerMessage,omitempty"`
+	IGID              string           `json:"igId,omitempty"` // Instagram user ID, only on instagram
+	IsBlocked         bool             `json:"isBlocked,omitempty"`
+	Presence          *ContactPresence `json:"presence,omitempty"`
+	UpdatedAtMs       int64            `json:"updatedAtMs,omitempty"` // when the full contact was last received
 }
 
 // GetUserInfoOptions for getting user info
 type GetUserInfoOptions struct {
-	UserID int64 `json:"userId"`
+	UserID  int64 `json:"userId"`
+	Refresh bool  `json:"refresh,omitempty"` // ignore the cache
 }
 
-// GetUserInfo gets detailed information about a user
-func (c *Client) GetUserInfo(opts *GetUserInfoOptions) (*ContactInfo, error) {
-	task := &socket.GetContactsFullTask{
-		ContactID: opts.UserID,
-	}
-	tbl, err := c.Messagix.ExecuteTasks(c.ctx, task)
-	if err != nil {
+// GetUserInfo gets detailed information about a user.
+// Cached contacts are returned directly until they are older than the cache TTL.
+func (c *Client) GetUserInfo(ctx context.Context, opts *GetUserInfoOptions) (*ContactInfo, error) {
+	cached := c.contacts.get(opts.UserID)
+	if !opts.Refresh && contactFresh(cached) {
+		return cached, nil
+	}
+	if err := c.fetchContacts(ctx, []int64{opts.UserID}); err != nil {
+		if cached != nil && cached.Name != "" {
+			c.Logger.Warn().Err(err).Int64("user_id", opts.UserID).Msg("Failed to refresh contact, returning cached info")
+			return cached, nil
+		}
 		return nil, err
 	}
-
-	if tbl != nil {
-		for _, contact := range tbl.LSDeleteThenInsertContact {
-			if contact.Id == opts.UserID {
-				return &ContactInfo{
-					ID:                contact.Id,
-					Name:              contact.Name,
-					FirstName:         contact.FirstName,
-					Username:          contact.Username,
-					ProfilePictureUrl: contact.GetAvatarURL(),
-					IsMessengerUser:   contact.IsMessengerUser,
-					Gender:            int64(contact.Gender),
-					CanViewerMessage:  contact.CanViewerMessage,
-				}, nil
-			}
+	if contact := c.contacts.get(opts.UserID); contactFresh(contact) {
+		if contact.IGID == "" {
+			contact.IGID = c.igIDs.userIGID(contact.ID)
 		}
+		return contact, nil
 	}
 
 	return nil, fmt.Errorf("user not found: %d", opts.UserID)
@@ -506,12 +580,13 @@ type SendE2EEImageOptions struct {
 	Caption          string `json:"caption,omitempty"`
 	Width            int    `json:"width,omitempty"`
 	Height           int    `json:"height,omitempty"`
+	ViewOnce         bool   `json:"viewOnce,omitempty"`
 	ReplyToID        string `json:"replyToId,omitempty"`
 	ReplyToSenderJID string `json:"replyToSenderJid,omitempty"`
 }
 
 // SendE2EEImage sends an E2EE image
-func (c *Client) SendE2EEImage(opts *SendE2EEImageOptions) (*SendMessageResult, error) {
+func (c *Client) SendE2EEImage(ctx context.Context, opts *SendE2EEImageOptions) (*SendMessageResult, error) {
 	if c.E2EE == nil || !c.E2EE.IsConnected() {
 		return nil, ErrE2EENotConnected
 	}
@@ -520,10 +595,24 @@ func (c *Client) SendE2EEImage(opts *SendE2EEImageOptions) (*SendMessageResult,
 	if err != nil {
 		return nil, err
 	}
+	if opts.ViewOnce {
+		if err := requireE2EEChat(chatJID, "view-once media"); err != nil {
+			return nil, err
+		}
+	}
 
-	// Set default dimensions if not provided
+	mimeType := opts.MimeType
+	if mimeType == "" {
+		mimeType = "image/jpeg"
+	}
+
+	// Fill in dimensions from the image itself, falling back to defaults
+	probed := c.probeMedia(ctx, opts.Data, mimeType, false)
 	width := opts.Width
 	height := opts.Height
+	if width == 0 || height == 0 {
+		width, height = probed.Width, probed.Height
+	}
 	if width == 0 {
 		width = 400
 	}
@@ -531,13 +620,8 @@ func (c *Client) SendE2EEImage(opts *SendE2EEImageOptions) (*SendMessageResult,
 		height = 400
 	}
 
-	mimeType := opts.MimeType
-	if mimeType == "" {
-		mimeType = "image/jpeg"
-	}
-
 	// Upload media
-	uploaded, err := c.E2EE.Upload(c.ctx, opts.Data, whatsmeow.MediaImage)
+	uploaded, err := c.uploadE2EE(ctx, chatJID, opts.Data, whatsmeow.MediaImage)
 	if err != nil {
 		return nil, err
 	}
@@ -554,11 +638,8 @@ func (c *Client) SendE2EEImage(opts *SendE2EEImageOptions) (*SendMessageResult,
 		Ancillary: &waMediaTransport.WAMediaTransport_Ancillary{
 			FileLength: proto.Uint64(uint64(len(opts.Data))),
 			Mimetype:   &mimeType,
-			Thumbnail: &waMediaTransport.WAMediaTransport_Ancillary_Thumbnail{
-				ThumbnailWidth:  proto.Uint32(uint32(width)),
-				ThumbnailHeight: proto.Uint32(uint32(height)),
-			},
-			ObjectID: &uploaded.ObjectID,
+			Thumbnail:  probed.transportThumbnail(),
+			ObjectID:   &uploaded.ObjectID,
 		},
 	}
 
@@ -582,14 +663,24 @@ func (c *Client) SendE2EEImage(opts *SendE2EEImageOptions) (*SendMessageResult,
 		return nil, err
 	}
 
+	content := &waConsumerApplication.ConsumerApplication_Content{
+		Content: &waConsumerApplication.ConsumerApplication_Content_ImageMessage{
+			ImageMessage: imageMsg,
+		},
+	}
+	if opts.ViewOnce {
+		content.Content = &waConsumerApplication.ConsumerApplication_Content_ViewOnceMessage{
+			ViewOnceMessage: &waConsumerApplication.ConsumerApplication_ViewOnceMessage{
+				ViewOnceContent: &waConsumerApplication.ConsumerApplication_ViewOnceMessage_ImageMessage{
+					ImageMessage: imageMsg,
+				},
+			},
+		}
+	}
 	waMsg := &waConsumerApplication.ConsumerApplication{
 		Payload: &waConsumerApplication.ConsumerApplication_Payload{
 			Payload: &waConsumerApplication.ConsumerApplication_Payload_Content{
-				Content: &waConsumerApplication.ConsumerApplication_Content{
-					Content: &waConsumerApplication.ConsumerApplication_Content_ImageMessage{
-						ImageMessage: imageMsg,
-					},
-				},
+				Content: content,
 			},
 		},
 	}
@@ -608,7 +699,7 @@ func (c *Client) SendE2EEImage(opts *SendE2EEImageOptions) (*SendMessageResult,
 	}
 
 	msgID := strconv.FormatInt(time.Now().UnixNano(), 10)
-	resp, err := c.E2EE.SendFBMessage(c.ctx, chatJID, waMsg, metadata, whatsmeow.SendRequestExtra{
+	resp, err := c.sendFBMessage(ctx, RateLimitActionSend, chatJID, waMsg, metadata, whatsmeow.SendRequestExtra{
 		ID:          msgID,
 		MediaHandle: uploaded.Handle,
 	})
@@ -631,12 +722,13 @@ type SendE2EEVideoOptions struct {
 	Width            int    `json:"width,omitempty"`
 	Height           int    `json:"height,omitempty"`
 	Duration         int    `json:"duration,omitempty"`
+	ViewOnce         bool   `json:"viewOnce,omitempty"`
 	ReplyToID        string `json:"replyToId,omitempty"`
 	ReplyToSenderJID string `json:"replyToSenderJid,omitempty"`
 }
 
 // SendE2EEVideo sends an E2EE video
-func (c *Client) SendE2EEVideo(opts *SendE2EEVideoOptions) (*SendMessageResult, error) {
+func (c *Client) SendE2EEVideo(ctx context.Context, opts *SendE2EEVideoOptions) (*SendMessageResult, error) {
 	if c.E2EE == nil || !c.E2EE.IsConnected() {
 		return nil, ErrE2EENotConnected
 	}
@@ -645,23 +737,37 @@ func (c *Client) SendE2EEVideo(opts *SendE2EEVideoOptions) (*SendMessageResult,
 	if err != nil {
 		return nil, err
 	}
+	if opts.ViewOnce {
+		if err := requireE2EEChat(chatJID, "view-once media"); err != nil {
+			return nil, err
+		}
+	}
 
-	// Set defaults
+	mimeType := opts.MimeType
+	if mimeType == "" {
+		mimeType = "video/mp4"
+	}
+
+	// Fill in missing metadata by probing the video, falling back to defaults
+	probed := c.probeMedia(ctx, opts.Data, mimeType, false)
 	width := opts.Width
 	height := opts.Height
+	if width == 0 || height == 0 {
+		width, height = probed.Width, probed.Height
+	}
 	if width == 0 {
 		width = 400
 	}
 	if height == 0 {
 		height = 400
 	}
-	mimeType := opts.MimeType
-	if mimeType == "" {
-		mimeType = "video/mp4"
+	duration := opts.Duration
+	if duration == 0 {
+		duration = probed.Seconds()
 	}
 
 	// Upload media
-	uploaded, err := c.E2EE.Upload(c.ctx, opts.Data, whatsmeow.MediaVideo)
+	uploaded, err := c.uploadE2EE(ctx, chatJID, opts.Data, whatsmeow.MediaVideo)
 	if err != nil {
 		return nil, err
 	}
@@ -678,11 +784,8 @@ func (c *Client) SendE2EEVideo(opts *SendE2EEVideoOptions) (*SendMessageResult,
 		Ancillary: &waMediaTransport.WAMediaTransport_Ancillary{
 			FileLength: proto.Uint64(uint64(len(opts.Data))),
 			Mimetype:   &mimeType,
-			Thumbnail: &waMediaTransport.WAMediaTransport_Ancillary_Thumbnail{
-				ThumbnailWidth:  proto.Uint32(uint32(width)),
-				ThumbnailHeight: proto.Uint32(uint32(height)),
-			},
-			ObjectID: &uploaded.ObjectID,
+			Thumbnail:  probed.transportThumbnail(),
+			ObjectID:   &uploaded.ObjectID,
 		},
 	}
 
@@ -700,7 +803,7 @@ func (c *Client) SendE2EEVideo(opts *SendE2EEVideoOptions) (*SendMessageResult,
 		Ancillary: &waMediaTransport.VideoTransport_Ancillary{
 			Height:      proto.Uint32(uint32(height)),
 			Width:       proto.Uint32(uint32(width)),
-			Seconds:     proto.Uint32(uint32(opts.Duration)),
+			Seconds:     proto.Uint32(uint32(duration)),
 			GifPlayback: &isGif,
 		},
 	})
@@ -708,14 +811,24 @@ func (c *Client) SendE2EEVideo(opts *SendE2EEVideoOptions) (*SendMessageResult,
 		return nil, err
 	}
 
+	content := &waConsumerApplication.ConsumerApplication_Content{
+		Content: &waConsumerApplication.ConsumerApplication_Content_VideoMessage{
+			VideoMessage: videoMsg,
+		},
+	}
+	if opts.ViewOnce {
+		content.Content = &waConsumerApplication.ConsumerApplication_Content_ViewOnceMessage{
+			ViewOnceMessage: &waConsumerApplication.ConsumerApplication_ViewOnceMessage{
+				ViewOnceContent: &waConsumerApplication.ConsumerApplication_ViewOnceMessage_VideoMessage{
+					VideoMessage: videoMsg,
+				},
+			},
+		}
+	}
 	waMsg := &waConsumerApplication.ConsumerApplication{
 		Payload: &waConsumerApplication.ConsumerApplication_Payload{
 			Payload: &waConsumerApplication.ConsumerApplication_Payload_Content{
-				Content: &waConsumerApplication.ConsumerApplication_Content{
-					Content: &waConsumerApplication.ConsumerApplication_Content_VideoMessage{
-						VideoMessage: videoMsg,
-					},
-				},
+				Content: content,
 			},
 		},
 	}
@@ -734,7 +847,7 @@ func (c *Client) SendE2EEVideo(opts *SendE2EEVideoOptions) (*SendMessageResult,
 	}
 
 	msgID := strconv.FormatInt(time.Now().UnixNano(), 10)
-	resp, err := c.E2EE.SendFBMessage(c.ctx, chatJID, waMsg, metadata, whatsmeow.SendRequestExtra{
+	resp, err := c.sendFBMessage(ctx, RateLimitActionSend, chatJID, waMsg, metadata, whatsmeow.SendRequestExtra{
 		ID:          msgID,
 		MediaHandle: uploaded.Handle,
 	})
@@ -754,13 +867,14 @@ type SendE2EEAudioOptions struct {
 	Data             []byte `json:"data"`
 	MimeType         string `json:"mimeType"`
 	Duration         int    `json:"duration,omitempty"`
-	PTT              bool   `json:"ptt"` // Push-to-talk (voice message)
+	PTT              bool   `json:"ptt"`                 // Push-to-talk (voice message)
+	Transcode        bool   `json:"transcode,omitempty"` // convert to OGG/Opus with ffmpeg if needed and send as PTT
 	ReplyToID        string `json:"replyToId,omitempty"`
 	ReplyToSenderJID string `json:"replyToSenderJid,omitempty"`
 }
 
 // SendE2EEAudio sends an E2EE audio/voice message
-func (c *Client) SendE2EEAudio(opts *SendE2EEAudioOptions) (*SendMessageResult, error) {
+func (c *Client) SendE2EEAudio(ctx context.Context, opts *SendE2EEAudioOptions) (*SendMessageResult, error) {
 	if c.E2EE == nil || !c.E2EE.IsConnected() {
 		return nil, ErrE2EENotConnected
 	}
@@ -770,13 +884,30 @@ func (c *Client) SendE2EEAudio(opts *SendE2EEAudioOptions) (*SendMessageResult,
 		return nil, err
 	}
 
-	mimeType := opts.MimeType
-	if mimeType == "" {
-		mimeType = "audio/ogg; codecs=opus"
+	data, mimeType, ptt := opts.Data, opts.MimeType, opts.PTT
+	if opts.Transcode {
+		data, mimeType, err = c.transcodeVoice(ctx, data, mimeType, e2eeVoiceFormat)
+		if err != nil {
+			return nil, err
+		}
+		ptt = true
+	} else if mimeType == "" {
+		mimeType = e2eeVoiceMimeType
+	}
+
+	// Fill in duration and the voice message waveform by probing the audio
+	duration := opts.Duration
+	var wf []byte
+	if duration == 0 || ptt {
+		probed := c.probeMedia(ctx, data, mimeType, ptt)
+		if duration == 0 {
+			duration = probed.Seconds()
+		}
+		wf = waveformBytes(probed.Waveform)
 	}
 
 	// Upload media
-	uploaded, err := c.E2EE.Upload(c.ctx, opts.Data, whatsmeow.MediaAudio)
+	uploaded, err := c.uploadE2EE(ctx, chatJID, data, whatsmeow.MediaAudio)
 	if err != nil {
 		return nil, err
 	}
@@ -791,7 +922,7 @@ func (c *Client) SendE2EEAudio(opts *SendE2EEAudioOptions) (*SendMessageResult,
 			MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
 		},
 		Ancillary: &waMediaTransport.WAMediaTransport_Ancillary{
-			FileLength: proto.Uint64(uint64(len(opts.Data))),
+			FileLength: proto.Uint64(uint64(len(data))),
 			Mimetype:   &mimeType,
 			ObjectID:   &uploaded.ObjectID,
 		},
@@ -799,14 +930,15 @@ func (c *Client) SendE2EEAudio(opts *SendE2EEAudioOptions) (*SendMessageResult,
 
 	// Build audio message with transport
 	audioMsg := &waConsumerApplication.ConsumerApplication_AudioMessage{
-		PTT: &opts.PTT,
+		PTT: &ptt,
 	}
 	err = audioMsg.Set(&waMediaTransport.AudioTransport{
 		Integral: &waMediaTransport.AudioTransport_Integral{
 			Transport: mediaTransport,
 		},
 		Ancillary: &waMediaTransport.AudioTransport_Ancillary{
-			Seconds: proto.Uint32(uint32(opts.Duration)),
+			Seconds:  proto.Uint32(uint32(duration)),
+			Waveform: wf,
 		},
 	})
 	if err != nil {
@@ -839,7 +971,7 @@ func (c *Client) SendE2EEAudio(opts *SendE2EEAudioOptions) (*SendMessageResult,
 	}
 
 	msgID := strconv.FormatInt(time.Now().UnixNano(), 10)
-	resp, err := c.E2EE.SendFBMessage(c.ctx, chatJID, waMsg, metadata, whatsmeow.SendRequestExtra{
+	resp, err := c.sendFBMessage(ctx, RateLimitActionSend, chatJID, waMsg, metadata, whatsmeow.SendRequestExtra{
 		ID:          msgID,
 		MediaHandle: uploaded.Handle,
 	})
@@ -864,7 +996,7 @@ type SendE2EEDocumentOptions struct {
 }
 
 // SendE2EEDocument sends an E2EE document/file
-func (c *Client) SendE2EEDocument(opts *SendE2EEDocumentOptions) (*SendMessageResult, error) {
+func (c *Client) SendE2EEDocument(ctx context.Context, opts *SendE2EEDocumentOptions) (*SendMessageResult, error) {
 	if c.E2EE == nil || !c.E2EE.IsConnected() {
 		return nil, ErrE2EENotConnected
 	}
@@ -880,7 +1012,7 @@ func (c *Client) SendE2EEDocument(opts *SendE2EEDocumentOptions) (*SendMessageRe
 	}
 
 	// Upload media
-	uploaded, err := c.E2EE.Upload(c.ctx, opts.Data, whatsmeow.MediaDocument)
+	uploaded, err := c.uploadE2EE(ctx, chatJID, opts.Data, whatsmeow.MediaDocument)
 	if err != nil {
 		return nil, err
 	}
@@ -941,7 +1073,7 @@ func (c *Client) SendE2EEDocument(opts *SendE2EEDocumentOptions) (*SendMessageRe
 	}
 
 	msgID := strconv.FormatInt(time.Now().UnixNano(), 10)
-	resp, err := c.E2EE.SendFBMessage(c.ctx, chatJID, waMsg, metadata, whatsmeow.SendRequestExtra{
+	resp, err := c.sendFBMessage(ctx, RateLimitActionSend, chatJID, waMsg, metadata, whatsmeow.SendRequestExtra{
 		ID:          msgID,
 		MediaHandle: uploaded.Handle,
 	})
@@ -967,7 +1099,7 @@ type SendE2EEStickerOptions struct {
 }
 
 // SendE2EESticker sends an E2EE sticker
-func (c *Client) SendE2EESticker(opts *SendE2EEStickerOptions) (*SendMessageResult, error) {
+func (c *Client) SendE2EESticker(ctx context.Context, opts *SendE2EEStickerOptions) (*SendMessageResult, error) {
 	if c.E2EE == nil || !c.E2EE.IsConnected() {
 		return nil, ErrE2EENotConnected
 	}
@@ -977,22 +1109,28 @@ func (c *Client) SendE2EESticker(opts *SendE2EEStickerOptions) (*SendMessageResu
 		return nil, err
 	}
 
-	// Set defaults
+	mimeType := opts.MimeType
+	if mimeType == "" {
+		mimeType = "image/webp"
+	}
+
+	// Fill in dimensions from the sticker itself, falling back to defaults
 	width := opts.Width
 	height := opts.Height
+	var probed *MediaInfo
+	if width == 0 || height == 0 {
+		probed = c.probeMedia(ctx, opts.Data, mimeType, false)
+		width, height = probed.Width, probed.Height
+	}
 	if width == 0 {
 		width = 512
 	}
 	if height == 0 {
 		height = 512
 	}
-	mimeType := opts.MimeType
-	if mimeType == "" {
-		mimeType = "image/webp"
-	}
 
 	// Upload media (stickers are typically image/webp)
-	uploaded, err := c.E2EE.Upload(c.ctx, opts.Data, whatsmeow.MediaImage)
+	uploaded, err := c.uploadE2EE(ctx, chatJID, opts.Data, whatsmeow.MediaImage)
 	if err != nil {
 		return nil, err
 	}
@@ -1009,11 +1147,8 @@ func (c *Client) SendE2EESticker(opts *SendE2EEStickerOptions) (*SendMessageResu
 		Ancillary: &waMediaTransport.WAMediaTransport_Ancillary{
 			FileLength: proto.Uint64(uint64(len(opts.Data))),
 			Mimetype:   &mimeType,
-			Thumbnail: &waMediaTransport.WAMediaTransport_Ancillary_Thumbnail{
-				ThumbnailWidth:  proto.Uint32(uint32(width)),
-				ThumbnailHeight: proto.Uint32(uint32(height)),
-			},
-			ObjectID: &uploaded.ObjectID,
+			Thumbnail:  probed.transportThumbnail(),
+			ObjectID:   &uploaded.ObjectID,
 		},
 	}
 
@@ -1058,7 +1193,7 @@ func (c *Client) SendE2EESticker(opts *SendE2EEStickerOptions) (*SendMessageResu
 	}
 
 	msgID := strconv.FormatInt(time.Now().UnixNano(), 10)
-	resp, err := c.E2EE.SendFBMessage(c.ctx, chatJID, waMsg, metadata, whatsmeow.SendRequestExtra{
+	resp, err := c.sendFBMessage(ctx, RateLimitActionSend, chatJID, waMsg, metadata, whatsmeow.SendRequestExtra{
 		ID:          msgID,
 		MediaHandle: uploaded.Handle,
 	})
@@ -1091,7 +1226,7 @@ type DownloadE2EEMediaResult struct {
 }
 
 // DownloadE2EEMedia downloads and decrypts E2EE media
-func (c *Client) DownloadE2EEMedia(opts *DownloadE2EEMediaOptions) (*DownloadE2EEMediaResult, error) {
+func (c *Client) DownloadE2EEMedia(ctx context.Context, opts *DownloadE2EEMediaOptions) (*DownloadE2EEMediaResult, error) {
 	if c.E2EE == nil || !c.E2EE.IsConnected() {
 		return nil, ErrE2EENotConnected
 	}
@@ -1140,7 +1275,7 @@ func (c *Client) DownloadE2EEMedia(opts *DownloadE2EEMediaOptions) (*DownloadE2E
 	}
 
 	// Download and decrypt
-	data, err := c.E2EE.DownloadFB(c.ctx, integral, waMediaType)
+	data, err := c.E2EE.DownloadFB(ctx, integral, waMediaType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download E2EE media: %w", err)
 	}
@@ -0,0 +1,282 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+	"strings"
+
+	"go.mau.fi/util/exmime"
+	"go.mau.fi/util/ffmpeg"
+	"go.mau.fi/util/ffmpeg/waveform"
+	"go.mau.fi/whatsmeow/proto/waMediaTransport"
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/mautrix-meta/pkg/messagix"
+)
+
+const (
+	// Longest side of generated JPEG thumbnails
+	mediaThumbnailSize = 100
+	// Number of samples in E2EE voice waveforms, values are 0-100
+	e2eeWaveformSamples = 64
+	// Sampling frequency of Mercury voice clip waveforms
+	mercuryWaveformHz = 10
+)
+
+// MediaInfo is the metadata probed from media data
+type MediaInfo struct {
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	Duration  float64 `json:"duration,omitempty"` // seconds
+	Thumbnail []byte  `json:"-"`                  // JPEG
+	// Dimensions of Thumbnail, which are smaller than Width and Height
+	ThumbnailSize image.Point `json:"-"`
+	Waveform      []int       `json:"-"`
+}
+
+// Seconds returns the duration rounded up to whole seconds
+func (mi *MediaInfo) Seconds() int {
+	return int(math.Ceil(mi.Duration))
+}
+
+// probeMedia inspects media data locally. Images are decoded in Go, video and audio
+// need ffprobe/ffmpeg to be installed. Failures are logged and leave fields empty.
+func (c *Client) probeMedia(ctx context.Context, data []byte, mimeType string, wantWaveform bool) *MediaInfo {
+	info := &MediaInfo{}
+	log := c.Logger.With().Str("action", "probe media").Str("mime_type", mimeType).Logger()
+	ctx = log.WithContext(ctx)
+
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			// Formats without a Go decoder (e.g. webp) can still be measured by ffprobe
+			log.Debug().Err(err).Msg("Failed to decode image")
+			if ffmpeg.ProbeSupported() {
+				c.probeWithFFprobe(ctx, data, mimeType, info)
+			}
+			return info
+		}
+		info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		info.Thumbnail, info.ThumbnailSize = makeJPEGThumbnail(img)
+
+	case strings.HasPrefix(mimeType, "video/"), strings.HasPrefix(mimeType, "audio/"):
+		if ffmpeg.ProbeSupported() {
+			c.probeWithFFprobe(ctx, data, mimeType, info)
+		}
+		if !ffmpeg.Supported() {
+			break
+		}
+		if strings.HasPrefix(mimeType, "video/") {
+			frame, err := ffmpeg.ConvertBytes(ctx, data, ".jpg", nil, []string{"-frames:v", "1"}, mimeType)
+			if err != nil {
+				log.Debug().Err(err).Msg("Failed to extract video frame")
+			} else if img, err := jpeg.Decode(bytes.NewReader(frame)); err == nil {
+				info.Thumbnail, info.ThumbnailSize = makeJPEGThumbnail(img)
+				if info.Width == 0 {
+					info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+				}
+			}
+		} else if wantWaveform {
+			wf, err := waveform.GenerateBytes(ctx, data, mimeType, e2eeWaveformSamples, 100)
+			if err != nil {
+				log.Debug().Err(err).Msg("Failed to generate waveform")
+			} else {
+				info.Waveform = wf
+			}
+		}
+	}
+	return info
+}
+
+// ffprobeBytes writes data to a temporary file and runs ffprobe on it
+func ffprobeBytes(ctx context.Context, data []byte, mimeType string) (*ffmpeg.ProbeResult, error) {
+	tmp, err := os.CreateTemp("", "mx-probe-*"+exmime.ExtensionFromMimetype(mimeType))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	_ = tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+	return ffmpeg.Probe(ctx, tmp.Name())
+}
+
+// probeWithFFprobe fills dimensions and duration using ffprobe
+func (c *Client) probeWithFFprobe(ctx context.Context, data []byte, mimeType string, info *MediaInfo) {
+	result, err := ffprobeBytes(ctx, data, mimeType)
+	if err != nil {
+		c.Logger.Debug().Err(err).Msg("ffprobe failed")
+		return
+	}
+	if result.Format != nil {
+		info.Duration = result.Format.Duration
+	}
+	for _, stream := range result.Streams {
+		if stream.CodecType == "video" && stream.Disposition.AttachedPic == 0 && info.Width == 0 {
+			info.Width, info.Height = stream.Width, stream.Height
+		}
+		if info.Duration == 0 && stream.Duration > 0 {
+			info.Duration = stream.Duration
+		}
+	}
+}
+
+// makeJPEGThumbnail downscales an image so its longest side is mediaThumbnailSize
+// and returns the JPEG along with the thumbnail's dimensions
+func makeJPEGThumbnail(img image.Image) ([]byte, image.Point) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return nil, image.Point{}
+	}
+	tw, th := w, h
+	if w >= h && w > mediaThumbnailSize {
+		tw, th = mediaThumbnailSize, max(1, h*mediaThumbnailSize/w)
+	} else if h > w && h > mediaThumbnailSize {
+		tw, th = max(1, w*mediaThumbnailSize/h), mediaThumbnailSize
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	switch src := img.(type) {
+	case *image.YCbCr:
+		// Decoded JPEGs and video frames, averaged without converting the whole image
+		downscaleYCbCr(thumb, src)
+	case *image.RGBA:
+		downscaleRGBA(thumb, src)
+	default:
+		// Convert once so the pixels can be read directly, draw.Draw has fast paths into RGBA
+		rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+		downscaleRGBA(thumb, rgba)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 70}); err != nil {
+		return nil, image.Point{}
+	}
+	return buf.Bytes(), thumb.Bounds().Size()
+}
+
+// downscaleRGBA fills dst with the average of the source pixels that fall into each target pixel
+func downscaleRGBA(dst, src *image.RGBA) {
+	sb := src.Bounds()
+	w, h := sb.Dx(), sb.Dy()
+	tw, th := dst.Bounds().Dx(), dst.Bounds().Dy()
+	var sum [4]uint64
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, max((x+1)*w/tw, x*w/tw+1)
+			sum = [4]uint64{}
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[src.PixOffset(sb.Min.X+x0, sb.Min.Y+sy):]
+				for i := 0; i < (x1-x0)*4; i += 4 {
+					sum[0] += uint64(row[i])
+					sum[1] += uint64(row[i+1])
+					sum[2] += uint64(row[i+2])
+					sum[3] += uint64(row[i+3])
+				}
+			}
+			n := uint64((y1 - y0) * (x1 - x0))
+			out := dst.Pix[dst.PixOffset(x, y):]
+			for i := range sum {
+				out[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+}
+
+// downscaleYCbCr is downscaleRGBA for YCbCr images
+func downscaleYCbCr(dst *image.RGBA, src *image.YCbCr) {
+	sb := src.Bounds()
+	w, h := sb.Dx(), sb.Dy()
+	tw, th := dst.Bounds().Dx(), dst.Bounds().Dy()
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, max((x+1)*w/tw, x*w/tw+1)
+			var ySum, cbSum, crSum, cn uint64
+			for sy := sb.Min.Y + y0; sy < sb.Min.Y+y1; sy++ {
+				for _, v := range src.Y[src.YOffset(sb.Min.X+x0, sy) : src.YOffset(sb.Min.X+x1-1, sy)+1] {
+					ySum += uint64(v)
+				}
+				// Chroma is usually subsampled, every other pixel is enough
+				if (sy-sb.Min.Y-y0)%2 != 0 {
+					continue
+				}
+				for sx := sb.Min.X + x0; sx < sb.Min.X+x1; sx += 2 {
+					ci := src.COffset(sx, sy)
+					cbSum += uint64(src.Cb[ci])
+					crSum += uint64(src.Cr[ci])
+					cn++
+				}
+			}
+			n := uint64((y1 - y0) * (x1 - x0))
+			r, g, b := color.YCbCrToRGB(uint8(ySum/n), uint8(cbSum/cn), uint8(crSum/cn))
+			dst.SetRGBA(x, y, color.RGBA{R: r, G: g, B: b, A: 0xff})
+		}
+	}
+}
+
+// transportThumbnail returns the thumbnail for a media transport, or nil if none was generated
+func (mi *MediaInfo) transportThumbnail() *waMediaTransport.WAMediaTransport_Ancillary_Thumbnail {
+	if mi == nil || len(mi.Thumbnail) == 0 {
+		return nil
+	}
+	return &waMediaTransport.WAMediaTransport_Ancillary_Thumbnail{
+		JPEGThumbnail:   mi.Thumbnail,
+		ThumbnailWidth:  proto.Uint32(uint32(mi.ThumbnailSize.X)),
+		ThumbnailHeight: proto.Uint32(uint32(mi.ThumbnailSize.Y)),
+	}
+}
+
+// waveformBytes converts a 0-100 waveform to the byte format used in E2EE audio messages
+func waveformBytes(wf []int) []byte {
+	if len(wf) == 0 {
+		return nil
+	}
+	result := make([]byte, len(wf))
+	for i, v := range wf {
+		result[i] = byte(min(max(v, 0), 100))
+	}
+	return result
+}
+
+// mercuryWaveform generates the waveform attached to Mercury voice clip uploads
+func (c *Client) mercuryWaveform(ctx context.Context, data []byte, mimeType string) *messagix.WaveformData {
+	if !ffmpeg.Supported() {
+		return nil
+	}
+	info := &MediaInfo{}
+	if ffmpeg.ProbeSupported() {
+		c.probeWithFFprobe(ctx, data, mimeType, info)
+	}
+	if info.Duration <= 0 {
+		return nil
+	}
+	samples := min(max(int(info.Duration*mercuryWaveformHz), 1), 1000)
+	wf, err := waveform.GenerateBytes(ctx, data, mimeType, samples, 100)
+	if err != nil {
+		c.Logger.Debug().Err(err).Msg("Failed to generate voice clip waveform")
+		return nil
+	}
+	amplitudes := make([]float64, len(wf))
+	for i, v := range wf {
+		amplitudes[i] = float64(v) / 100
+	}
+	return &messagix.WaveformData{
+		Amplitudes:        amplitudes,
+		SamplingFrequency: mercuryWaveformHz,
+	}
+}
@@ -2,8 +2,12 @@ package bridge
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"strconv"
 	"time"
+	"unicode/utf16"
 
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/proto/waCommon"
@@ -31,28 +35,87 @@ type SendMessageOptions struct {
 	// E2EE Reply fields
 	E2EEReplyToID        string `json:"e2eeReplyToId,omitempty"`
 	E2EEReplyToSenderJID string `json:"e2eeReplyToSenderJid,omitempty"`
+	// E2EE link preview, sent as an extended text message. Regular messages return
+	// an error when it's set, use Url to let Meta generate the preview instead.
+	LinkPreview *LinkPreview `json:"linkPreview,omitempty"`
+}
+
+// LinkPreview metadata for a URL in an E2EE message
+type LinkPreview struct {
+	URL         string `json:"url"`
+	MatchedText string `json:"matchedText,omitempty"` // the URL as it appears in the text, defaults to URL
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
 }
 
 // SendMessageResult result of sending a message
 type SendMessageResult struct {
-	MessageID   string `json:"messageId"`
+	MessageID   string `json:"messageId"` // empty while Pending
 	TimestampMs int64  `json:"timestampMs"`
+	OTID        string `json:"otid,omitempty"`
+	// Pending is true when Meta accepted the send but did not confirm it yet.
+	// A messageSendConfirmed or messageSendFailed event follows with the same OTID.
+	Pending bool `json:"pending,omitempty"`
+}
+
+// SendError is returned when Meta rejects an outgoing message
+type SendError struct {
+	OTID     string `json:"otid"`
+	ThreadID int64  `json:"threadId,omitempty"`
+	TaskID   int64  `json:"taskId,omitempty"`
+	Reason   string `json:"reason"`
+	Source   string `json:"source"` // "optimistic" or "task"
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("message send failed (%s): %s", e.Source, e.Reason)
+}
+
+// ErrorCode returns the machine-readable error code
+func (e *SendError) ErrorCode() string {
+	return "send_failed"
+}
+
+// CodedError is implemented by errors that carry a machine-readable code
+type CodedError interface {
+	error
+	ErrorCode() string
+}
+
+// pendingSend is a message that was accepted by Meta but not confirmed yet
+type pendingSend struct {
+	ThreadID  int64
+	CreatedAt time.Time
 }
 
+// pendingSendTTL is how long an unconfirmed send is tracked before it's forgotten
+const pendingSendTTL = 10 * time.Minute
+
 // SendMessage sends a text message
-func (c *Client) SendMessage(opts *SendMessageOptions) (*SendMessageResult, error) {
+func (c *Client) SendMessage(ctx context.Context, opts *SendMessageOptions) (*SendMessageResult, error) {
+	return c.sendMessageWithOTID(ctx, opts, time.Now().UnixNano())
+}
+
+// sendMessageWithOTID sends a message with a caller-chosen otid, which is also
+// used as the E2EE message ID. Reusing the otid makes retries idempotent.
+func (c *Client) sendMessageWithOTID(ctx context.Context, opts *SendMessageOptions, otid int64) (*SendMessageResult, error) {
 	if opts.IsE2EE && c.E2EE != nil && c.E2EE.IsConnected() {
-		return c.sendE2EEMessage(opts)
+		return c.sendE2EEMessage(ctx, opts, strconv.FormatInt(otid, 10))
 	}
-	return c.sendRegularMessage(opts)
+	return c.sendRegularMessage(ctx, opts, otid)
 }
 
-func (c *Client) sendRegularMessage(opts *SendMessageOptions) (*SendMessageResult, error) {
-	if err := c.Messagix.WaitUntilCanSendMessages(c.ctx, 10*time.Second); err != nil {
+func (c *Client) sendRegularMessage(ctx context.Context, opts *SendMessageOptions, otid int64) (*SendMessageResult, error) {
+	if opts.LinkPreview != nil {
+		return nil, errors.New("linkPreview is only supported for E2EE messages, use url for regular messages")
+	}
+	if err := validateMentions(opts.Text, opts.MentionIDs, opts.MentionOffsets, opts.MentionLengths); err != nil {
+		return nil, err
+	}
+	if err := c.Messagix.WaitUntilCanSendMessages(ctx, 10*time.Second); err != nil {
 		return nil, err
 	}
 
-	otid := time.Now().UnixNano()
 	sendType := table.TEXT
 
 	if opts.StickerID > 0 {
@@ -89,44 +152,159 @@ func (c *Client) sendRegularMessage(opts *SendMessageOptions) (*SendMessageResul
 		task.MentionData = buildMentionData(opts.MentionIDs, opts.MentionOffsets, opts.MentionLengths)
 	}
 
-	resp, err := c.Messagix.ExecuteTasks(c.ctx, task)
+	// Track the send before executing it, the confirmation or failure may arrive in
+	// another table before ExecuteTasks returns
+	otidStr := strconv.FormatInt(otid, 10)
+	c.trackPendingSend(otidStr, opts.ThreadID)
+
+	resp, err := c.executeTasks(ctx, RateLimitActionSend, opts.ThreadID, task)
 	if err != nil {
+		c.untrackPendingSend(otidStr)
 		return nil, err
 	}
 
 	result := &SendMessageResult{
-		MessageID:   generateMID(otid),
 		TimestampMs: time.Now().UnixMilli(),
+		OTID:        otidStr,
 	}
 
 	// Try to get actual message ID from response
-	otidStr := strconv.FormatInt(otid, 10)
 	if resp != nil {
 		for _, r := range resp.LSReplaceOptimsiticMessage {
 			if r.OfflineThreadingId == otidStr {
+				c.untrackPendingSend(otidStr)
 				result.MessageID = r.MessageId
-				break
+				return result, nil
 			}
 		}
+		if sendErr := findSendFailure(resp, otidStr); sendErr != nil {
+			c.untrackPendingSend(otidStr)
+			sendErr.ThreadID = opts.ThreadID
+			c.Logger.Warn().Str("otid", otidStr).Str("reason", sendErr.Reason).Msg("Message send rejected")
+			return nil, sendErr
+		}
 	}
 
+	// Accepted but not confirmed yet, a later table resolves it. If it was already
+	// resolved while ExecuteTasks was running, the event has been emitted.
+	result.Pending = true
 	return result, nil
 }
 
-func (c *Client) sendE2EEMessage(opts *SendMessageOptions) (*SendMessageResult, error) {
+// findSendFailure looks for failure rows matching the given otid
+func findSendFailure(tbl *table.LSTable, otid string) *SendError {
+	for _, failed := range tbl.LSMarkOptimisticMessageFailed {
+		if failed.OTID == otid {
+			return &SendError{OTID: otid, Reason: failed.Message, Source: "optimistic"}
+		}
+	}
+	for _, failed := range tbl.LSHandleFailedTask {
+		if failed.OTID == otid {
+			return &SendError{OTID: otid, TaskID: failed.TaskID, Reason: failed.Message, Source: "task"}
+		}
+	}
+	return nil
+}
+
+// trackPendingSend remembers an unconfirmed send so a later table can resolve it
+func (c *Client) trackPendingSend(otid string, threadID int64) {
+	c.pendingSendsMu.Lock()
+	defer c.pendingSendsMu.Unlock()
+	now := time.Now()
+	for k, p := range c.pendingSends {
+		if now.Sub(p.CreatedAt) > pendingSendTTL {
+			delete(c.pendingSends, k)
+		}
+	}
+	c.pendingSends[otid] = &pendingSend{ThreadID: threadID, CreatedAt: now}
+}
+
+// untrackPendingSend forgets a send that was resolved synchronously
+func (c *Client) untrackPendingSend(otid string) {
+	c.pendingSendsMu.Lock()
+	delete(c.pendingSends, otid)
+	c.pendingSendsMu.Unlock()
+}
+
+// resolvePendingSends emits confirmation or failure events for tracked sends
+func (c *Client) resolvePendingSends(tbl *table.LSTable) {
+	if len(tbl.LSReplaceOptimsiticMessage) == 0 && len(tbl.LSMarkOptimisticMessageFailed) == 0 && len(tbl.LSHandleFailedTask) == 0 {
+		return
+	}
+	if c.Outbox != nil {
+		c.Outbox.resolve(tbl)
+	}
+
+	var confirmed []*MessageSendConfirmedEvent
+	var failed []*SendError
+	c.pendingSendsMu.Lock()
+	for _, r := range tbl.LSReplaceOptimsiticMessage {
+		if p, ok := c.pendingSends[r.OfflineThreadingId]; ok {
+			delete(c.pendingSends, r.OfflineThreadingId)
+			confirmed = append(confirmed, &MessageSendConfirmedEvent{
+				OTID:      r.OfflineThreadingId,
+				MessageID: r.MessageId,
+				ThreadID:  p.ThreadID,
+			})
+		}
+	}
+	for otid, p := range c.pendingSends {
+		if sendErr := findSendFailure(tbl, otid); sendErr != nil {
+			delete(c.pendingSends, otid)
+			sendErr.ThreadID = p.ThreadID
+			failed = append(failed, sendErr)
+		}
+	}
+	c.pendingSendsMu.Unlock()
+
+	for _, evt := range confirmed {
+		c.emitEvent(EventTypeMessageSendConfirmed, evt)
+	}
+	for _, evt := range failed {
+		c.emitEvent(EventTypeMessageSendFailed, evt)
+	}
+}
+
+func (c *Client) sendE2EEMessage(ctx context.Context, opts *SendMessageOptions, msgID string) (*SendMessageResult, error) {
 	chatJID, err := parseJID(opts.E2EEChatJID)
 	if err != nil {
 		return nil, err
 	}
 
+	messageText, err := buildE2EEMessageText(opts.Text, opts.MentionIDs, opts.MentionOffsets, opts.MentionLengths)
+	if err != nil {
+		return nil, err
+	}
+	content := &waConsumerApplication.ConsumerApplication_Content{
+		Content: &waConsumerApplication.ConsumerApplication_Content_MessageText{
+			MessageText: messageText,
+		},
+	}
+	if preview := opts.LinkPreview; preview != nil && preview.URL != "" {
+		matchedText := preview.MatchedText
+		if matchedText == "" {
+			matchedText = preview.URL
+		}
+		extended := &waConsumerApplication.ConsumerApplication_ExtendedTextMessage{
+			Text:         messageText,
+			MatchedText:  &matchedText,
+			CanonicalURL: &preview.URL,
+		}
+		if preview.Title != "" {
+			extended.Title = &preview.Title
+		}
+		if preview.Description != "" {
+			extended.Description = &preview.Description
+		}
+		content.Content = &waConsumerApplication.ConsumerApplication_Content_ExtendedTextMessage{
+			ExtendedTextMessage: extended,
+		}
+	}
+
 	waMsg := &waConsumerApplication.ConsumerApplication{
 		Payload: &waConsumerApplication.ConsumerApplication_Payload{
 			Payload: &waConsumerApplication.ConsumerApplication_Payload_Content{
-				Content: &waConsumerApplication.ConsumerApplication_Content{
-					Content: &waConsumerApplication.ConsumerApplication_Content_MessageText{
-						MessageText: &waCommon.MessageText{Text: &opts.Text},
-					},
-				},
+				Content: content,
 			},
 		},
 	}
@@ -144,8 +322,7 @@ func (c *Client) sendE2EEMessage(opts *SendMessageOptions) (*SendMessageResult,
 		}
 	}
 
-	msgID := strconv.FormatInt(time.Now().UnixNano(), 10)
-	resp, err := c.E2EE.SendFBMessage(c.ctx, chatJID, waMsg, metadata, whatsmeow.SendRequestExtra{ID: msgID})
+	resp, err := c.sendFBMessage(ctx, RateLimitActionSend, chatJID, waMsg, metadata, whatsmeow.SendRequestExtra{ID: msgID})
 	if err != nil {
 		return nil, err
 	}
@@ -157,7 +334,7 @@ func (c *Client) sendE2EEMessage(opts *SendMessageOptions) (*SendMessageResult,
 }
 
 // SendReaction sends a reaction to a message
-func (c *Client) SendReaction(threadID int64, messageID, emoji string) error {
+func (c *Client) SendReaction(ctx context.Context, threadID int64, messageID, emoji string) error {
 	task := &socket.SendReactionTask{
 		ThreadKey:       threadID,
 		MessageID:       messageID,
@@ -165,12 +342,12 @@ func (c *Client) SendReaction(threadID int64, messageID, emoji string) error {
 		ActorID:         c.FBID,
 		SendAttribution: table.MESSENGER_INBOX_IN_THREAD,
 	}
-	_, err := c.Messagix.ExecuteTasks(c.ctx, task)
+	_, err := c.executeTasks(ctx, RateLimitActionReact, threadID, task)
 	return err
 }
 
 // SendE2EEReaction sends an E2EE reaction
-func (c *Client) SendE2EEReaction(chatJIDStr, messageID, senderJIDStr, emoji string) error {
+func (c *Client) SendE2EEReaction(ctx context.Context, chatJIDStr, messageID, senderJIDStr, emoji string) error {
 	if c.E2EE == nil || !c.E2EE.IsConnected() {
 		return ErrE2EENotConnected
 	}
@@ -201,31 +378,31 @@ func (c *Client) SendE2EEReaction(chatJIDStr, messageID, senderJIDStr, emoji str
 	}
 
 	reactionID := strconv.FormatInt(time.Now().UnixNano(), 10)
-	_, err = c.E2EE.SendFBMessage(c.ctx, chatJID, reactionMsg, nil, whatsmeow.SendRequestExtra{ID: reactionID})
+	_, err = c.sendFBMessage(ctx, RateLimitActionReact, chatJID, reactionMsg, nil, whatsmeow.SendRequestExtra{ID: reactionID})
 	return err
 }
 
 // EditMessage edits a message
-func (c *Client) EditMessage(messageID, newText string) error {
+func (c *Client) EditMessage(ctx context.Context, messageID, newText string) error {
 	task := &socket.EditMessageTask{
 		MessageID: messageID,
 		Text:      newText,
 	}
-	_, err := c.Messagix.ExecuteTasks(c.ctx, task)
+	_, err := c.executeTasks(ctx, RateLimitActionSend, 0, task)
 	return err
 }
 
 // UnsendMessage unsends/deletes a message
-func (c *Client) UnsendMessage(messageID string) error {
+func (c *Client) UnsendMessage(ctx context.Context, messageID string) error {
 	task := &socket.DeleteMessageTask{
 		MessageId: messageID,
 	}
-	_, err := c.Messagix.ExecuteTasks(c.ctx, task)
+	_, err := c.executeTasks(ctx, RateLimitActionSend, 0, task)
 	return err
 }
 
 // SendTypingIndicator sends a typing indicator
-func (c *Client) SendTypingIndicator(threadID int64, isTyping bool, isGroup bool, threadType int64) error {
+func (c *Client) SendTypingIndicator(ctx context.Context, threadID int64, isTyping bool, isGroup bool, threadType int64) error {
 	typingVal, groupVal := int64(0), int64(0)
 	if isTyping {
 		typingVal = 1
@@ -242,11 +419,14 @@ func (c *Client) SendTypingIndicator(threadID int64, isTyping bool, isGroup bool
 		SyncGroup:     1,
 		ThreadType:    threadType,
 	}
-	return c.Messagix.ExecuteStatelessTask(c.ctx, task)
+	if err := c.throttle(ctx, RateLimitActionTyping, strconv.FormatInt(threadID, 10)); err != nil {
+		return err
+	}
+	return c.Messagix.ExecuteStatelessTask(ctx, task)
 }
 
 // MarkRead marks messages as read
-func (c *Client) MarkRead(threadID int64, watermarkTs int64) error {
+func (c *Client) MarkRead(ctx context.Context, threadID int64, watermarkTs int64) error {
 	if watermarkTs == 0 {
 		watermarkTs = time.Now().UnixMilli()
 	}
@@ -255,21 +435,36 @@ func (c *Client) MarkRead(threadID int64, watermarkTs int64) error {
 		LastReadWatermarkTs: watermarkTs,
 		SyncGroup:           1,
 	}
-	_, err := c.Messagix.ExecuteTasks(c.ctx, task)
+	_, err := c.executeTasks(ctx, RateLimitActionManage, threadID, task)
 	return err
 }
 
 // Helper functions
+
+// validateMentions checks that every mention has a UTF-16 span inside the text
+func validateMentions(text string, ids []int64, offsets, lengths []int) error {
+	if len(ids) == 0 && len(offsets) == 0 && len(lengths) == 0 {
+		return nil
+	}
+	if len(offsets) != len(ids) || len(lengths) != len(ids) {
+		return fmt.Errorf("mention ids, offsets and lengths must have the same length (got %d, %d, %d)", len(ids), len(offsets), len(lengths))
+	}
+	textLen := len(utf16.Encode([]rune(text)))
+	for i := range ids {
+		start, end := offsets[i], offsets[i]+lengths[i]
+		if lengths[i] <= 0 || start < 0 || end <= start || end > textLen {
+			return fmt.Errorf("mention %d has an invalid span (offset %d, length %d, text length %d)", i, offsets[i], lengths[i], textLen)
+		}
+	}
+	return nil
+}
+
 func buildMentionData(ids []int64, offsets, lengths []int) *socket.MentionData {
 	var idStrs, offsetStrs, lengthStrs, typeStrs []string
 	for i, id := range ids {
 		idStrs = append(idStrs, strconv.FormatInt(id, 10))
-		if i < len(offsets) {
-			offsetStrs = append(offsetStrs, strconv.Itoa(offsets[i]))
-		}
-		if i < len(lengths) {
-			lengthStrs = append(lengthStrs, strconv.Itoa(lengths[i]))
-		}
+		offsetStrs = append(offsetStrs, strconv.Itoa(offsets[i]))
+		lengthStrs = append(lengthStrs, strconv.Itoa(lengths[i]))
 		typeStrs = append(typeStrs, "p")
 	}
 	return &socket.MentionData{
@@ -280,6 +475,52 @@ func buildMentionData(ids []int64, offsets, lengths []int) *socket.MentionData {
 	}
 }
 
+// buildE2EEMessageText converts Messenger-style mentions (UTF-16 offsets and lengths into text)
+// to the E2EE format, where each mention is written as "@<jid>" in the text and listed in MentionedJID.
+// Overlapping mentions still notify the user but only the first one replaces text.
+func buildE2EEMessageText(text string, ids []int64, offsets, lengths []int) (*waCommon.MessageText, error) {
+	msgText := &waCommon.MessageText{Text: &text}
+	if err := validateMentions(text, ids, offsets, lengths); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return msgText, nil
+	}
+
+	type span struct {
+		jid        string
+		start, end int
+	}
+	utf16Text := utf16.Encode([]rune(text))
+	spans := make([]span, 0, len(ids))
+	for i, id := range ids {
+		spans = append(spans, span{
+			jid:   waTypes.NewJID(strconv.FormatInt(id, 10), waTypes.MessengerServer).String(),
+			start: offsets[i],
+			end:   offsets[i] + lengths[i],
+		})
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+
+	var output []uint16
+	prevEnd := 0
+	for _, sp := range spans {
+		msgText.MentionedJID = append(msgText.MentionedJID, sp.jid)
+		if sp.start < prevEnd {
+			continue
+		}
+		output = append(output, utf16Text[prevEnd:sp.start]...)
+		output = append(output, utf16.Encode([]rune("@"+sp.jid))...)
+		prevEnd = sp.end
+	}
+	output = append(output, utf16Text[prevEnd:]...)
+	converted := string(utf16.Decode(output))
+	msgText.Text = &converted
+	return msgText, nil
+}
+
 func joinStrings(strs []string) string {
 	result := ""
 	for i, s := range strs {
@@ -291,10 +532,6 @@ func joinStrings(strs []string) string {
 	return result
 }
 
-func generateMID(otid int64) string {
-	return "mid.$" + strconv.FormatInt(otid, 10)
-}
-
 func parseJID(jidStr string) (waTypes.JID, error) {
 	if jidStr == "" {
 		return waTypes.EmptyJID, nil
@@ -303,7 +540,7 @@ func parseJID(jidStr string) (waTypes.JID, error) {
 }
 
 // E2EE send typing
-func (c *Client) SendE2EETyping(chatJIDStr string, isTyping bool) error {
+func (c *Client) SendE2EETyping(ctx context.Context, chatJIDStr string, isTyping bool) error {
 	if c.E2EE == nil || !c.E2EE.IsConnected() {
 		return ErrE2EENotConnected
 	}
@@ -317,11 +554,36 @@ func (c *Client) SendE2EETyping(chatJIDStr string, isTyping bool) error {
 	if isTyping {
 		presence = waTypes.ChatPresenceComposing
 	}
-	return c.E2EE.SendChatPresence(context.Background(), chatJID, presence, waTypes.ChatPresenceMediaText)
+	if err := c.throttle(ctx, RateLimitActionTyping, chatJID.User); err != nil {
+		return err
+	}
+	return c.E2EE.SendChatPresence(ctx, chatJID, presence, waTypes.ChatPresenceMediaText)
+}
+
+// MarkE2EERead sends read receipts for E2EE messages.
+// senderJID is the sender of the messages and is required in groups.
+func (c *Client) MarkE2EERead(ctx context.Context, chatJIDStr string, messageIDs []string, senderJIDStr string) error {
+	if c.E2EE == nil || !c.E2EE.IsConnected() {
+		return ErrE2EENotConnected
+	}
+
+	chatJID, err := parseJID(chatJIDStr)
+	if err != nil {
+		return err
+	}
+	var senderJID waTypes.JID
+	if senderJIDStr != "" {
+		if senderJID, err = parseJID(senderJIDStr); err != nil {
+			return err
+		}
+	} else if chatJID.Server == waTypes.GroupServer {
+		return fmt.Errorf("sender is required to mark group messages as read")
+	}
+	return c.E2EE.MarkRead(ctx, messageIDs, time.Now(), chatJID, senderJID)
 }
 
 // EditE2EEMessage edits an E2EE message
-func (c *Client) EditE2EEMessage(chatJIDStr, messageID, newText string) error {
+func (c *Client) EditE2EEMessage(ctx context.Context, chatJIDStr, messageID, newText string) error {
 	if c.E2EE == nil || !c.E2EE.IsConnected() {
 		return ErrE2EENotConnected
 	}
@@ -350,12 +612,12 @@ func (c *Client) EditE2EEMessage(chatJIDStr, messageID, newText string) error {
 	}
 
 	editID := strconv.FormatInt(time.Now().UnixNano(), 10)
-	_, err = c.E2EE.SendFBMessage(c.ctx, chatJID, editMsg, nil, whatsmeow.SendRequestExtra{ID: editID})
+	_, err = c.sendFBMessage(ctx, RateLimitActionSend, chatJID, editMsg, nil, whatsmeow.SendRequestExtra{ID: editID})
 	return err
 }
 
 // UnsendE2EEMessage unsends/deletes an E2EE message
-func (c *Client) UnsendE2EEMessage(chatJIDStr, messageID string) error {
+func (c *Client) UnsendE2EEMessage(ctx context.Context, chatJIDStr, messageID string) error {
 	if c.E2EE == nil || !c.E2EE.IsConnected() {
 		return ErrE2EENotConnected
 	}
@@ -379,6 +641,6 @@ func (c *Client) UnsendE2EEMessage(chatJIDStr, messageID string) error {
 	}
 
 	revokeID := strconv.FormatInt(time.Now().UnixNano(), 10)
-	_, err = c.E2EE.SendFBMessage(c.ctx, chatJID, revokeMsg, nil, whatsmeow.SendRequestExtra{ID: revokeID})
+	_, err = c.sendFBMessage(ctx, RateLimitActionSend, chatJID, revokeMsg, nil, whatsmeow.SendRequestExtra{ID: revokeID})
 	return err
 }
@@ -0,0 +1,105 @@
+package bridge
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBuildE2EEMessageText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		ids      []int64
+		offsets  []int
+		lengths  []int
+		wantText string
+		wantJIDs []string
+		wantErr  bool
+	}{
+		{
+			name:     "no mentions",
+			text:     "hello",
+			wantText: "hello",
+		},
+		{
+			name:     "single mention",
+			text:     "hi Bob!",
+			ids:      []int64{100},
+			offsets:  []int{3},
+			lengths:  []int{3},
+			wantText: "hi @100@msgr!",
+			wantJIDs: []string{"100@msgr"},
+		},
+		{
+			name:     "unsorted mentions",
+			text:     "Ann and Bob",
+			ids:      []int64{2, 1},
+			offsets:  []int{8, 0},
+			lengths:  []int{3, 3},
+			wantText: "@1@msgr and @2@msgr",
+			wantJIDs: []string{"1@msgr", "2@msgr"},
+		},
+		{
+			name:     "utf-16 offsets after emoji",
+			text:     "😀 Bob 😀",
+			ids:      []int64{7},
+			offsets:  []int{3},
+			lengths:  []int{3},
+			wantText: "😀 @7@msgr 😀",
+			wantJIDs: []string{"7@msgr"},
+		},
+		{
+			name:     "mention at end of text",
+			text:     "cc Ann",
+			ids:      []int64{5},
+			offsets:  []int{3},
+			lengths:  []int{3},
+			wantText: "cc @5@msgr",
+			wantJIDs: []string{"5@msgr"},
+		},
+		{
+			name:     "overlapping mention only notifies",
+			text:     "Ann Bob",
+			ids:      []int64{1, 2},
+			offsets:  []int{0, 2},
+			lengths:  []int{3, 3},
+			wantText: "@1@msgr Bob",
+			wantJIDs: []string{"1@msgr", "2@msgr"},
+		},
+		{name: "missing offsets", text: "hi Bob", ids: []int64{1}, lengths: []int{3}, wantErr: true},
+		{name: "extra lengths", text: "hi Bob", ids: []int64{1}, offsets: []int{3}, lengths: []int{3, 1}, wantErr: true},
+		{name: "offsets without ids", text: "hi Bob", offsets: []int{3}, lengths: []int{3}, wantErr: true},
+		{name: "zero length", text: "hi Bob", ids: []int64{1}, offsets: []int{3}, lengths: []int{0}, wantErr: true},
+		{name: "negative length", text: "hi Bob", ids: []int64{1}, offsets: []int{3}, lengths: []int{-2}, wantErr: true},
+		{name: "negative offset", text: "hi Bob", ids: []int64{1}, offsets: []int{-1}, lengths: []int{3}, wantErr: true},
+		{name: "past end of text", text: "hi Bob", ids: []int64{1}, offsets: []int{4}, lengths: []int{3}, wantErr: true},
+		{name: "offset at end of text", text: "hi Bob", ids: []int64{1}, offsets: []int{6}, lengths: []int{1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildE2EEMessageText(tt.text, tt.ids, tt.offsets, tt.lengths)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("buildE2EEMessageText() = %q, want error", got.GetText())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.GetText() != tt.wantText {
+				t.Errorf("text = %q, want %q", got.GetText(), tt.wantText)
+			}
+			if !slices.Equal(got.MentionedJID, tt.wantJIDs) {
+				t.Errorf("MentionedJID = %v, want %v", got.MentionedJID, tt.wantJIDs)
+			}
+		})
+	}
+}
+
+func TestBuildMentionData(t *testing.T) {
+	got := buildMentionData([]int64{1, 22}, []int{0, 4}, []int{3, 5})
+	if got.MentionIDs != "1,22" || got.MentionOffsets != "0,4" || got.MentionLengths != "3,5" || got.MentionTypes != "p,p" {
+		t.Errorf("buildMentionData() = %+v", got)
+	}
+}
@@ -0,0 +1,321 @@
+package bridge
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+
+	"go.mau.fi/mautrix-meta/pkg/messagix/socket"
+)
+
+// Histogram bucket upper bounds
+var (
+	taskLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}                   // seconds
+	uploadSizeBuckets  = []float64{16 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20} // bytes
+)
+
+// Metrics counts what happens inside a client. A nil *Metrics records nothing,
+// so clients created without metrics don't need checks at every call site.
+type Metrics struct {
+	eventsEmitted atomic.Uint64
+	eventsDropped atomic.Uint64
+	reconnects    atomic.Uint64
+
+	mu           sync.Mutex
+	sendFailures map[string]uint64     // key: rate limit action
+	tasks        map[string]*histogram // key: task name
+	uploads      map[string]*histogram // key: media kind
+}
+
+// NewMetrics creates an empty metrics registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		sendFailures: make(map[string]uint64),
+		tasks:        make(map[string]*histogram),
+		uploads:      make(map[string]*histogram),
+	}
+}
+
+// HistogramBucket is the number of observations less than or equal to Le
+type HistogramBucket struct {
+	Le    float64 `json:"le"`
+	Count uint64  `json:"count"`
+}
+
+// HistogramSnapshot is a cumulative histogram. Count includes the observations
+// above the last bucket.
+type HistogramSnapshot struct {
+	Count   uint64            `json:"count"`
+	Sum     float64           `json:"sum"`
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+// MetricsSnapshot is the state of a client's metrics at one point in time
+type MetricsSnapshot struct {
+	Handle             uint64                        `json:"handle"`
+	UserID             int64                         `json:"userId,omitempty"`
+	EventQueueDepth    int                           `json:"eventQueueDepth"`
+	EventQueueCapacity int                           `json:"eventQueueCapacity"`
+	EventsEmitted      uint64                        `json:"eventsEmitted"`
+	EventsDropped      uint64                        `json:"eventsDropped"`
+	Reconnects         uint64                        `json:"reconnects"`
+	SendFailures       map[string]uint64             `json:"sendFailures"` // keyed by action: send, react or upload
+	TaskLatency        map[string]*HistogramSnapshot `json:"taskLatency"`  // seconds, keyed by task name
+	TaskErrors         map[string]uint64             `json:"taskErrors"`   // keyed by task name
+	UploadSizes        map[string]*HistogramSnapshot `json:"uploadSizes"`  // bytes, keyed by media kind
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64 // not cumulative, the last entry counts observations above all bounds
+	sum    float64
+	errors uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	h.counts[sort.SearchFloat64s(h.bounds, value)]++
+	h.sum += value
+}
+
+func (h *histogram) snapshot() *HistogramSnapshot {
+	snap := &HistogramSnapshot{Sum: h.sum, Buckets: make([]HistogramBucket, 0, len(h.bounds))}
+	for i, count := range h.counts {
+		snap.Count += count
+		if i < len(h.bounds) {
+			snap.Buckets = append(snap.Buckets, HistogramBucket{Le: h.bounds[i], Count: snap.Count})
+		}
+	}
+	return snap
+}
+
+var (
+	taskNamesOnce sync.Once
+	taskNames     map[string]string // key: task label
+)
+
+// taskName maps a task label back to its name in socket.TaskLabels
+func taskName(label string) string {
+	taskNamesOnce.Do(func() {
+		taskNames = make(map[string]string, len(socket.TaskLabels))
+		for name, l := range socket.TaskLabels {
+			// Some labels are shared, use the first name alphabetically so it doesn't change between runs
+			if prev, ok := taskNames[l]; !ok || name < prev {
+				taskNames[l] = name
+			}
+		}
+	})
+	if name, ok := taskNames[label]; ok {
+		return name
+	}
+	return "label_" + label
+}
+
+func uploadKind(mediaType whatsmeow.MediaType) string {
+	switch mediaType {
+	case whatsmeow.MediaImage:
+		return "image"
+	case whatsmeow.MediaVideo:
+		return "video"
+	case whatsmeow.MediaAudio:
+		return "audio"
+	case whatsmeow.MediaDocument:
+		return "document"
+	default:
+		return "other"
+	}
+}
+
+func (m *Metrics) eventEmitted() {
+	if m != nil {
+		m.eventsEmitted.Add(1)
+	}
+}
+
+func (m *Metrics) eventDropped() {
+	if m != nil {
+		m.eventsDropped.Add(1)
+	}
+}
+
+func (m *Metrics) reconnected() {
+	if m != nil {
+		m.reconnects.Add(1)
+	}
+}
+
+// sendFailed counts a failed send, reaction or upload. Other actions aren't sends,
+// their task failures are counted in the task errors instead.
+func (m *Metrics) sendFailed(action string) {
+	if m == nil {
+		return
+	}
+	switch action {
+	case RateLimitActionSend, RateLimitActionReact, RateLimitActionUpload:
+	default:
+		return
+	}
+	m.mu.Lock()
+	m.sendFailures[action]++
+	m.mu.Unlock()
+}
+
+// observeTasks records the latency of a batch of tasks under the name of each task in it
+func (m *Metrics) observeTasks(tasks []socket.Task, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[string]struct{}, len(tasks))
+	for _, task := range tasks {
+		name := taskName(task.GetLabel())
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		h, ok := m.tasks[name]
+		if !ok {
+			h = newHistogram(taskLatencyBuckets)
+			m.tasks[name] = h
+		}
+		h.observe(elapsed.Seconds())
+		if err != nil {
+			h.errors++
+		}
+	}
+}
+
+func (m *Metrics) observeUpload(kind string, size int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.uploads[kind]
+	if !ok {
+		h = newHistogram(uploadSizeBuckets)
+		m.uploads[kind] = h
+	}
+	h.observe(float64(size))
+}
+
+// Metrics returns a snapshot of the client's metrics, or nil if they're disabled
+func (c *Client) Metrics() *MetricsSnapshot {
+	m := c.metrics
+	if m == nil {
+		return nil
+	}
+	snap := &MetricsSnapshot{
+		Handle:             c.ID,
+		UserID:             c.FBID,
+		EventQueueDepth:    len(c.eventChan),
+		EventQueueCapacity: cap(c.eventChan),
+		EventsEmitted:      m.eventsEmitted.Load(),
+		EventsDropped:      m.eventsDropped.Load(),
+		Reconnects:         m.reconnects.Load(),
+		SendFailures:       make(map[string]uint64),
+		TaskLatency:        make(map[string]*HistogramSnapshot),
+		TaskErrors:         make(map[string]uint64),
+		UploadSizes:        make(map[string]*HistogramSnapshot),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for action, count := range m.sendFailures {
+		snap.SendFailures[action] = count
+	}
+	for name, h := range m.tasks {
+		snap.TaskLatency[name] = h.snapshot()
+		snap.TaskErrors[name] = h.errors
+	}
+	for kind, h := range m.uploads {
+		snap.UploadSizes[kind] = h.snapshot()
+	}
+	return snap
+}
+
+// writePrometheus writes snapshots in the Prometheus text exposition format
+func writePrometheus(w io.Writer, snaps []*MetricsSnapshot) {
+	labels := func(snap *MetricsSnapshot, extra ...string) string {
+		parts := []string{
+			fmt.Sprintf(`handle="%d"`, snap.Handle),
+			fmt.Sprintf(`account="%d"`, snap.UserID),
+		}
+		for i := 0; i+1 < len(extra); i += 2 {
+			parts = append(parts, fmt.Sprintf(`%s=%q`, extra[i], extra[i+1]))
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	}
+	simple := func(name, kind, help string, value func(*MetricsSnapshot) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, snap := range snaps {
+			fmt.Fprintf(w, "%s%s %s\n", name, labels(snap), formatFloat(value(snap)))
+		}
+	}
+	labelled := func(name, help, label string, values func(*MetricsSnapshot) map[string]uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, snap := range snaps {
+			m := values(snap)
+			for _, key := range sortedKeys(m) {
+				fmt.Fprintf(w, "%s%s %d\n", name, labels(snap, label, key), m[key])
+			}
+		}
+	}
+	histograms := func(name, help, label string, values func(*MetricsSnapshot) map[string]*HistogramSnapshot) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		for _, snap := range snaps {
+			m := values(snap)
+			for _, key := range sortedKeys(m) {
+				h := m[key]
+				for _, b := range h.Buckets {
+					fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(snap, label, key, "le", formatFloat(b.Le)), b.Count)
+				}
+				fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(snap, label, key, "le", "+Inf"), h.Count)
+				fmt.Fprintf(w, "%s_sum%s %s\n", name, labels(snap, label, key), formatFloat(h.Sum))
+				fmt.Fprintf(w, "%s_count%s %d\n", name, labels(snap, label, key), h.Count)
+			}
+		}
+	}
+
+	simple("messagix_event_queue_depth", "gauge", "Events waiting in the client's queue.",
+		func(s *MetricsSnapshot) float64 { return float64(s.EventQueueDepth) })
+	simple("messagix_event_queue_capacity", "gauge", "Size of the client's event queue.",
+		func(s *MetricsSnapshot) float64 { return float64(s.EventQueueCapacity) })
+	simple("messagix_events_emitted_total", "counter", "Events emitted by the client.",
+		func(s *MetricsSnapshot) float64 { return float64(s.EventsEmitted) })
+	simple("messagix_events_dropped_total", "counter", "Events dropped because the queue was full.",
+		func(s *MetricsSnapshot) float64 { return float64(s.EventsDropped) })
+	simple("messagix_reconnects_total", "counter", "Reconnects of the LightSpeed socket.",
+		func(s *MetricsSnapshot) float64 { return float64(s.Reconnects) })
+	labelled("messagix_send_failures_total", "Failed outgoing actions.", "action",
+		func(s *MetricsSnapshot) map[string]uint64 { return s.SendFailures })
+	labelled("messagix_task_errors_total", "Failed LightSpeed task requests.", "task",
+		func(s *MetricsSnapshot) map[string]uint64 { return s.TaskErrors })
+	histograms("messagix_task_duration_seconds", "Latency of LightSpeed task requests.", "task",
+		func(s *MetricsSnapshot) map[string]*HistogramSnapshot { return s.TaskLatency })
+	histograms("messagix_upload_size_bytes", "Size of uploaded media.", "kind",
+		func(s *MetricsSnapshot) map[string]*HistogramSnapshot { return s.UploadSizes })
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,153 @@
+package bridge
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHistogramSnapshot(t *testing.T) {
+	tests := []struct {
+		name   string
+		bounds []float64
+		values []float64
+		want   *HistogramSnapshot
+	}{
+		{
+			name:   "empty",
+			bounds: []float64{1, 2},
+			want: &HistogramSnapshot{Buckets: []HistogramBucket{
+				{Le: 1, Count: 0},
+				{Le: 2, Count: 0},
+			}},
+		},
+		{
+			name:   "cumulative",
+			bounds: []float64{1, 2, 5},
+			values: []float64{0.5, 1.5, 1.5, 4},
+			want: &HistogramSnapshot{Count: 4, Sum: 7.5, Buckets: []HistogramBucket{
+				{Le: 1, Count: 1},
+				{Le: 2, Count: 3},
+				{Le: 5, Count: 4},
+			}},
+		},
+		{
+			name:   "bound is inclusive",
+			bounds: []float64{1, 2},
+			values: []float64{1, 2},
+			want: &HistogramSnapshot{Count: 2, Sum: 3, Buckets: []HistogramBucket{
+				{Le: 1, Count: 1},
+				{Le: 2, Count: 2},
+			}},
+		},
+		{
+			name:   "above last bucket only in count",
+			bounds: []float64{1, 2},
+			values: []float64{0.5, 10},
+			want: &HistogramSnapshot{Count: 2, Sum: 10.5, Buckets: []HistogramBucket{
+				{Le: 1, Count: 1},
+				{Le: 2, Count: 1},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHistogram(tt.bounds)
+			for _, v := range tt.values {
+				h.observe(v)
+			}
+			if got := h.snapshot(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("snapshot() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendFailed(t *testing.T) {
+	m := NewMetrics()
+	for _, action := range []string{
+		RateLimitActionSend, RateLimitActionReact, RateLimitActionUpload, RateLimitActionUpload,
+		RateLimitActionManage, RateLimitActionQuery, RateLimitActionTyping,
+	} {
+		m.sendFailed(action)
+	}
+	want := map[string]uint64{"send": 1, "react": 1, "upload": 2}
+	if !reflect.DeepEqual(m.sendFailures, want) {
+		t.Errorf("sendFailures = %v, want %v", m.sendFailures, want)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	snap := &MetricsSnapshot{
+		Handle:             1,
+		UserID:             100,
+		EventQueueDepth:    3,
+		EventQueueCapacity: 100,
+		EventsEmitted:      42,
+		SendFailures:       map[string]uint64{"send": 2, "react": 1},
+		TaskErrors:         map[string]uint64{"SendMessageTask": 1},
+		TaskLatency: map[string]*HistogramSnapshot{
+			"SendMessageTask": {Count: 3, Sum: 1.25, Buckets: []HistogramBucket{
+				{Le: 0.5, Count: 2},
+				{Le: 1, Count: 2},
+			}},
+		},
+		UploadSizes: map[string]*HistogramSnapshot{},
+	}
+	var buf bytes.Buffer
+	writePrometheus(&buf, []*MetricsSnapshot{snap})
+	out := buf.String()
+
+	wantLines := []string{
+		"# HELP messagix_event_queue_depth Events waiting in the client's queue.",
+		"# TYPE messagix_event_queue_depth gauge",
+		`messagix_event_queue_depth{handle="1",account="100"} 3`,
+		`messagix_events_emitted_total{handle="1",account="100"} 42`,
+		`messagix_events_dropped_total{handle="1",account="100"} 0`,
+		"# TYPE messagix_send_failures_total counter",
+		`messagix_send_failures_total{handle="1",account="100",action="react"} 1`,
+		`messagix_send_failures_total{handle="1",account="100",action="send"} 2`,
+		`messagix_task_errors_total{handle="1",account="100",task="SendMessageTask"} 1`,
+		"# TYPE messagix_task_duration_seconds histogram",
+		`messagix_task_duration_seconds_bucket{handle="1",account="100",task="SendMessageTask",le="0.5"} 2`,
+		`messagix_task_duration_seconds_bucket{handle="1",account="100",task="SendMessageTask",le="1"} 2`,
+		`messagix_task_duration_seconds_bucket{handle="1",account="100",task="SendMessageTask",le="+Inf"} 3`,
+		`messagix_task_duration_seconds_sum{handle="1",account="100",task="SendMessageTask"} 1.25`,
+		`messagix_task_duration_seconds_count{handle="1",account="100",task="SendMessageTask"} 3`,
+		"# TYPE messagix_upload_size_bytes histogram",
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	index := make(map[string]int, len(lines))
+	for i, line := range lines {
+		index[line] = i
+	}
+	prev := -1
+	for _, want := range wantLines {
+		i, ok := index[want]
+		if !ok {
+			t.Errorf("missing line %q in output:\n%s", want, out)
+			continue
+		}
+		if i < prev {
+			t.Errorf("line %q is out of order", want)
+		}
+		prev = i
+	}
+	if strings.Contains(out, "messagix_upload_size_bytes_bucket") {
+		t.Errorf("empty upload histogram has samples:\n%s", out)
+	}
+}
+
+func TestMetricsNil(t *testing.T) {
+	var m *Metrics
+	m.eventEmitted()
+	m.eventDropped()
+	m.reconnected()
+	m.sendFailed(RateLimitActionSend)
+	m.observeTasks(nil, 0, nil)
+	m.observeUpload("image", 1)
+	if snap := (&Client{}).Metrics(); snap != nil {
+		t.Errorf("Metrics() = %+v, want nil without metrics", snap)
+	}
+}
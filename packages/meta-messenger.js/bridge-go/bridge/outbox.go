@@ -0,0 +1,447 @@
+package bridge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mau.fi/mautrix-meta/pkg/messagix/table"
+)
+
+// OutboxConfig enables the persistent outbox
+type OutboxConfig struct {
+	Path        string `json:"path,omitempty"`        // JSON file for persistence, empty = memory only
+	MaxAttempts int    `json:"maxAttempts,omitempty"` // 0 = default (10)
+	BaseDelayMs int64  `json:"baseDelayMs,omitempty"` // 0 = default (1000)
+	MaxDelayMs  int64  `json:"maxDelayMs,omitempty"`  // 0 = default (300000)
+	RetentionMs int64  `json:"retentionMs,omitempty"` // how long finished items are kept for deduplication, 0 = default (24h)
+}
+
+// OutboxStatus is the state of an outbox item
+type OutboxStatus string
+
+const (
+	OutboxStatusQueued  OutboxStatus = "queued"
+	OutboxStatusSending OutboxStatus = "sending"
+	OutboxStatusPending OutboxStatus = "pending" // accepted by Meta, waiting for confirmation
+	OutboxStatusSent    OutboxStatus = "sent"
+	OutboxStatusFailed  OutboxStatus = "failed"
+)
+
+// OutboxItem is a message waiting to be sent (or already sent) through the outbox
+type OutboxItem struct {
+	Key             string             `json:"key"`
+	OTID            int64              `json:"otid"`
+	Options         SendMessageOptions `json:"options"`
+	Status          OutboxStatus       `json:"status"`
+	Attempts        int                `json:"attempts"`
+	LastError       string             `json:"lastError,omitempty"`
+	MessageID       string             `json:"messageId,omitempty"`
+	CreatedAtMs     int64              `json:"createdAtMs"`
+	UpdatedAtMs     int64              `json:"updatedAtMs"`
+	NextAttemptAtMs int64              `json:"nextAttemptAtMs,omitempty"`
+
+	// Failure that arrived while the item was still being sent
+	sendErr *SendError
+}
+
+// Outbox queues outgoing messages and retries them with backoff across reconnects
+type Outbox struct {
+	client *Client
+	cfg    OutboxConfig
+	mu     sync.Mutex
+	items  map[string]*OutboxItem
+	wake   chan struct{}
+	done   chan struct{} // closed when run returns
+}
+
+// ErrOutboxDisabled error when the outbox was not configured
+var ErrOutboxDisabled = errors.New("outbox not enabled")
+
+func newOutbox(client *Client, cfg *OutboxConfig) (*Outbox, error) {
+	ob := &Outbox{
+		client: client,
+		cfg:    *cfg,
+		items:  make(map[string]*OutboxItem),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	if ob.cfg.MaxAttempts <= 0 {
+		ob.cfg.MaxAttempts = 10
+	}
+	if ob.cfg.BaseDelayMs <= 0 {
+		ob.cfg.BaseDelayMs = 1000
+	}
+	if ob.cfg.MaxDelayMs <= 0 {
+		ob.cfg.MaxDelayMs = 5 * 60 * 1000
+	}
+	if ob.cfg.RetentionMs <= 0 {
+		ob.cfg.RetentionMs = 24 * 60 * 60 * 1000
+	}
+
+	if ob.cfg.Path != "" {
+		if data, err := os.ReadFile(ob.cfg.Path); err == nil {
+			var items []*OutboxItem
+			if err := json.Unmarshal(data, &items); err != nil {
+				return nil, fmt.Errorf("failed to load outbox: %w", err)
+			}
+			for _, item := range items {
+				// Items that were mid-send when the process died are retried
+				if item.Status == OutboxStatusSending {
+					item.Status = OutboxStatusQueued
+				}
+				ob.items[item.Key] = item
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read outbox: %w", err)
+		}
+	}
+
+	return ob, nil
+}
+
+// Enqueue adds a message to the outbox. If an item with the same key exists,
+// the existing item is returned and nothing new is sent.
+func (ob *Outbox) Enqueue(key string, opts *SendMessageOptions) (*OutboxItem, error) {
+	if key == "" {
+		return nil, errors.New("idempotency key is required")
+	}
+	if err := validateMentions(opts.Text, opts.MentionIDs, opts.MentionOffsets, opts.MentionLengths); err != nil {
+		return nil, err
+	}
+
+	ob.mu.Lock()
+	if existing, ok := ob.items[key]; ok {
+		item := *existing
+		ob.mu.Unlock()
+		return &item, nil
+	}
+	now := time.Now().UnixMilli()
+	item := &OutboxItem{
+		Key:         key,
+		OTID:        time.Now().UnixNano(),
+		Options:     *opts,
+		Status:      OutboxStatusQueued,
+		CreatedAtMs: now,
+		UpdatedAtMs: now,
+	}
+	ob.items[key] = item
+	snapshot := *item
+	err := ob.saveLocked()
+	ob.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	ob.client.emitEvent(EventTypeOutboxStatus, &snapshot)
+	ob.Kick()
+	return &snapshot, nil
+}
+
+// Get returns a copy of the item with the given key
+func (ob *Outbox) Get(key string) (*OutboxItem, bool) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	item, ok := ob.items[key]
+	if !ok {
+		return nil, false
+	}
+	snapshot := *item
+	return &snapshot, true
+}
+
+// List returns copies of all items ordered by creation time
+func (ob *Outbox) List() []*OutboxItem {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	result := make([]*OutboxItem, 0, len(ob.items))
+	for _, item := range ob.items {
+		snapshot := *item
+		result = append(result, &snapshot)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAtMs < result[j].CreatedAtMs
+	})
+	return result
+}
+
+// Remove drops an item that is not currently being sent
+func (ob *Outbox) Remove(key string) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	item, ok := ob.items[key]
+	if !ok {
+		return fmt.Errorf("outbox item not found: %s", key)
+	}
+	if item.Status == OutboxStatusSending {
+		return fmt.Errorf("outbox item is being sent: %s", key)
+	}
+	delete(ob.items, key)
+	return ob.saveLocked()
+}
+
+// Kick wakes the worker, e.g. after a reconnect
+func (ob *Outbox) Kick() {
+	select {
+	case ob.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run is the outbox worker loop, it stops when the client context is cancelled
+func (ob *Outbox) run() {
+	defer close(ob.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ob.client.ctx.Done():
+			return
+		case <-ob.wake:
+		case <-ticker.C:
+		}
+		ob.processDue()
+	}
+}
+
+// processDue sends all items whose next attempt is due, oldest first
+func (ob *Outbox) processDue() {
+	now := time.Now().UnixMilli()
+
+	ob.mu.Lock()
+	pruned := false
+	due := make([]*OutboxItem, 0)
+	var expired []OutboxItem
+	for key, item := range ob.items {
+		switch item.Status {
+		case OutboxStatusQueued:
+			if item.NextAttemptAtMs <= now {
+				due = append(due, item)
+			}
+		case OutboxStatusPending:
+			// Sending again could duplicate the message, so unconfirmed sends fail
+			if item.NextAttemptAtMs <= now {
+				item.Status = OutboxStatusFailed
+				item.LastError = "send was not confirmed"
+				item.NextAttemptAtMs = 0
+				item.UpdatedAtMs = now
+				expired = append(expired, *item)
+				pruned = true
+			}
+		case OutboxStatusSent, OutboxStatusFailed:
+			if now-item.UpdatedAtMs > ob.cfg.RetentionMs {
+				delete(ob.items, key)
+				pruned = true
+			}
+		}
+	}
+	if pruned {
+		ob.saveLocked()
+	}
+	ob.mu.Unlock()
+
+	for i := range expired {
+		ob.client.emitEvent(EventTypeOutboxStatus, &expired[i])
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].CreatedAtMs < due[j].CreatedAtMs
+	})
+	for _, item := range due {
+		if ob.client.ctx.Err() != nil {
+			return
+		}
+		if !ob.canSend(item) {
+			continue
+		}
+		ob.attempt(item)
+	}
+}
+
+// canSend checks whether the transport needed by the item is available
+func (ob *Outbox) canSend(item *OutboxItem) bool {
+	if item.Options.IsE2EE {
+		return ob.client.IsE2EEConnected()
+	}
+	return ob.client.Messagix != nil && ob.client.Messagix.IsConnected()
+}
+
+// attempt sends a single item and records the outcome
+func (ob *Outbox) attempt(item *OutboxItem) {
+	ob.mu.Lock()
+	// The item may have been removed since processDue collected it
+	if current, ok := ob.items[item.Key]; !ok || current != item || item.Status != OutboxStatusQueued {
+		ob.mu.Unlock()
+		return
+	}
+	item.Status = OutboxStatusSending
+	item.Attempts++
+	item.UpdatedAtMs = time.Now().UnixMilli()
+	opts := item.Options
+	otid := item.OTID
+	ob.saveLocked()
+	ob.mu.Unlock()
+
+	result, err := ob.client.sendMessageWithOTID(ob.client.ctx, &opts, otid)
+
+	ob.mu.Lock()
+	item.UpdatedAtMs = time.Now().UnixMilli()
+	// The confirmation or failure may have arrived while the send was running
+	if item.MessageID != "" {
+		result, err = &SendMessageResult{MessageID: item.MessageID}, nil
+	} else if item.sendErr != nil && (err != nil || result.Pending) {
+		err = item.sendErr
+	}
+	item.sendErr = nil
+	if err == nil {
+		item.MessageID = result.MessageID
+		item.LastError = ""
+		if result.Pending {
+			item.Status = OutboxStatusPending
+			item.NextAttemptAtMs = item.UpdatedAtMs + pendingSendTTL.Milliseconds()
+		} else {
+			item.Status = OutboxStatusSent
+			item.NextAttemptAtMs = 0
+		}
+	} else {
+		item.LastError = err.Error()
+		var sendErr *SendError
+		if errors.As(err, &sendErr) || item.Attempts >= ob.cfg.MaxAttempts {
+			// Meta rejected the message, retrying with the same otid won't help
+			item.Status = OutboxStatusFailed
+			item.NextAttemptAtMs = 0
+		} else {
+			item.Status = OutboxStatusQueued
+			item.NextAttemptAtMs = item.UpdatedAtMs + ob.backoff(item.Attempts)
+		}
+		ob.client.Logger.Warn().Err(err).Str("key", item.Key).Int("attempts", item.Attempts).Msg("Outbox send failed")
+	}
+	snapshot := *item
+	ob.saveLocked()
+	ob.mu.Unlock()
+
+	ob.client.emitEvent(EventTypeOutboxStatus, &snapshot)
+}
+
+// resolve updates sent items from the confirmation and failure rows of a table
+func (ob *Outbox) resolve(tbl *table.LSTable) {
+	now := time.Now().UnixMilli()
+
+	ob.mu.Lock()
+	var resolved []OutboxItem
+	for _, item := range ob.items {
+		if item.Status != OutboxStatusPending && item.Status != OutboxStatusSending {
+			continue
+		}
+		otid := strconv.FormatInt(item.OTID, 10)
+		var messageID string
+		for _, r := range tbl.LSReplaceOptimsiticMessage {
+			if r.OfflineThreadingId == otid {
+				messageID = r.MessageId
+				break
+			}
+		}
+		sendErr := findSendFailure(tbl, otid)
+		if messageID == "" && sendErr == nil {
+			continue
+		}
+		if item.Status == OutboxStatusSending {
+			// attempt picks the outcome up once the send returns
+			item.MessageID = messageID
+			item.sendErr = sendErr
+			continue
+		}
+		if messageID != "" {
+			item.Status = OutboxStatusSent
+			item.MessageID = messageID
+		} else {
+			item.Status = OutboxStatusFailed
+			item.LastError = sendErr.Error()
+		}
+		item.NextAttemptAtMs = 0
+		item.UpdatedAtMs = now
+		resolved = append(resolved, *item)
+	}
+	if len(resolved) > 0 {
+		ob.saveLocked()
+	}
+	ob.mu.Unlock()
+
+	for i := range resolved {
+		ob.client.emitEvent(EventTypeOutboxStatus, &resolved[i])
+	}
+}
+
+// backoff returns the delay before the next attempt, doubling each time
+func (ob *Outbox) backoff(attempts int) int64 {
+	delay := ob.cfg.BaseDelayMs
+	for i := 1; i < attempts && delay < ob.cfg.MaxDelayMs; i++ {
+		delay *= 2
+	}
+	if delay > ob.cfg.MaxDelayMs {
+		delay = ob.cfg.MaxDelayMs
+	}
+	return delay
+}
+
+// saveLocked writes the outbox to disk, caller must hold ob.mu
+func (ob *Outbox) saveLocked() error {
+	if ob.cfg.Path == "" {
+		return nil
+	}
+	items := make([]*OutboxItem, 0, len(ob.items))
+	for _, item := range ob.items {
+		items = append(items, item)
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temp file first so a crash can't leave a truncated outbox
+	tmpPath := ob.cfg.Path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, ob.cfg.Path)
+}
+
+// EnqueueMessage adds a message to the outbox under the given idempotency key
+func (c *Client) EnqueueMessage(key string, opts *SendMessageOptions) (*OutboxItem, error) {
+	if c.Outbox == nil {
+		return nil, ErrOutboxDisabled
+	}
+	return c.Outbox.Enqueue(key, opts)
+}
+
+// GetOutboxItem returns the outbox item with the given idempotency key
+func (c *Client) GetOutboxItem(key string) (*OutboxItem, error) {
+	if c.Outbox == nil {
+		return nil, ErrOutboxDisabled
+	}
+	item, ok := c.Outbox.Get(key)
+	if !ok {
+		return nil, fmt.Errorf("outbox item not found: %s", key)
+	}
+	return item, nil
+}
+
+// ListOutbox returns all outbox items
+func (c *Client) ListOutbox() ([]*OutboxItem, error) {
+	if c.Outbox == nil {
+		return nil, ErrOutboxDisabled
+	}
+	return c.Outbox.List(), nil
+}
+
+// RemoveOutboxItem removes an outbox item that is not currently being sent
+func (c *Client) RemoveOutboxItem(key string) error {
+	if c.Outbox == nil {
+		return ErrOutboxDisabled
+	}
+	return c.Outbox.Remove(key)
+}
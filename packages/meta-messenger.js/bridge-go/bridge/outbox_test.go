@@ -0,0 +1,75 @@
+package bridge
+
+import (
+	"testing"
+
+	"go.mau.fi/mautrix-meta/pkg/messagix/table"
+)
+
+func TestOutboxResolve(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        OutboxStatus
+		tbl           *table.LSTable
+		wantStatus    OutboxStatus
+		wantMessageID string
+		wantEvent     bool
+	}{
+		{
+			name:   "pending confirmed",
+			status: OutboxStatusPending,
+			tbl: &table.LSTable{LSReplaceOptimsiticMessage: []*table.LSReplaceOptimsiticMessage{
+				{OfflineThreadingId: "42", MessageId: "mid.1"},
+			}},
+			wantStatus:    OutboxStatusSent,
+			wantMessageID: "mid.1",
+			wantEvent:     true,
+		},
+		{
+			name:   "pending failed",
+			status: OutboxStatusPending,
+			tbl: &table.LSTable{LSMarkOptimisticMessageFailed: []*table.LSMarkOptimisticMessageFailed{
+				{OTID: "42", Message: "blocked"},
+			}},
+			wantStatus: OutboxStatusFailed,
+			wantEvent:  true,
+		},
+		{
+			name:   "other otid",
+			status: OutboxStatusPending,
+			tbl: &table.LSTable{LSReplaceOptimsiticMessage: []*table.LSReplaceOptimsiticMessage{
+				{OfflineThreadingId: "43", MessageId: "mid.1"},
+			}},
+			wantStatus: OutboxStatusPending,
+		},
+		{
+			name:   "still sending",
+			status: OutboxStatusSending,
+			tbl: &table.LSTable{LSReplaceOptimsiticMessage: []*table.LSReplaceOptimsiticMessage{
+				{OfflineThreadingId: "42", MessageId: "mid.1"},
+			}},
+			wantStatus:    OutboxStatusSending,
+			wantMessageID: "mid.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{eventChan: make(chan *Event, 1)}
+			ob, err := newOutbox(c, &OutboxConfig{})
+			if err != nil {
+				t.Fatalf("newOutbox() error = %v", err)
+			}
+			ob.items["key"] = &OutboxItem{Key: "key", OTID: 42, Status: tt.status}
+
+			ob.resolve(tt.tbl)
+
+			item, _ := ob.Get("key")
+			if item.Status != tt.wantStatus || item.MessageID != tt.wantMessageID {
+				t.Errorf("item = %s %q, want %s %q", item.Status, item.MessageID, tt.wantStatus, tt.wantMessageID)
+			}
+			if gotEvent := len(c.eventChan) > 0; gotEvent != tt.wantEvent {
+				t.Errorf("event emitted = %v, want %v", gotEvent, tt.wantEvent)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	armadillo "go.mau.fi/whatsmeow/proto"
+	"go.mau.fi/whatsmeow/proto/waMsgApplication"
+	waTypes "go.mau.fi/whatsmeow/types"
+
+	"go.mau.fi/mautrix-meta/pkg/messagix/socket"
+	"go.mau.fi/mautrix-meta/pkg/messagix/table"
+)
+
+// Rate limited action types
+const (
+	RateLimitActionSend   = "send"
+	RateLimitActionReact  = "react"
+	RateLimitActionTyping = "typing"
+	RateLimitActionUpload = "upload"
+	RateLimitActionManage = "manage" // thread and contact management: mute, rename, folders, blocking...
+	RateLimitActionQuery  = "query"  // searches and lookups
+)
+
+// RateLimitRule describes a token bucket
+type RateLimitRule struct {
+	Rate  float64 `json:"rate"`  // tokens added per second
+	Burst int     `json:"burst"` // bucket size, 0 = max(1, rate)
+}
+
+// RateLimitConfig configures client-side pacing of outgoing actions
+type RateLimitConfig struct {
+	Global      *RateLimitRule            `json:"global,omitempty"`      // shared by all actions and threads
+	PerThread   *RateLimitRule            `json:"perThread,omitempty"`   // one bucket per thread, shared by all actions
+	Actions     map[string]*RateLimitRule `json:"actions,omitempty"`     // keyed by "send", "react", "typing", "upload", "manage", "query"
+	Mode        string                    `json:"mode,omitempty"`        // "queue" (default) waits for a token, "reject" fails immediately
+	MaxWaitMs   int64                     `json:"maxWaitMs,omitempty"`   // queue mode only, 0 = wait as long as needed
+	JitterMinMs int64                     `json:"jitterMinMs,omitempty"` // random delay added before each send
+	JitterMaxMs int64                     `json:"jitterMaxMs,omitempty"`
+}
+
+// RateLimitError is returned when an action is rejected by the rate limiter
+type RateLimitError struct {
+	Action       string `json:"action"`
+	ThreadKey    string `json:"threadKey,omitempty"`
+	RetryAfterMs int64  `json:"retryAfterMs"`
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited: %s (retry after %dms)", e.Action, e.RetryAfterMs)
+}
+
+// ErrorCode returns the machine-readable error code
+func (e *RateLimitError) ErrorCode() string {
+	return "rate_limited"
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rule *RateLimitRule) *tokenBucket {
+	burst := float64(rule.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, rule.Rate)
+	}
+	return &tokenBucket{
+		rate:   rule.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// refill adds tokens for the time elapsed since the last call
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait returns how long until a token is available
+func (b *tokenBucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	if b.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// RateLimiter paces outgoing actions using token buckets
+type RateLimiter struct {
+	cfg     RateLimitConfig
+	mu      sync.Mutex
+	global  *tokenBucket
+	actions map[string]*tokenBucket
+	threads map[string]*tokenBucket
+}
+
+// Validate checks that every rule can hand out tokens. A rule with a zero rate would
+// make queued actions wait forever.
+func (cfg *RateLimitConfig) Validate() error {
+	check := func(name string, rule *RateLimitRule) error {
+		if rule == nil {
+			return nil
+		}
+		if rule.Rate <= 0 || math.IsInf(rule.Rate, 0) || math.IsNaN(rule.Rate) {
+			return fmt.Errorf("rate limit %s: rate must be a positive number, got %v", name, rule.Rate)
+		}
+		if rule.Burst < 0 {
+			return fmt.Errorf("rate limit %s: burst can't be negative", name)
+		}
+		return nil
+	}
+	if err := check("global", cfg.Global); err != nil {
+		return err
+	}
+	if err := check("perThread", cfg.PerThread); err != nil {
+		return err
+	}
+	for action, rule := range cfg.Actions {
+		if err := check(action, rule); err != nil {
+			return err
+		}
+	}
+	switch cfg.Mode {
+	case "", "queue", "reject":
+	default:
+		return fmt.Errorf("unknown rate limit mode %q", cfg.Mode)
+	}
+	if cfg.MaxWaitMs < 0 || cfg.JitterMinMs < 0 || cfg.JitterMaxMs < 0 {
+		return fmt.Errorf("rate limit durations can't be negative")
+	}
+	if cfg.JitterMaxMs > 0 && cfg.JitterMinMs > cfg.JitterMaxMs {
+		return fmt.Errorf("rate limit jitterMinMs is greater than jitterMaxMs")
+	}
+	return nil
+}
+
+// NewRateLimiter creates a rate limiter from the config
+func NewRateLimiter(cfg *RateLimitConfig) (*RateLimiter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	rl := &RateLimiter{
+		cfg:     *cfg,
+		actions: make(map[string]*tokenBucket),
+		threads: make(map[string]*tokenBucket),
+	}
+	if cfg.Global != nil {
+		rl.global = newTokenBucket(cfg.Global)
+	}
+	for action, rule := range cfg.Actions {
+		if rule != nil {
+			rl.actions[action] = newTokenBucket(rule)
+		}
+	}
+	return rl, nil
+}
+
+// buckets returns the buckets that apply to an action, caller must hold rl.mu
+func (rl *RateLimiter) buckets(action, threadKey string) []*tokenBucket {
+	result := make([]*tokenBucket, 0, 3)
+	if rl.global != nil {
+		result = append(result, rl.global)
+	}
+	if b, ok := rl.actions[action]; ok {
+		result = append(result, b)
+	}
+	if rl.cfg.PerThread != nil && threadKey != "" {
+		b, ok := rl.threads[threadKey]
+		if !ok {
+			b = newTokenBucket(rl.cfg.PerThread)
+			rl.threads[threadKey] = b
+		}
+		result = append(result, b)
+	}
+	return result
+}
+
+// reserve takes a token from every applicable bucket, or returns how long to wait
+func (rl *RateLimiter) reserve(action, threadKey string) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	buckets := rl.buckets(action, threadKey)
+	var wait time.Duration
+	for _, b := range buckets {
+		b.refill(now)
+		if w := b.wait(); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	rl.pruneThreads(now)
+	return 0
+}
+
+// pruneThreads drops per-thread buckets that are full again, caller must hold rl.mu
+func (rl *RateLimiter) pruneThreads(now time.Time) {
+	if len(rl.threads) < 1000 {
+		return
+	}
+	for key, b := range rl.threads {
+		b.refill(now)
+		if b.tokens >= b.burst {
+			delete(rl.threads, key)
+		}
+	}
+}
+
+// Wait blocks until the action is allowed, or returns a RateLimitError
+func (rl *RateLimiter) Wait(ctx context.Context, action, threadKey string) error {
+	start := time.Now()
+	for {
+		wait := rl.reserve(action, threadKey)
+		if wait == 0 {
+			break
+		}
+		if rl.cfg.Mode == "reject" {
+			return &RateLimitError{Action: action, ThreadKey: threadKey, RetryAfterMs: wait.Milliseconds() + 1}
+		}
+		if rl.cfg.MaxWaitMs > 0 {
+			remaining := time.Duration(rl.cfg.MaxWaitMs)*time.Millisecond - time.Since(start)
+			if wait > remaining {
+				return &RateLimitError{Action: action, ThreadKey: threadKey, RetryAfterMs: wait.Milliseconds() + 1}
+			}
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+
+	if action == RateLimitActionSend && rl.cfg.JitterMaxMs > 0 {
+		jitter := rl.cfg.JitterMinMs
+		if spread := rl.cfg.JitterMaxMs - rl.cfg.JitterMinMs; spread > 0 {
+			jitter += rand.Int63n(spread + 1)
+		}
+		return sleepContext(ctx, time.Duration(jitter)*time.Millisecond)
+	}
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttle applies the client rate limiter, if one is configured
+func (c *Client) throttle(ctx context.Context, action, threadKey string) error {
+	if c.RateLimiter == nil {
+		return nil
+	}
+	return c.RateLimiter.Wait(ctx, action, threadKey)
+}
+
+// executeTasks runs LightSpeed tasks after applying the rate limiter.
+// threadID may be 0 when the task isn't tied to a known thread.
+func (c *Client) executeTasks(ctx context.Context, action string, threadID int64, tasks ...socket.Task) (*table.LSTable, error) {
+	var threadKey string
+	if threadID != 0 {
+		threadKey = strconv.FormatInt(threadID, 10)
+	}
+	if err := c.throttle(ctx, action, threadKey); err != nil {
+		c.metrics.sendFailed(action)
+		return nil, err
+	}
+	tbl, err := c.runTasks(ctx, tasks...)
+	if err != nil {
+		c.metrics.sendFailed(action)
+	}
+	return tbl, err
+}
+
+// runTasks runs LightSpeed tasks and records their latency
+func (c *Client) runTasks(ctx context.Context, tasks ...socket.Task) (*table.LSTable, error) {
+	start := time.Now()
+	tbl, err := c.Messagix.ExecuteTasks(ctx, tasks...)
+	c.metrics.observeTasks(tasks, time.Since(start), err)
+	return tbl, err
+}
+
+// sendFBMessage sends an E2EE message after applying the rate limiter
+func (c *Client) sendFBMessage(ctx context.Context,
+	action string,
+	chatJID waTypes.JID,
+	message armadillo.RealMessageApplicationSub,
+	metadata *waMsgApplication.MessageApplication_Metadata,
+	extra whatsmeow.SendRequestExtra,
+) (whatsmeow.SendResponse, error) {
+	if err := c.throttle(ctx, action, chatJID.User); err != nil {
+		c.metrics.sendFailed(action)
+		return whatsmeow.SendResponse{}, err
+	}
+	metadata = c.withDisappearingSetting(chatJID, message, metadata)
+	resp, err := c.E2EE.SendFBMessage(ctx, chatJID, message, metadata, extra)
+	if err != nil {
+		c.metrics.sendFailed(action)
+	}
+	return resp, err
+}
+
+// uploadE2EE uploads E2EE media after applying the rate limiter
+func (c *Client) uploadE2EE(ctx context.Context, chatJID waTypes.JID, data []byte, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	if err := c.throttle(ctx, RateLimitActionUpload, chatJID.User); err != nil {
+		c.metrics.sendFailed(RateLimitActionUpload)
+		return whatsmeow.UploadResponse{}, err
+	}
+	c.metrics.observeUpload(uploadKind(mediaType), len(data))
+	resp, err := c.E2EE.Upload(ctx, data, mediaType)
+	if err != nil {
+		c.metrics.sendFailed(RateLimitActionUpload)
+	}
+	return resp, err
+}
@@ -0,0 +1,170 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		rule     RateLimitRule
+		take     int           // tokens taken at start
+		elapsed  time.Duration // time until refill
+		wantWait time.Duration
+	}{
+		{name: "full bucket", rule: RateLimitRule{Rate: 1, Burst: 3}, take: 2, wantWait: 0},
+		{name: "empty bucket", rule: RateLimitRule{Rate: 2, Burst: 1}, take: 1, wantWait: 500 * time.Millisecond},
+		{name: "partly refilled", rule: RateLimitRule{Rate: 2, Burst: 1}, take: 1, elapsed: 250 * time.Millisecond, wantWait: 250 * time.Millisecond},
+		{name: "refilled", rule: RateLimitRule{Rate: 2, Burst: 1}, take: 1, elapsed: time.Second, wantWait: 0},
+		{name: "refill stops at burst", rule: RateLimitRule{Rate: 10, Burst: 2}, take: 2, elapsed: time.Hour, wantWait: 0},
+		{name: "default burst", rule: RateLimitRule{Rate: 0.5}, take: 1, wantWait: 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(&tt.rule)
+			b.last = start
+			b.tokens -= float64(tt.take)
+			b.refill(start.Add(tt.elapsed))
+			if got := b.wait(); got.Round(time.Millisecond) != tt.wantWait {
+				t.Errorf("wait() = %v, want %v", got, tt.wantWait)
+			}
+			if b.tokens > b.burst {
+				t.Errorf("tokens = %v, more than burst %v", b.tokens, b.burst)
+			}
+		})
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       RateLimitConfig
+		action    string
+		threadKey string
+		calls     int
+		minTime   time.Duration
+		wantErr   bool
+	}{
+		{
+			name:   "within burst",
+			cfg:    RateLimitConfig{Global: &RateLimitRule{Rate: 1, Burst: 3}},
+			action: RateLimitActionSend,
+			calls:  3,
+		},
+		{
+			name:    "queue waits for a token",
+			cfg:     RateLimitConfig{Global: &RateLimitRule{Rate: 20, Burst: 1}},
+			action:  RateLimitActionSend,
+			calls:   3,
+			minTime: 90 * time.Millisecond,
+		},
+		{
+			name:    "reject mode",
+			cfg:     RateLimitConfig{Global: &RateLimitRule{Rate: 1, Burst: 1}, Mode: "reject"},
+			action:  RateLimitActionSend,
+			calls:   2,
+			wantErr: true,
+		},
+		{
+			name:    "max wait exceeded",
+			cfg:     RateLimitConfig{Global: &RateLimitRule{Rate: 1, Burst: 1}, MaxWaitMs: 10},
+			action:  RateLimitActionSend,
+			calls:   2,
+			wantErr: true,
+		},
+		{
+			name:   "other action not limited",
+			cfg:    RateLimitConfig{Actions: map[string]*RateLimitRule{RateLimitActionReact: {Rate: 1, Burst: 1}}, Mode: "reject"},
+			action: RateLimitActionSend,
+			calls:  5,
+		},
+		{
+			name:      "per thread bucket",
+			cfg:       RateLimitConfig{PerThread: &RateLimitRule{Rate: 1, Burst: 1}, Mode: "reject"},
+			action:    RateLimitActionSend,
+			threadKey: "100",
+			calls:     2,
+			wantErr:   true,
+		},
+		{
+			name:    "jitter",
+			cfg:     RateLimitConfig{JitterMinMs: 20, JitterMaxMs: 30},
+			action:  RateLimitActionSend,
+			calls:   2,
+			minTime: 40 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, err := NewRateLimiter(&tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			var waitErr error
+			for i := 0; i < tt.calls && waitErr == nil; i++ {
+				waitErr = rl.Wait(context.Background(), tt.action, tt.threadKey)
+			}
+			if tt.wantErr {
+				var rlErr *RateLimitError
+				if !errors.As(waitErr, &rlErr) {
+					t.Fatalf("Wait() error = %v, want RateLimitError", waitErr)
+				}
+				if rlErr.RetryAfterMs <= 0 {
+					t.Errorf("RetryAfterMs = %d, want > 0", rlErr.RetryAfterMs)
+				}
+				return
+			}
+			if waitErr != nil {
+				t.Fatalf("Wait() error = %v", waitErr)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minTime {
+				t.Errorf("calls took %v, want at least %v", elapsed, tt.minTime)
+			}
+		})
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	rl, err := NewRateLimiter(&RateLimitConfig{Global: &RateLimitRule{Rate: 0.01, Burst: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rl.Wait(context.Background(), RateLimitActionSend, ""); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err = rl.Wait(ctx, RateLimitActionSend, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestRateLimitConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     RateLimitConfig
+		wantErr bool
+	}{
+		{name: "empty", cfg: RateLimitConfig{}},
+		{name: "valid", cfg: RateLimitConfig{Global: &RateLimitRule{Rate: 5, Burst: 10}, Mode: "queue", JitterMinMs: 10, JitterMaxMs: 20}},
+		{name: "zero rate", cfg: RateLimitConfig{Global: &RateLimitRule{Rate: 0, Burst: 1}}, wantErr: true},
+		{name: "negative rate", cfg: RateLimitConfig{PerThread: &RateLimitRule{Rate: -1}}, wantErr: true},
+		{name: "zero action rate", cfg: RateLimitConfig{Actions: map[string]*RateLimitRule{RateLimitActionSend: {}}}, wantErr: true},
+		{name: "negative burst", cfg: RateLimitConfig{Global: &RateLimitRule{Rate: 1, Burst: -1}}, wantErr: true},
+		{name: "unknown mode", cfg: RateLimitConfig{Mode: "drop"}, wantErr: true},
+		{name: "jitter range", cfg: RateLimitConfig{JitterMinMs: 30, JitterMaxMs: 20}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
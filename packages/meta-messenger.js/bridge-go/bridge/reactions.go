@@ -0,0 +1,266 @@
+package bridge
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+
+	"go.mau.fi/mautrix-meta/pkg/messagix/socket"
+	"go.mau.fi/mautrix-meta/pkg/messagix/table"
+)
+
+const (
+	// Sync group of the reactions V2 tasks
+	reactionsV2SyncGroup = 104
+	// Reactions V2 state kept for sending, least recently used entries are dropped past this
+	reactionsV2CacheLimit = 5000
+
+	reactionV2OperationAdd    = 1
+	reactionV2OperationRemove = 3
+	reactionV2StyleDefault    = 1
+)
+
+// ReactionDetail is a single user's reaction on a message
+type ReactionDetail struct {
+	ReactorID         int64  `json:"reactorId"`
+	Name              string `json:"name"`
+	ProfilePictureURL string `json:"profilePictureUrl,omitempty"`
+	Reaction          string `json:"reaction,omitempty"` // empty if the emoji of the reaction FBID isn't known
+	ReactionFBID      int64  `json:"reactionFbid"`
+	TimestampMs       int64  `json:"timestampMs"`
+}
+
+// FetchReactionsOptions for listing who reacted to a message
+type FetchReactionsOptions struct {
+	ThreadID     int64  `json:"threadId"`
+	MessageID    string `json:"messageId"`
+	ReactionFBID int64  `json:"reactionFbid,omitempty"` // only list this reaction
+}
+
+// SendReactionV2Options for adding or removing one of several reactions on a message
+type SendReactionV2Options struct {
+	ThreadID           int64  `json:"threadId"`
+	MessageID          string `json:"messageId"`
+	MessageTimestampMs int64  `json:"messageTimestampMs"`
+	Reaction           string `json:"reaction"`
+	// FBID of the reaction, looked up from the reactions seen on the message when not set
+	ReactionFBID int64 `json:"reactionFbid,omitempty"`
+	// reaction_style sent to Meta, 1 (default) for a normal tap.
+	// Other styles such as supertaps use the value sent by the web client.
+	Style  int  `json:"style,omitempty"`
+	Remove bool `json:"remove,omitempty"`
+}
+
+func reactionV2Key(messageID string, reactionFBID int64) string {
+	return fmt.Sprintf("%s:%d", messageID, reactionFBID)
+}
+
+// reactionV2Cache is a size-limited LRU cache of the last known V2 reaction rows
+type reactionV2Cache struct {
+	mu    sync.Mutex
+	limit int
+	items map[string]*list.Element // key: messageId:reactionFbid
+	order *list.List               // front = most recently used
+}
+
+func newReactionV2Cache(limit int) *reactionV2Cache {
+	return &reactionV2Cache{
+		limit: limit,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (rc *reactionV2Cache) put(r *table.LSUpdateOrInsertReactionV2) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	key := reactionV2Key(r.MessageID, r.ReactionFBID)
+	if elem, ok := rc.items[key]; ok {
+		elem.Value = r
+		rc.order.MoveToFront(elem)
+		return
+	}
+	rc.items[key] = rc.order.PushFront(r)
+	for rc.order.Len() > rc.limit {
+		oldest := rc.order.Back()
+		old := oldest.Value.(*table.LSUpdateOrInsertReactionV2)
+		rc.order.Remove(oldest)
+		delete(rc.items, reactionV2Key(old.MessageID, old.ReactionFBID))
+	}
+}
+
+// remove drops a reaction and returns its last known state
+func (rc *reactionV2Cache) remove(messageID string, reactionFBID int64) *table.LSUpdateOrInsertReactionV2 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	key := reactionV2Key(messageID, reactionFBID)
+	elem, ok := rc.items[key]
+	if !ok {
+		return nil
+	}
+	rc.order.Remove(elem)
+	delete(rc.items, key)
+	return elem.Value.(*table.LSUpdateOrInsertReactionV2)
+}
+
+// find looks up a reaction on a message by FBID, or by emoji when the FBID is 0
+func (rc *reactionV2Cache) find(messageID string, reactionFBID int64, literal string) *table.LSUpdateOrInsertReactionV2 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if reactionFBID != 0 {
+		elem, ok := rc.items[reactionV2Key(messageID, reactionFBID)]
+		if !ok {
+			return nil
+		}
+		rc.order.MoveToFront(elem)
+		return elem.Value.(*table.LSUpdateOrInsertReactionV2)
+	}
+	if literal == "" {
+		return nil
+	}
+	for elem := rc.order.Front(); elem != nil; elem = elem.Next() {
+		r := elem.Value.(*table.LSUpdateOrInsertReactionV2)
+		if r.MessageID == messageID && r.ReactionLiteral == literal {
+			rc.order.MoveToFront(elem)
+			return r
+		}
+	}
+	return nil
+}
+
+// handleReactionsV2 emits reaction events for V2 reaction rows.
+// V2 rows carry aggregated counts, so the actor is only known for the viewer's own reactions.
+// Removals are only emitted when the viewer was the reactor, others can't be attributed to anyone.
+func (c *Client) handleReactionsV2(tbl *table.LSTable) {
+	for _, r := range tbl.LSUpdateOrInsertReactionV2 {
+		c.reactionsV2.put(r)
+		var actorID int64
+		if r.ViewerIsReactor {
+			actorID = c.FBID
+		}
+		c.emitEvent(EventTypeReaction, &ReactionEvent{
+			MessageID:    r.MessageID,
+			ThreadID:     r.ThreadKey,
+			ActorID:      actorID,
+			Reaction:     r.ReactionLiteral,
+			TimestampMs:  r.LastUpdatedTimestampMS,
+			ReactionFBID: r.ReactionFBID,
+			Count:        r.Count,
+		})
+	}
+	for _, r := range tbl.LSDeleteReactionV2 {
+		prev := c.reactionsV2.remove(r.MessageID, r.ReactionFBID)
+		if prev == nil || !prev.ViewerIsReactor {
+			continue
+		}
+		c.emitEvent(EventTypeReaction, &ReactionEvent{
+			MessageID:    r.MessageID,
+			ThreadID:     r.ThreadKey,
+			ActorID:      c.FBID,
+			Reaction:     "", // Empty means reaction removed
+			TimestampMs:  r.LastUpdatedTimestampMS,
+			ReactionFBID: r.ReactionFBID,
+		})
+	}
+}
+
+// DeleteMessageForMe removes a message only for the current user
+func (c *Client) DeleteMessageForMe(ctx context.Context, threadID int64, messageID string) error {
+	task := &socket.DeleteMessageMeOnlyTask{
+		ThreadKey: threadID,
+		MessageId: messageID,
+	}
+	_, err := c.executeTasks(ctx, RateLimitActionSend, threadID, task)
+	return err
+}
+
+// FetchReactions lists who reacted to a message with which reaction
+func (c *Client) FetchReactions(ctx context.Context, opts *FetchReactionsOptions) ([]*ReactionDetail, error) {
+	task := &socket.FetchReactionsV2UserList{
+		ThreadID:  opts.ThreadID,
+		MessageID: opts.MessageID,
+		SyncGroup: reactionsV2SyncGroup,
+	}
+	if opts.ReactionFBID != 0 {
+		task.ReactionFBID = &opts.ReactionFBID
+	}
+	tbl, err := c.executeTasks(ctx, RateLimitActionQuery, opts.ThreadID, task)
+	if err != nil {
+		return nil, err
+	}
+
+	details := make([]*ReactionDetail, 0)
+	if tbl == nil {
+		return details, nil
+	}
+	for _, r := range tbl.LSUpdateOrInsertReactionV2 {
+		c.reactionsV2.put(r)
+	}
+	for _, r := range tbl.LSDeleteThenInsertReactionsV2Detail {
+		if r.MessageID != opts.MessageID {
+			continue
+		}
+		detail := &ReactionDetail{
+			ReactorID:         r.ReactorID,
+			Name:              r.FullName,
+			ProfilePictureURL: r.ProfilePictureURL,
+			ReactionFBID:      r.ReactionFBID,
+			TimestampMs:       r.TimestampMS,
+		}
+		if known := c.reactionsV2.find(r.MessageID, r.ReactionFBID, ""); known != nil {
+			detail.Reaction = known.ReactionLiteral
+		}
+		details = append(details, detail)
+	}
+	return details, nil
+}
+
+// SendReactionV2 adds or removes a reaction without replacing the user's other reactions.
+// When the reaction FBID isn't given and the emoji hasn't been seen on the message yet,
+// it falls back to a regular reaction, which replaces the user's reaction (or clears it on remove).
+func (c *Client) SendReactionV2(ctx context.Context, opts *SendReactionV2Options) error {
+	known := c.reactionsV2.find(opts.MessageID, opts.ReactionFBID, opts.Reaction)
+	reactionFBID := opts.ReactionFBID
+	literal := opts.Reaction
+	var count int
+	if known != nil {
+		reactionFBID = known.ReactionFBID
+		count = int(known.Count)
+		if literal == "" {
+			literal = known.ReactionLiteral
+		}
+	}
+	if reactionFBID == 0 {
+		if opts.Remove {
+			return c.SendReaction(ctx, opts.ThreadID, opts.MessageID, "")
+		}
+		if literal == "" {
+			return fmt.Errorf("reaction or reaction FBID is required")
+		}
+		return c.SendReaction(ctx, opts.ThreadID, opts.MessageID, literal)
+	}
+	style := opts.Style
+	if style == 0 {
+		style = reactionV2StyleDefault
+	}
+	task := &socket.SendReactionV2Task{
+		ThreadID:         opts.ThreadID,
+		MessageID:        opts.MessageID,
+		MessageTimestamp: opts.MessageTimestampMs,
+		ActorID:          c.FBID,
+		ReactionFBID:     reactionFBID,
+		ReactionStyle:    style,
+		CurrentCount:     count,
+		ViewerIsReactor:  1,
+		Operation:        reactionV2OperationAdd,
+		ReactionLiteral:  literal,
+		SyncGroup:        reactionsV2SyncGroup,
+	}
+	if opts.Remove {
+		task.ViewerIsReactor = 0
+		task.Operation = reactionV2OperationRemove
+	}
+	_, err := c.executeTasks(ctx, RateLimitActionReact, opts.ThreadID, task)
+	return err
+}
@@ -0,0 +1,166 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"go.mau.fi/mautrix-meta/pkg/messagix/socket"
+	"go.mau.fi/mautrix-meta/pkg/messagix/table"
+)
+
+// Search scopes, also used as the type of each search result
+const (
+	SearchScopeUsers       = "users"
+	SearchScopeGroups      = "groups"
+	SearchScopePages       = "pages"
+	SearchScopeCommunities = "communities"
+	SearchScopeMessages    = "messages"
+)
+
+// SearchOptions for searching contacts, threads and message content
+type SearchOptions struct {
+	Query    string   `json:"query"`
+	Scopes   []string `json:"scopes,omitempty"`   // defaults to users, groups and pages
+	ThreadID int64    `json:"threadId,omitempty"` // only returns message matches in this thread, implies the messages scope
+	Limit    int      `json:"limit,omitempty"`
+}
+
+// SearchResult is a single search match
+type SearchResult struct {
+	Type               string `json:"type"` // one of the search scopes
+	ID                 string `json:"id"`
+	ThreadID           int64  `json:"threadId,omitempty"`
+	UserID             int64  `json:"userId,omitempty"`
+	ThreadType         int    `json:"threadType,omitempty"`
+	Name               string `json:"name"`
+	ProfilePictureURL  string `json:"profilePictureUrl,omitempty"`
+	ContextLine        string `json:"contextLine,omitempty"`
+	IsVerified         bool   `json:"isVerified,omitempty"`
+	CanViewerMessage   bool   `json:"canViewerMessage,omitempty"`
+	CommunityID        int64  `json:"communityId,omitempty"`
+	IGID               string `json:"igId,omitempty"`
+	MessageID          string `json:"messageId,omitempty"`          // set for message matches
+	MessageTimestampMs int64  `json:"messageTimestampMs,omitempty"` // set for message matches
+	Snippet            string `json:"snippet,omitempty"`            // matching message text
+}
+
+// searchScopeTypes returns the search types Meta uses for a scope on this platform
+func (c *Client) searchScopeTypes(scope string) ([]table.SearchType, error) {
+	switch scope {
+	case SearchScopeUsers:
+		if c.Platform.IsInstagram() {
+			return []table.SearchType{
+				table.SearchTypeContact, table.SearchTypeNonContact,
+				table.SearchTypeIGContactFollowing, table.SearchTypeIGContactNonFollowing,
+				table.SearchTypeIGNonContactFollowing, table.SearchTypeIGNonContactNonFollowing,
+			}, nil
+		}
+		return []table.SearchType{table.SearchTypeContact, table.SearchTypeNonContact}, nil
+	case SearchScopeGroups:
+		return []table.SearchType{table.SearchTypeGroup}, nil
+	case SearchScopePages:
+		if c.Platform.IsInstagram() {
+			return []table.SearchType{table.SearchTypePage, table.SearchTypeIGBusiness}, nil
+		}
+		return []table.SearchType{table.SearchTypePage}, nil
+	case SearchScopeCommunities:
+		return []table.SearchType{table.SearchTypeCommunityMessagingThread}, nil
+	case SearchScopeMessages:
+		return []table.SearchType{table.SearchTypeIntegratedMessageSearchThread}, nil
+	default:
+		return nil, fmt.Errorf("unknown search scope: %s", scope)
+	}
+}
+
+// searchResultScope maps the type of a result back to its scope
+func searchResultScope(typ table.SearchType) string {
+	switch typ {
+	case table.SearchTypeGroup:
+		return SearchScopeGroups
+	case table.SearchTypePage, table.SearchTypeIGBusiness:
+		return SearchScopePages
+	case table.SearchTypeCommunityMessagingThread:
+		return SearchScopeCommunities
+	case table.SearchTypeIntegratedMessageSearchThread:
+		return SearchScopeMessages
+	default:
+		return SearchScopeUsers
+	}
+}
+
+// Search searches contacts, groups, pages, community chats and message content.
+// Message matches point to the thread they were found in, with the matching
+// message ID and a snippet. Meta only returns the best matches of each thread,
+// so searching within a thread filters the global message search.
+func (c *Client) Search(ctx context.Context, opts *SearchOptions) ([]*SearchResult, error) {
+	if opts.Query == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+	scopes := opts.Scopes
+	if opts.ThreadID != 0 {
+		scopes = []string{SearchScopeMessages}
+	} else if len(scopes) == 0 {
+		scopes = []string{SearchScopeUsers, SearchScopeGroups, SearchScopePages}
+	}
+	task := &socket.SearchUserTask{
+		Query:       opts.Query,
+		SurfaceType: 15,
+	}
+	if c.Platform.IsMessenger() {
+		task.SurfaceType = 5
+	}
+	for _, scope := range scopes {
+		types, err := c.searchScopeTypes(scope)
+		if err != nil {
+			return nil, err
+		}
+		task.SupportedTypes = append(task.SupportedTypes, types...)
+	}
+
+	tbl, err := c.executeTasks(ctx, RateLimitActionQuery, 0, task)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*SearchResult, 0)
+	if tbl == nil {
+		return results, nil
+	}
+	for _, r := range tbl.LSInsertSearchResult {
+		if r.Type_ == table.SearchTypeSectionHeader {
+			continue
+		}
+		threadID, _ := strconv.ParseInt(r.ResultId, 10, 64)
+		result := &SearchResult{
+			Type:              searchResultScope(r.Type_),
+			ID:                r.ResultId,
+			ThreadID:          threadID,
+			UserID:            r.GetFBID(),
+			ThreadType:        int(r.ThreadType),
+			Name:              r.DisplayName,
+			ProfilePictureURL: r.ProfilePicUrl,
+			ContextLine:       r.ContextLine,
+			IsVerified:        r.IsVerified,
+			CanViewerMessage:  r.CanViewerMessage,
+			CommunityID:       r.CommunityId,
+			IGID:              r.ResultIgid,
+		}
+		if result.UserID == 0 && r.OtherUserId != 0 {
+			result.UserID = r.OtherUserId
+		}
+		if result.Type == SearchScopeMessages {
+			if opts.ThreadID != 0 && threadID != opts.ThreadID {
+				continue
+			}
+			result.MessageID = r.MessageId
+			result.MessageTimestampMs = r.MessageTimestampMs
+			result.Snippet = r.ContextLine
+		}
+		results = append(results, result)
+		if opts.Limit > 0 && len(results) >= opts.Limit {
+			break
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,170 @@
+package bridge
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
+	"go.mau.fi/whatsmeow/proto/waAdv"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// SQLDeviceStore keeps the E2EE device in a SQLite database using whatsmeow's sqlstore,
+// so every store interface (app state, contacts, LID mappings, message secrets,
+// buffered events, ...) is backed by real storage instead of the JSON stubs.
+type SQLDeviceStore struct {
+	Container *sqlstore.Container
+	Device    *store.Device
+	db        *sql.DB
+	log       zerolog.Logger
+}
+
+// NewSQLDeviceStore opens (and creates or upgrades) the SQLite database at path
+func NewSQLDeviceStore(ctx context.Context, path string, log zerolog.Logger) (*SQLDeviceStore, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open device database: %w", err)
+	}
+	// SQLite only supports one writer at a time
+	db.SetMaxOpenConns(1)
+
+	container := sqlstore.NewWithDB(db, "sqlite3", waLog.Zerolog(log.With().Str("component", "whatsmeow_sqlstore").Logger()))
+	if err := container.Upgrade(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to upgrade device database: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, createContactsTableQuery); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create contacts table: %w", err)
+	}
+
+	return &SQLDeviceStore{
+		Container: container,
+		db:        db,
+		log:       log,
+	}, nil
+}
+
+// LoadDevice finds the device for the given FBID. If there is none, the legacy
+// JSON device (file path or data string) is migrated when available, otherwise
+// a new unregistered device is created. Encrypted JSON can't be migrated, see
+// ErrDeviceEncryptionWithDB.
+func (s *SQLDeviceStore) LoadDevice(ctx context.Context, fbid int64, legacyPath, legacyData string) (*store.Device, error) {
+	devices, err := s.Container.GetAllDevices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load devices: %w", err)
+	}
+	user := fmt.Sprintf("%d", fbid)
+	for _, dev := range devices {
+		if dev.ID != nil && dev.ID.User == user {
+			s.Device = dev
+			return dev, nil
+		}
+	}
+
+	if legacyData == "" && legacyPath != "" {
+		if data, err := os.ReadFile(legacyPath); err == nil {
+			legacyData = string(data)
+		}
+	}
+	if legacyData != "" {
+		dev, err := s.migrateFromJSON(ctx, legacyData, user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate device JSON: %w", err)
+		}
+		if dev != nil {
+			s.Device = dev
+			return dev, nil
+		}
+	}
+
+	s.Device = s.Container.NewDevice()
+	return s.Device, nil
+}
+
+// migrateFromJSON copies a DeviceJSON device with all of its Signal state into the database.
+// Returns nil if the JSON device was never registered or belongs to another account.
+func (s *SQLDeviceStore) migrateFromJSON(ctx context.Context, data string, user string) (*store.Device, error) {
+	legacy, err := NewDeviceStoreFromData(data, nil)
+	if err != nil {
+		return nil, err
+	}
+	if legacy.Device.ID == nil || legacy.Device.ID.User != user {
+		return nil, nil
+	}
+
+	dev := s.Container.NewDevice()
+	dev.NoiseKey = legacy.Device.NoiseKey
+	dev.IdentityKey = legacy.Device.IdentityKey
+	dev.SignedPreKey = legacy.Device.SignedPreKey
+	dev.RegistrationID = legacy.Device.RegistrationID
+	dev.AdvSecretKey = legacy.Device.AdvSecretKey
+	dev.FacebookUUID = legacy.Device.FacebookUUID
+	jid := *legacy.Device.ID
+	dev.ID = &jid
+	// This is a hack since currently whatsmeow requires it to be set
+	dev.Account = &waAdv.ADVSignedDeviceIdentity{
+		Details: make([]byte, 0), AccountSignatureKey: make([]byte, 32),
+		AccountSignature: make([]byte, 64), DeviceSignature: make([]byte, 64),
+	}
+
+	// PutDevice also initializes the per-device stores
+	if err := s.Container.PutDevice(ctx, dev); err != nil {
+		return nil, err
+	}
+
+	legacy.mu.RLock()
+	defer legacy.mu.RUnlock()
+	for address, key := range legacy.identities {
+		if err := dev.Identities.PutIdentity(ctx, address, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := dev.Sessions.PutManySessions(ctx, legacy.sessions); err != nil {
+		return nil, err
+	}
+	for name, session := range legacy.senderKeys {
+		group, senderUser, ok := strings.Cut(name, ":")
+		if !ok {
+			continue
+		}
+		if err := dev.SenderKeys.PutSenderKey(ctx, group, senderUser, session); err != nil {
+			return nil, err
+		}
+	}
+	// The sqlstore API can only generate new pre-keys, so existing ones are inserted directly
+	for id, pk := range legacy.preKeys {
+		_, err := s.db.ExecContext(ctx,
+			`INSERT INTO whatsmeow_pre_keys (jid, key_id, key, uploaded) VALUES ($1, $2, $3, true) ON CONFLICT DO NOTHING`,
+			jid.String(), id, pk.Priv[:],
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.log.Info().
+		Stringer("jid", dev.ID).
+		Int("sessions", len(legacy.sessions)).
+		Int("pre_keys", len(legacy.preKeys)).
+		Msg("Migrated E2EE device from JSON to SQLite")
+	return dev, nil
+}
+
+// Save persists the device row (keys and registration info)
+func (s *SQLDeviceStore) Save(ctx context.Context) error {
+	if s.Device == nil || s.Device.ID == nil {
+		return nil
+	}
+	return s.Device.Save(ctx)
+}
+
+// Close closes the database
+func (s *SQLDeviceStore) Close() error {
+	return s.Container.Close()
+}
@@ -257,16 +257,57 @@ func (ds *DeviceStore) GetChatSettings(ctx context.Context, chat waTypes.JID) (w
 	return waTypes.LocalChatSettings{}, nil
 }
 
+// Message secrets are kept in memory only, they are short-lived and not needed
+// to restore the session. The oldest are dropped once there are too many.
+const (
+	messageSecretLimit  = 10000
+	messageSecretMaxAge = 14 * 24 * time.Hour
+)
+
+func messageSecretKey(chat, sender waTypes.JID, id waTypes.MessageID) string {
+	return chat.ToNonAD().String() + "|" + sender.ToNonAD().String() + "|" + id
+}
+
 func (ds *DeviceStore) PutMessageSecrets(ctx context.Context, inserts []store.MessageSecretInsert) error {
+	for _, insert := range inserts {
+		ds.PutMessageSecret(ctx, insert.Chat, insert.Sender, insert.ID, insert.Secret)
+	}
 	return nil
 }
 
 func (ds *DeviceStore) PutMessageSecret(ctx context.Context, chat, sender waTypes.JID, id waTypes.MessageID, secret []byte) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.messageSecrets == nil {
+		ds.messageSecrets = make(map[string]messageSecret)
+	}
+	key := messageSecretKey(chat, sender, id)
+	if _, exists := ds.messageSecrets[key]; exists {
+		return nil
+	}
+	now := time.Now()
+	ds.messageSecrets[key] = messageSecret{secret: secret, sender: sender.ToNonAD(), insertedAt: now}
+	ds.messageSecretOrder = append(ds.messageSecretOrder, key)
+	// Secrets are never replaced, so insertion order is also age order
+	for len(ds.messageSecretOrder) > 0 {
+		oldest := ds.messageSecretOrder[0]
+		if len(ds.messageSecrets) <= messageSecretLimit && now.Sub(ds.messageSecrets[oldest].insertedAt) <= messageSecretMaxAge {
+			break
+		}
+		delete(ds.messageSecrets, oldest)
+		ds.messageSecretOrder = ds.messageSecretOrder[1:]
+	}
 	return nil
 }
 
 func (ds *DeviceStore) GetMessageSecret(ctx context.Context, chat, sender waTypes.JID, id waTypes.MessageID) ([]byte, waTypes.JID, error) {
-	return nil, waTypes.JID{}, nil
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	secret, ok := ds.messageSecrets[messageSecretKey(chat, sender, id)]
+	if !ok {
+		return nil, waTypes.JID{}, nil
+	}
+	return secret.secret, secret.sender, nil
 }
 
 func (ds *DeviceStore) PutPrivacyTokens(ctx context.Context, tokens ...store.PrivacyToken) error {
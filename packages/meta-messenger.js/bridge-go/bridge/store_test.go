@@ -0,0 +1,53 @@
+package bridge
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+)
+
+func TestMessageSecretLimits(t *testing.T) {
+	ctx := context.Background()
+	chat := waTypes.NewJID("1", waTypes.MessengerServer)
+	sender := waTypes.NewJID("2", waTypes.MessengerServer)
+	has := func(ds *DeviceStore, id string) bool {
+		secret, _, _ := ds.GetMessageSecret(ctx, chat, sender, id)
+		return secret != nil
+	}
+
+	t.Run("count", func(t *testing.T) {
+		ds := &DeviceStore{}
+		for i := range messageSecretLimit + 1 {
+			ds.PutMessageSecret(ctx, chat, sender, strconv.Itoa(i), []byte{1})
+		}
+		if has(ds, "0") {
+			t.Error("oldest secret kept after the limit was reached")
+		}
+		if !has(ds, "1") || !has(ds, strconv.Itoa(messageSecretLimit)) {
+			t.Error("newer secrets were dropped")
+		}
+		if len(ds.messageSecrets) != len(ds.messageSecretOrder) {
+			t.Errorf("%d secrets but %d in order", len(ds.messageSecrets), len(ds.messageSecretOrder))
+		}
+	})
+
+	t.Run("age", func(t *testing.T) {
+		ds := &DeviceStore{}
+		ds.PutMessageSecret(ctx, chat, sender, "old", []byte{1})
+		key := messageSecretKey(chat, sender, "old")
+		old := ds.messageSecrets[key]
+		old.insertedAt = time.Now().Add(-messageSecretMaxAge - time.Minute)
+		ds.messageSecrets[key] = old
+
+		ds.PutMessageSecret(ctx, chat, sender, "new", []byte{2})
+		if has(ds, "old") {
+			t.Error("expired secret kept")
+		}
+		if !has(ds, "new") {
+			t.Error("new secret dropped")
+		}
+	})
+}
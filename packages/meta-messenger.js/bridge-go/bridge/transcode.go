@@ -0,0 +1,105 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"go.mau.fi/util/ffmpeg"
+)
+
+const (
+	// Container accepted for E2EE voice messages
+	e2eeVoiceMimeType = "audio/ogg; codecs=opus"
+	// Container accepted for Mercury voice clips
+	mercuryVoiceMimeType = "audio/mp4"
+)
+
+// voiceFormat describes the target of a voice transcode
+type voiceFormat struct {
+	ext       string
+	mimeType  string
+	args      []string
+	container string // ffprobe format name
+	codec     string // ffprobe codec name
+	// accepts is only used when ffprobe isn't installed and the codec can't be checked
+	accepts func(mimeType string) bool
+}
+
+var (
+	e2eeVoiceFormat = &voiceFormat{
+		ext:       ".ogg",
+		mimeType:  e2eeVoiceMimeType,
+		args:      []string{"-vn", "-c:a", "libopus", "-b:a", "32k", "-ac", "1", "-application", "voip"},
+		container: "ogg",
+		codec:     "opus",
+		accepts: func(mimeType string) bool {
+			return strings.HasPrefix(mimeType, "audio/ogg") && strings.Contains(mimeType, "opus")
+		},
+	}
+	mercuryVoiceFormat = &voiceFormat{
+		ext:       ".m4a",
+		mimeType:  mercuryVoiceMimeType,
+		args:      []string{"-vn", "-c:a", "aac", "-b:a", "64k", "-ac", "1"},
+		container: "mov,mp4,m4a,3gp,3g2,mj2",
+		codec:     "aac",
+		accepts: func(mimeType string) bool {
+			return mimeType == "audio/mp4" || mimeType == "audio/x-m4a"
+		},
+	}
+)
+
+// matches checks the container and codec of the data. Only a single audio stream
+// in the expected codec is accepted, so e.g. Vorbis in ogg or raw AAC get transcoded.
+func (f *voiceFormat) matches(result *ffmpeg.ProbeResult) bool {
+	if result.Format == nil || result.Format.FormatName != f.container || len(result.Streams) != 1 {
+		return false
+	}
+	stream := result.Streams[0]
+	return stream.CodecType == "audio" && stream.CodecName == f.codec
+}
+
+// isVoiceFormat checks whether data can be sent as is. The codec is checked with
+// ffprobe when it's installed, otherwise only the MIME type is.
+func (c *Client) isVoiceFormat(ctx context.Context, data []byte, mimeType string, format *voiceFormat) bool {
+	if !ffmpeg.ProbeSupported() {
+		return format.accepts(mimeType)
+	}
+	result, err := ffprobeBytes(ctx, data, mimeType)
+	if err != nil {
+		c.Logger.Debug().Err(err).Msg("ffprobe failed, transcoding voice message")
+		return false
+	}
+	return format.matches(result)
+}
+
+// transcodeVoice converts audio to the voice message container and codec Meta expects.
+// Data that is already in the right format, or can't be converted because
+// ffmpeg isn't installed, is returned unchanged.
+func (c *Client) transcodeVoice(ctx context.Context, data []byte, mimeType string, format *voiceFormat) ([]byte, string, error) {
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	if c.isVoiceFormat(ctx, data, mimeType, format) {
+		return data, format.mimeType, nil
+	}
+	if !ffmpeg.Supported() {
+		c.Logger.Warn().Str("mime_type", mimeType).Msg("ffmpeg is not installed, sending voice message without transcoding")
+		return data, mimeType, nil
+	}
+	converted, err := ffmpeg.ConvertBytes(ctx, data, format.ext, nil, format.args, mimeType)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to transcode voice message to %s: %w", format.mimeType, err)
+	}
+	return converted, format.mimeType, nil
+}
+
+// voiceFilename replaces the extension of a filename to match the transcoded format
+func voiceFilename(filename string, format *voiceFormat) string {
+	if filename == "" {
+		return "voice" + format.ext
+	}
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + format.ext
+}
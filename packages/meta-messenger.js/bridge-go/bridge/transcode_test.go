@@ -0,0 +1,38 @@
+package bridge
+
+import (
+	"testing"
+
+	"go.mau.fi/util/ffmpeg"
+)
+
+func TestVoiceFormatMatches(t *testing.T) {
+	probe := func(container string, streams ...*ffmpeg.Stream) *ffmpeg.ProbeResult {
+		return &ffmpeg.ProbeResult{Format: &ffmpeg.Format{FormatName: container}, Streams: streams}
+	}
+	audio := func(codec string) *ffmpeg.Stream {
+		return &ffmpeg.Stream{CodecType: "audio", CodecName: codec}
+	}
+	tests := []struct {
+		name   string
+		format *voiceFormat
+		result *ffmpeg.ProbeResult
+		want   bool
+	}{
+		{"opus in ogg", e2eeVoiceFormat, probe("ogg", audio("opus")), true},
+		{"vorbis in ogg", e2eeVoiceFormat, probe("ogg", audio("vorbis")), false},
+		{"opus in mp4", e2eeVoiceFormat, probe("mov,mp4,m4a,3gp,3g2,mj2", audio("opus")), false},
+		{"aac in mp4", mercuryVoiceFormat, probe("mov,mp4,m4a,3gp,3g2,mj2", audio("aac")), true},
+		{"raw aac", mercuryVoiceFormat, probe("aac", audio("aac")), false},
+		{"mp3 in mp4", mercuryVoiceFormat, probe("mov,mp4,m4a,3gp,3g2,mj2", audio("mp3")), false},
+		{"with cover art", mercuryVoiceFormat, probe("mov,mp4,m4a,3gp,3g2,mj2", audio("aac"), &ffmpeg.Stream{CodecType: "video"}), false},
+		{"no format", e2eeVoiceFormat, &ffmpeg.ProbeResult{Streams: []*ffmpeg.Stream{audio("opus")}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.format.matches(tt.result); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
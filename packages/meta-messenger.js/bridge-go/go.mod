@@ -4,10 +4,13 @@ go 1.24.0
 
 require (
 	github.com/google/uuid v1.6.0
+	github.com/mattn/go-sqlite3 v1.14.33
 	github.com/rs/zerolog v1.34.0
+	go.mau.fi/libsignal v0.2.1
 	go.mau.fi/mautrix-meta v0.0.0
 	go.mau.fi/util v0.9.5
 	go.mau.fi/whatsmeow v0.0.0-20260116142645-06f473759141
+	golang.org/x/crypto v0.47.0
 	google.golang.org/protobuf v1.36.11
 )
 
@@ -30,9 +33,7 @@ require (
 	github.com/tidwall/sjson v1.2.5 // indirect
 	github.com/vektah/gqlparser/v2 v2.5.27 // indirect
 	github.com/yuin/goldmark v1.7.16 // indirect
-	go.mau.fi/libsignal v0.2.1 // indirect
 	go.mau.fi/zeroconfig v0.2.0 // indirect
-	golang.org/x/crypto v0.47.0 // indirect
 	golang.org/x/exp v0.0.0-20260112195511-716be5621a96 // indirect
 	golang.org/x/net v0.49.0 // indirect
 	golang.org/x/sys v0.40.0 // indirect
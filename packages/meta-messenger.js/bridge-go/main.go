@@ -2,32 +2,36 @@ package main
 
 /*
 #include <stdlib.h>
+
+typedef void (*MxEventCallback)(char *event);
+
+static inline void mxInvokeEventCallback(MxEventCallback cb, char *event) {
+	cb(event);
+}
 */
 import "C"
 import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"sync"
-	"sync/atomic"
+	"time"
 	"unsafe"
 
+	"github.com/google/uuid"
+
 	"messagix-bridge/bridge"
 )
 
-type handle uint64
-
-var nextHandle atomic.Uint64
-var clients = make(map[handle]*bridge.Client)
-var clientsMu sync.RWMutex
-
-func newHandle() handle { return handle(nextHandle.Add(1)) }
+var manager = bridge.NewClientManager(nil)
 
 type jsonResp struct {
-	OK    bool        `json:"ok"`
-	Data  interface{} `json:"data,omitempty"`
-	Error string      `json:"error,omitempty"`
+	OK      bool        `json:"ok"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Code    string      `json:"code,omitempty"`
+	Details interface{} `json:"details,omitempty"`
 }
 
 func success(data interface{}) *C.char {
@@ -38,10 +42,46 @@ func success(data interface{}) *C.char {
 
 func fail(err error) *C.char {
 	resp := jsonResp{OK: false, Error: err.Error()}
+	resp.Code, resp.Details = errorCode(err)
 	b, _ := json.Marshal(resp)
 	return C.CString(string(b))
 }
 
+// errorCode classifies an error for the code and details of a failed response
+func errorCode(err error) (string, interface{}) {
+	var coded bridge.CodedError
+	if errors.As(err, &coded) {
+		return coded.ErrorCode(), coded
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout", nil
+	} else if errors.Is(err, context.Canceled) {
+		return "cancelled", nil
+	}
+	return "", nil
+}
+
+// runExport runs the Go implementation of an export and converts its result into the JSON response.
+// The same implementations are run by MxCallAsync.
+func runExport(fn func(json.RawMessage) (any, error), input *C.char) *C.char {
+	data, err := fn(json.RawMessage(C.GoString(input)))
+	if err != nil {
+		return fail(err)
+	}
+	return success(data)
+}
+
+// callOptions are accepted next to the handle by every export that talks to Meta.
+// Payloads embed it so the options are parsed along with the rest of the input.
+type callOptions struct {
+	RequestID string `json:"requestId,omitempty"` // lets MxCancel cancel the call
+	TimeoutMs int64  `json:"timeoutMs,omitempty"`
+}
+
+// beginCall derives the context of one call from the client context
+func beginCall(client *bridge.Client, opts callOptions) (context.Context, context.CancelFunc, error) {
+	return manager.BeginCall(client, opts.RequestID, time.Duration(opts.TimeoutMs)*time.Millisecond)
+}
+
 //export MxFreeCString
 func MxFreeCString(s *C.char) {
 	C.free(unsafe.Pointer(s))
@@ -54,71 +94,82 @@ func MxNewClient(input *C.char) *C.char {
 		return fail(fmt.Errorf("invalid json: %w", err))
 	}
 
-	client, err := bridge.NewClient(&cfg)
+	client, err := manager.NewClient(&cfg)
 	if err != nil {
 		return fail(err)
 	}
 
-	h := newHandle()
-	client.ID = uint64(h)
-
-	clientsMu.Lock()
-	clients[h] = client
-	clientsMu.Unlock()
-
 	return success(map[string]interface{}{
-		"handle": h,
+		"handle": client.ID,
 	})
 }
 
 //export MxConnect
 func MxConnect(input *C.char) *C.char {
+	return runExport(connect, input)
+}
+
+func connect(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle uint64 `json:"handle"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	userInfo, initialData, err := client.Connect()
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(map[string]interface{}{
+	userInfo, initialData, err := client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
 		"user":        userInfo,
 		"initialData": initialData,
-	})
+	}, nil
 }
 
 //export MxConnectE2EE
 func MxConnectE2EE(input *C.char) *C.char {
+	return runExport(connectE2EE, input)
+}
+
+func connectE2EE(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle uint64 `json:"handle"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	if err := client.ConnectE2EE(); err != nil {
-		return fail(err)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer cancel()
 
-	return success(map[string]interface{}{})
+	if err := client.ConnectE2EE(ctx); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
 }
 
 //export MxDisconnect
@@ -130,16 +181,7 @@ func MxDisconnect(input *C.char) *C.char {
 		return fail(fmt.Errorf("invalid json: %w", err))
 	}
 
-	clientsMu.Lock()
-	client := clients[handle(payload.Handle)]
-	if client != nil {
-		delete(clients, handle(payload.Handle))
-	}
-	clientsMu.Unlock()
-
-	if client != nil {
-		client.Disconnect()
-	}
+	manager.Remove(payload.Handle)
 
 	return success(map[string]interface{}{})
 }
@@ -153,9 +195,7 @@ func MxIsConnected(input *C.char) *C.char {
 		return fail(fmt.Errorf("invalid json: %w", err))
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
 		return fail(fmt.Errorf("client not found"))
 	}
@@ -168,851 +208,2325 @@ func MxIsConnected(input *C.char) *C.char {
 
 //export MxSendMessage
 func MxSendMessage(input *C.char) *C.char {
+	return runExport(sendMessage, input)
+}
+
+func sendMessage(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle  uint64                    `json:"handle"`
 		Options bridge.SendMessageOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	result, err := client.SendMessage(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(result)
+	result, err := client.SendMessage(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
 //export MxSendReaction
 func MxSendReaction(input *C.char) *C.char {
+	return runExport(sendReaction, input)
+}
+
+func sendReaction(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle    uint64 `json:"handle"`
 		ThreadID  int64  `json:"threadId"`
 		MessageID string `json:"messageId"`
 		Emoji     string `json:"emoji"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	if err := client.SendReaction(payload.ThreadID, payload.MessageID, payload.Emoji); err != nil {
-		return fail(err)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer cancel()
 
-	return success(map[string]interface{}{})
+	if err := client.SendReaction(ctx, payload.ThreadID, payload.MessageID, payload.Emoji); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
 }
 
 //export MxEditMessage
 func MxEditMessage(input *C.char) *C.char {
+	return runExport(editMessage, input)
+}
+
+func editMessage(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle    uint64 `json:"handle"`
 		MessageID string `json:"messageId"`
 		NewText   string `json:"newText"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	if err := client.EditMessage(payload.MessageID, payload.NewText); err != nil {
-		return fail(err)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer cancel()
 
-	return success(map[string]interface{}{})
+	if err := client.EditMessage(ctx, payload.MessageID, payload.NewText); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
 }
 
 //export MxUnsendMessage
 func MxUnsendMessage(input *C.char) *C.char {
+	return runExport(unsendMessage, input)
+}
+
+func unsendMessage(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle    uint64 `json:"handle"`
 		MessageID string `json:"messageId"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	if err := client.UnsendMessage(payload.MessageID); err != nil {
-		return fail(err)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer cancel()
 
-	return success(map[string]interface{}{})
+	if err := client.UnsendMessage(ctx, payload.MessageID); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxDeleteMessageForMe
+func MxDeleteMessageForMe(input *C.char) *C.char {
+	return runExport(deleteMessageForMe, input)
+}
+
+func deleteMessageForMe(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle    uint64 `json:"handle"`
+		ThreadID  int64  `json:"threadId"`
+		MessageID string `json:"messageId"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.DeleteMessageForMe(ctx, payload.ThreadID, payload.MessageID); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxFetchReactions
+func MxFetchReactions(input *C.char) *C.char {
+	return runExport(fetchReactions, input)
+}
+
+func fetchReactions(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle  uint64                       `json:"handle"`
+		Options bridge.FetchReactionsOptions `json:"options"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	reactions, err := client.FetchReactions(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"reactions": reactions,
+	}, nil
+}
+
+//export MxSendReactionV2
+func MxSendReactionV2(input *C.char) *C.char {
+	return runExport(sendReactionV2, input)
+}
+
+func sendReactionV2(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle  uint64                       `json:"handle"`
+		Options bridge.SendReactionV2Options `json:"options"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.SendReactionV2(ctx, &payload.Options); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
 }
 
 //export MxSendTyping
 func MxSendTyping(input *C.char) *C.char {
+	return runExport(sendTyping, input)
+}
+
+func sendTyping(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle     uint64 `json:"handle"`
 		ThreadID   int64  `json:"threadId"`
 		IsTyping   bool   `json:"isTyping"`
 		IsGroup    bool   `json:"isGroup"`
 		ThreadType int64  `json:"threadType"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	if err := client.SendTypingIndicator(payload.ThreadID, payload.IsTyping, payload.IsGroup, payload.ThreadType); err != nil {
-		return fail(err)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer cancel()
 
-	return success(map[string]interface{}{})
+	if err := client.SendTypingIndicator(ctx, payload.ThreadID, payload.IsTyping, payload.IsGroup, payload.ThreadType); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
 }
 
 //export MxMarkRead
 func MxMarkRead(input *C.char) *C.char {
+	return runExport(markRead, input)
+}
+
+func markRead(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle      uint64 `json:"handle"`
 		ThreadID    int64  `json:"threadId"`
 		WatermarkTs int64  `json:"watermarkTs"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	if err := client.MarkRead(payload.ThreadID, payload.WatermarkTs); err != nil {
-		return fail(err)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer cancel()
 
-	return success(map[string]interface{}{})
+	if err := client.MarkRead(ctx, payload.ThreadID, payload.WatermarkTs); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
 }
 
 //export MxUploadMedia
 func MxUploadMedia(input *C.char) *C.char {
+	return runExport(uploadMedia, input)
+}
+
+func uploadMedia(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle  uint64                    `json:"handle"`
 		Options bridge.UploadMediaOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	result, err := client.UploadMedia(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(result)
+	result, err := client.UploadMedia(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
 //export MxSendImage
 func MxSendImage(input *C.char) *C.char {
+	return runExport(sendImage, input)
+}
+
+func sendImage(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle  uint64                  `json:"handle"`
 		Options bridge.SendImageOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	result, err := client.SendImage(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(result)
+	result, err := client.SendImage(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
 //export MxSendVideo
 func MxSendVideo(input *C.char) *C.char {
+	return runExport(sendVideo, input)
+}
+
+func sendVideo(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle  uint64                  `json:"handle"`
 		Options bridge.SendVideoOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	result, err := client.SendVideo(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(result)
+	result, err := client.SendVideo(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
 //export MxSendVoice
 func MxSendVoice(input *C.char) *C.char {
+	return runExport(sendVoice, input)
+}
+
+func sendVoice(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle  uint64                  `json:"handle"`
 		Options bridge.SendVoiceOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	result, err := client.SendVoice(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(result)
+	result, err := client.SendVoice(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
 //export MxSendFile
 func MxSendFile(input *C.char) *C.char {
+	return runExport(sendFile, input)
+}
+
+func sendFile(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle  uint64                 `json:"handle"`
 		Options bridge.SendFileOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	result, err := client.SendFile(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(result)
+	result, err := client.SendFile(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
 //export MxSendSticker
 func MxSendSticker(input *C.char) *C.char {
+	return runExport(sendSticker, input)
+}
+
+func sendSticker(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle  uint64                    `json:"handle"`
 		Options bridge.SendStickerOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	result, err := client.SendSticker(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(result)
+	result, err := client.SendSticker(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
 //export MxCreateThread
 func MxCreateThread(input *C.char) *C.char {
+	return runExport(createThread, input)
+}
+
+func createThread(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle  uint64                     `json:"handle"`
 		Options bridge.CreateThreadOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	result, err := client.CreateThread(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(result)
+	result, err := client.CreateThread(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
 //export MxGetUserInfo
 func MxGetUserInfo(input *C.char) *C.char {
+	return runExport(getUserInfo, input)
+}
+
+func getUserInfo(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle  uint64                    `json:"handle"`
 		Options bridge.GetUserInfoOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	result, err := client.GetUserInfo(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(result)
+	result, err := client.GetUserInfo(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
-//export MxSetGroupPhoto
-func MxSetGroupPhoto(input *C.char) *C.char {
+//export MxGetUsersInfo
+func MxGetUsersInfo(input *C.char) *C.char {
+	return runExport(getUsersInfo, input)
+}
+
+func getUsersInfo(input json.RawMessage) (any, error) {
 	var payload struct {
-		Handle   uint64 `json:"handle"`
-		ThreadID int64  `json:"threadId"`
-		Data     string `json:"data"` // base64 encoded
-		MimeType string `json:"mimeType"`
+		callOptions
+		Handle  uint64                     `json:"handle"`
+		Options bridge.GetUsersInfoOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	// Decode base64 data
-	data, err := base64.StdEncoding.DecodeString(payload.Data)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(fmt.Errorf("invalid base64 data: %w", err))
+		return nil, err
 	}
+	defer cancel()
 
-	if err := client.SetGroupPhoto(&bridge.SetGroupPhotoOptions{
-		ThreadID: payload.ThreadID,
-		Data:     data,
-		MimeType: payload.MimeType,
-	}); err != nil {
-		return fail(err)
+	users, err := client.GetUsersInfo(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
 	}
 
-	return success(map[string]interface{}{})
+	return map[string]interface{}{
+		"users": users,
+	}, nil
 }
 
-//export MxRenameThread
-func MxRenameThread(input *C.char) *C.char {
+//export MxListContacts
+func MxListContacts(input *C.char) *C.char {
 	var payload struct {
 		Handle  uint64                     `json:"handle"`
-		Options bridge.RenameThreadOptions `json:"options"`
+		Options bridge.ListContactsOptions `json:"options"`
 	}
 	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
 		return fail(fmt.Errorf("invalid json: %w", err))
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
 		return fail(fmt.Errorf("client not found"))
 	}
 
-	if err := client.RenameThread(&payload.Options); err != nil {
-		return fail(err)
+	return success(map[string]interface{}{
+		"contacts": client.ListContacts(&payload.Options),
+	})
+}
+
+//export MxSetGroupPhoto
+func MxSetGroupPhoto(input *C.char) *C.char {
+	return runExport(setGroupPhoto, input)
+}
+
+func setGroupPhoto(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle   uint64 `json:"handle"`
+		ThreadID int64  `json:"threadId"`
+		Data     string `json:"data"` // base64 encoded
+		MimeType string `json:"mimeType"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	return success(map[string]interface{}{})
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	// Decode base64 data
+	data, err := base64.StdEncoding.DecodeString(payload.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 data: %w", err)
+	}
+
+	if err := client.SetGroupPhoto(ctx, &bridge.SetGroupPhotoOptions{
+		ThreadID: payload.ThreadID,
+		Data:     data,
+		MimeType: payload.MimeType,
+	}); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxRenameThread
+func MxRenameThread(input *C.char) *C.char {
+	return runExport(renameThread, input)
+}
+
+func renameThread(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle  uint64                     `json:"handle"`
+		Options bridge.RenameThreadOptions `json:"options"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.RenameThread(ctx, &payload.Options); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
 }
 
 //export MxMuteThread
 func MxMuteThread(input *C.char) *C.char {
+	return runExport(muteThread, input)
+}
+
+func muteThread(input json.RawMessage) (any, error) {
 	var payload struct {
+		callOptions
 		Handle  uint64                   `json:"handle"`
 		Options bridge.MuteThreadOptions `json:"options"`
 	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.MuteThread(ctx, &payload.Options); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxDeleteThread
+func MxDeleteThread(input *C.char) *C.char {
+	return runExport(deleteThread, input)
+}
+
+func deleteThread(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle  uint64                     `json:"handle"`
+		Options bridge.DeleteThreadOptions `json:"options"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.DeleteThread(ctx, &payload.Options); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxMarkUnread
+func MxMarkUnread(input *C.char) *C.char {
+	return runExport(markUnread, input)
+}
+
+func markUnread(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle  uint64                   `json:"handle"`
+		Options bridge.MarkUnreadOptions `json:"options"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.MarkUnread(ctx, &payload.Options); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxListCommunityChats
+func MxListCommunityChats(input *C.char) *C.char {
+	return runExport(listCommunityChats, input)
+}
+
+func listCommunityChats(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle  uint64                           `json:"handle"`
+		Options bridge.ListCommunityChatsOptions `json:"options"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	page, err := client.ListCommunityChats(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+//export MxFetchCommunityMembers
+func MxFetchCommunityMembers(input *C.char) *C.char {
+	return runExport(fetchCommunityMembers, input)
+}
+
+func fetchCommunityMembers(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle  uint64                              `json:"handle"`
+		Options bridge.FetchCommunityMembersOptions `json:"options"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	page, err := client.FetchCommunityMembers(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+//export MxCreateCommunitySubThread
+func MxCreateCommunitySubThread(input *C.char) *C.char {
+	return runExport(createCommunitySubThread, input)
+}
+
+func createCommunitySubThread(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle  uint64                                 `json:"handle"`
+		Options bridge.CreateCommunitySubThreadOptions `json:"options"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	threadID, err := client.CreateCommunitySubThread(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"threadId": threadID,
+	}, nil
+}
+
+//export MxDeleteCommunitySubThread
+func MxDeleteCommunitySubThread(input *C.char) *C.char {
+	return runExport(deleteCommunitySubThread, input)
+}
+
+func deleteCommunitySubThread(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle   uint64 `json:"handle"`
+		ThreadID int64  `json:"threadId"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.DeleteCommunitySubThread(ctx, payload.ThreadID); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxSearchUsers
+func MxSearchUsers(input *C.char) *C.char {
+	return runExport(searchUsers, input)
+}
+
+func searchUsers(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle  uint64                    `json:"handle"`
+		Options bridge.SearchUsersOptions `json:"options"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	users, err := client.SearchUsers(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"users": users,
+	}, nil
+}
+
+//export MxSearch
+func MxSearch(input *C.char) *C.char {
+	return runExport(search, input)
+}
+
+func search(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle  uint64               `json:"handle"`
+		Options bridge.SearchOptions `json:"options"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	results, err := client.Search(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"results": results,
+	}, nil
+}
+
+//export MxPollEvents
+func MxPollEvents(input *C.char) *C.char {
+	var payload struct {
+		Handle    uint64 `json:"handle"`
+		TimeoutMs int    `json:"timeoutMs"`
+	}
 	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
 		return fail(fmt.Errorf("invalid json: %w", err))
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
 		return fail(fmt.Errorf("client not found"))
 	}
 
-	if err := client.MuteThread(&payload.Options); err != nil {
-		return fail(err)
+	select {
+	case evt, ok := <-client.Events():
+		if !ok {
+			return success(map[string]interface{}{
+				"type": "closed",
+			})
+		}
+		return success(evt)
+	default:
+		return success(map[string]interface{}{
+			"type": "timeout",
+		})
+	}
+}
+
+//export MxConfigureManager
+func MxConfigureManager(input *C.char) *C.char {
+	var cfg bridge.ManagerConfig
+	if err := json.Unmarshal([]byte(C.GoString(input)), &cfg); err != nil {
+		return fail(fmt.Errorf("invalid json: %w", err))
 	}
 
+	if err := manager.Configure(&cfg); err != nil {
+		return fail(err)
+	}
 	return success(map[string]interface{}{})
 }
 
-//export MxDeleteThread
-func MxDeleteThread(input *C.char) *C.char {
+//export MxListClients
+func MxListClients(input *C.char) *C.char {
+	return success(map[string]interface{}{
+		"clients": manager.ListClients(),
+	})
+}
+
+//export MxGetClientInfo
+func MxGetClientInfo(input *C.char) *C.char {
+	var payload struct {
+		Handle uint64 `json:"handle"`
+	}
+	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
+		return fail(fmt.Errorf("invalid json: %w", err))
+	}
+
+	info, err := manager.ClientInfo(payload.Handle)
+	if err != nil {
+		return fail(err)
+	}
+	return success(info)
+}
+
+// asyncExports are the exports that MxCallAsync can run in the background, keyed by name
+var asyncExports = map[string]func(json.RawMessage) (any, error){
+	"MxConnect":                     connect,
+	"MxConnectE2EE":                 connectE2EE,
+	"MxSendMessage":                 sendMessage,
+	"MxSendReaction":                sendReaction,
+	"MxEditMessage":                 editMessage,
+	"MxUnsendMessage":               unsendMessage,
+	"MxDeleteMessageForMe":          deleteMessageForMe,
+	"MxFetchReactions":              fetchReactions,
+	"MxSendReactionV2":              sendReactionV2,
+	"MxSendTyping":                  sendTyping,
+	"MxMarkRead":                    markRead,
+	"MxUploadMedia":                 uploadMedia,
+	"MxSendImage":                   sendImage,
+	"MxSendVideo":                   sendVideo,
+	"MxSendVoice":                   sendVoice,
+	"MxSendFile":                    sendFile,
+	"MxSendSticker":                 sendSticker,
+	"MxCreateThread":                createThread,
+	"MxGetUserInfo":                 getUserInfo,
+	"MxGetUsersInfo":                getUsersInfo,
+	"MxSetGroupPhoto":               setGroupPhoto,
+	"MxRenameThread":                renameThread,
+	"MxMuteThread":                  muteThread,
+	"MxDeleteThread":                deleteThread,
+	"MxMarkUnread":                  markUnread,
+	"MxListCommunityChats":          listCommunityChats,
+	"MxFetchCommunityMembers":       fetchCommunityMembers,
+	"MxCreateCommunitySubThread":    createCommunitySubThread,
+	"MxDeleteCommunitySubThread":    deleteCommunitySubThread,
+	"MxSearchUsers":                 searchUsers,
+	"MxSearch":                      search,
+	"MxSendE2EEMessage":             sendE2EEMessage,
+	"MxSendE2EEReaction":            sendE2EEReaction,
+	"MxSendE2EETyping":              sendE2EETyping,
+	"MxMarkE2EERead":                markE2EERead,
+	"MxEditE2EEMessage":             editE2EEMessage,
+	"MxUnsendE2EEMessage":           unsendE2EEMessage,
+	"MxSetE2EEDisappearingTimer":    setE2EEDisappearingTimer,
+	"MxGetE2EESafetyNumber":         getE2EESafetyNumber,
+	"MxCompareE2EESafetyNumber":     compareE2EESafetyNumber,
+	"MxTrustE2EEIdentity":           trustE2EEIdentity,
+	"MxSendE2EEImage":               sendE2EEImage,
+	"MxSendE2EEVideo":               sendE2EEVideo,
+	"MxSendE2EEAudio":               sendE2EEAudio,
+	"MxSendE2EEDocument":            sendE2EEDocument,
+	"MxSendE2EESticker":             sendE2EESticker,
+	"MxDownloadE2EEMedia":           downloadE2EEMedia,
+	"MxRegisterPushNotifications":   registerPushNotifications,
+	"MxFetchInstagramMedia":         fetchInstagramMedia,
+	"MxFetchInstagramReel":          fetchInstagramReel,
+	"MxFetchInstagramProfile":       fetchInstagramProfile,
+	"MxGetE2EEGroupInfo":            getE2EEGroupInfo,
+	"MxCreateE2EEGroup":             createE2EEGroup,
+	"MxAddE2EEGroupParticipants":    addE2EEGroupParticipants,
+	"MxRemoveE2EEGroupParticipants": removeE2EEGroupParticipants,
+}
+
+//export MxCallAsync
+func MxCallAsync(input *C.char) *C.char {
+	args := json.RawMessage(C.GoString(input))
+	var payload struct {
+		callOptions
+		Handle uint64 `json:"handle"`
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(args, &payload); err != nil {
+		return fail(fmt.Errorf("invalid json: %w", err))
+	}
+
+	fn := asyncExports[payload.Method]
+	if fn == nil {
+		return fail(fmt.Errorf("unknown async method: %s", payload.Method))
+	}
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return fail(fmt.Errorf("client not found"))
+	}
+
+	// The request ID is passed on so the call can be cancelled with MxCancel
+	if payload.RequestID == "" {
+		payload.RequestID = uuid.NewString()
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(args, &raw); err != nil {
+			return fail(fmt.Errorf("invalid json: %w", err))
+		}
+		raw["requestId"], _ = json.Marshal(payload.RequestID)
+		var err error
+		if args, err = json.Marshal(raw); err != nil {
+			return fail(err)
+		}
+	}
+
+	// Reserved now so a duplicate ID fails here instead of in a requestFailed event.
+	// beginCall in fn takes the reserved call over.
+	release, err := manager.ReserveCall(client, payload.RequestID, time.Duration(payload.TimeoutMs)*time.Millisecond)
+	if err != nil {
+		return fail(err)
+	}
+	err = client.RunRequest(func(err error) {
+		defer release()
+		var result any
+		if err == nil {
+			result, err = fn(args)
+		}
+		if err == nil {
+			client.CompleteRequest(&bridge.RequestCompletedEvent{
+				RequestID: payload.RequestID,
+				Method:    payload.Method,
+				Result:    result,
+			})
+			return
+		}
+		code, details := errorCode(err)
+		client.FailRequest(&bridge.RequestFailedEvent{
+			RequestID: payload.RequestID,
+			Method:    payload.Method,
+			Error:     err.Error(),
+			Code:      code,
+			Details:   details,
+		})
+	})
+	if err != nil {
+		release()
+		return fail(err)
+	}
+
+	return success(map[string]interface{}{
+		"requestId": payload.RequestID,
+	})
+}
+
+//export MxCancel
+func MxCancel(input *C.char) *C.char {
+	var payload struct {
+		RequestID string `json:"requestId"`
+	}
+	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
+		return fail(fmt.Errorf("invalid json: %w", err))
+	}
+
+	return success(map[string]interface{}{
+		"cancelled": manager.Cancel(payload.RequestID),
+	})
+}
+
+//export MxGetMetrics
+func MxGetMetrics(input *C.char) *C.char {
+	var payload struct {
+		Handle uint64 `json:"handle"`
+	}
+	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
+		return fail(fmt.Errorf("invalid json: %w", err))
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return fail(fmt.Errorf("client not found"))
+	}
+
+	metrics := client.Metrics()
+	if metrics == nil {
+		return fail(fmt.Errorf("metrics are not enabled for this client"))
+	}
+	return success(metrics)
+}
+
+//export MxServeMetrics
+func MxServeMetrics(input *C.char) *C.char {
+	var payload struct {
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
+		return fail(fmt.Errorf("invalid json: %w", err))
+	}
+
+	addr, err := manager.ServeMetrics(payload.Address)
+	if err != nil {
+		return fail(err)
+	}
+	return success(map[string]interface{}{
+		"address": addr,
+	})
+}
+
+//export MxStopMetrics
+func MxStopMetrics(input *C.char) *C.char {
+	manager.StopMetrics()
+	return success(map[string]interface{}{})
+}
+
+//export MxPollAllEvents
+func MxPollAllEvents(input *C.char) *C.char {
+	var payload struct {
+		TimeoutMs int `json:"timeoutMs"`
+	}
+	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
+		return fail(fmt.Errorf("invalid json: %w", err))
+	}
+
+	evt := manager.PollEvents(time.Duration(payload.TimeoutMs) * time.Millisecond)
+	if evt == nil {
+		return success(map[string]interface{}{
+			"type": "timeout",
+		})
+	}
+	return success(evt)
+}
+
+//export MxSetEventCallback
+func MxSetEventCallback(cb C.MxEventCallback) *C.char {
+	if cb == nil {
+		manager.SetEventHandler(nil)
+		return success(map[string]interface{}{})
+	}
+	manager.SetEventHandler(func(evt *bridge.ManagedEvent) {
+		data, err := json.Marshal(evt)
+		if err != nil {
+			return
+		}
+		cs := C.CString(string(data))
+		C.mxInvokeEventCallback(cb, cs)
+		C.free(unsafe.Pointer(cs))
+	})
+	return success(map[string]interface{}{})
+}
+
+// E2EE functions
+
+//export MxSendE2EEMessage
+func MxSendE2EEMessage(input *C.char) *C.char {
+	return runExport(sendE2EEMessage, input)
+}
+
+func sendE2EEMessage(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle           uint64              `json:"handle"`
+		ChatJID          string              `json:"chatJid"`
+		Text             string              `json:"text"`
+		ReplyToID        string              `json:"replyToId,omitempty"`
+		ReplyToSenderJID string              `json:"replyToSenderJid,omitempty"`
+		MentionIDs       []int64             `json:"mentionIds,omitempty"`
+		MentionOffsets   []int               `json:"mentionOffsets,omitempty"`
+		MentionLengths   []int               `json:"mentionLengths,omitempty"`
+		LinkPreview      *bridge.LinkPreview `json:"linkPreview,omitempty"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	result, err := client.SendMessage(ctx, &bridge.SendMessageOptions{
+		Text:                 payload.Text,
+		IsE2EE:               true,
+		E2EEChatJID:          payload.ChatJID,
+		E2EEReplyToID:        payload.ReplyToID,
+		E2EEReplyToSenderJID: payload.ReplyToSenderJID,
+		MentionIDs:           payload.MentionIDs,
+		MentionOffsets:       payload.MentionOffsets,
+		MentionLengths:       payload.MentionLengths,
+		LinkPreview:          payload.LinkPreview,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//export MxSendE2EEReaction
+func MxSendE2EEReaction(input *C.char) *C.char {
+	return runExport(sendE2EEReaction, input)
+}
+
+func sendE2EEReaction(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle    uint64 `json:"handle"`
+		ChatJID   string `json:"chatJid"`
+		MessageID string `json:"messageId"`
+		SenderJID string `json:"senderJid"`
+		Emoji     string `json:"emoji"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.SendE2EEReaction(ctx, payload.ChatJID, payload.MessageID, payload.SenderJID, payload.Emoji); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxSendE2EETyping
+func MxSendE2EETyping(input *C.char) *C.char {
+	return runExport(sendE2EETyping, input)
+}
+
+func sendE2EETyping(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle   uint64 `json:"handle"`
+		ChatJID  string `json:"chatJid"`
+		IsTyping bool   `json:"isTyping"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.SendE2EETyping(ctx, payload.ChatJID, payload.IsTyping); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxMarkE2EERead
+func MxMarkE2EERead(input *C.char) *C.char {
+	return runExport(markE2EERead, input)
+}
+
+func markE2EERead(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle     uint64   `json:"handle"`
+		ChatJID    string   `json:"chatJid"`
+		MessageIDs []string `json:"messageIds"`
+		SenderJID  string   `json:"senderJid,omitempty"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.MarkE2EERead(ctx, payload.ChatJID, payload.MessageIDs, payload.SenderJID); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxEditE2EEMessage
+func MxEditE2EEMessage(input *C.char) *C.char {
+	return runExport(editE2EEMessage, input)
+}
+
+func editE2EEMessage(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle    uint64 `json:"handle"`
+		ChatJID   string `json:"chatJid"`
+		MessageID string `json:"messageId"`
+		NewText   string `json:"newText"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.EditE2EEMessage(ctx, payload.ChatJID, payload.MessageID, payload.NewText); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxUnsendE2EEMessage
+func MxUnsendE2EEMessage(input *C.char) *C.char {
+	return runExport(unsendE2EEMessage, input)
+}
+
+func unsendE2EEMessage(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle    uint64 `json:"handle"`
+		ChatJID   string `json:"chatJid"`
+		MessageID string `json:"messageId"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.UnsendE2EEMessage(ctx, payload.ChatJID, payload.MessageID); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxSetE2EEDisappearingTimer
+func MxSetE2EEDisappearingTimer(input *C.char) *C.char {
+	return runExport(setE2EEDisappearingTimer, input)
+}
+
+func setE2EEDisappearingTimer(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle       uint64 `json:"handle"`
+		ChatJID      string `json:"chatJid"`
+		TimerSeconds uint32 `json:"timerSeconds"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.SetE2EEDisappearingTimer(ctx, payload.ChatJID, payload.TimerSeconds); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxGetE2EESafetyNumber
+func MxGetE2EESafetyNumber(input *C.char) *C.char {
+	return runExport(getE2EESafetyNumber, input)
+}
+
+func getE2EESafetyNumber(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle    uint64 `json:"handle"`
+		ContactID int64  `json:"contactId"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	result, err := client.GetE2EESafetyNumber(ctx, payload.ContactID)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//export MxCompareE2EESafetyNumber
+func MxCompareE2EESafetyNumber(input *C.char) *C.char {
+	return runExport(compareE2EESafetyNumber, input)
+}
+
+func compareE2EESafetyNumber(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle       uint64 `json:"handle"`
+		ContactID    int64  `json:"contactId"`
+		SafetyNumber string `json:"safetyNumber"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	matches, err := client.CompareE2EESafetyNumber(ctx, payload.ContactID, payload.SafetyNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"matches": matches,
+	}, nil
+}
+
+//export MxTrustE2EEIdentity
+func MxTrustE2EEIdentity(input *C.char) *C.char {
+	return runExport(trustE2EEIdentity, input)
+}
+
+func trustE2EEIdentity(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle    uint64 `json:"handle"`
+		ContactID int64  `json:"contactId"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := client.TrustE2EEIdentity(ctx, payload.ContactID); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+//export MxGetDeviceData
+func MxGetDeviceData(input *C.char) *C.char {
+	var payload struct {
+		Handle uint64 `json:"handle"`
+	}
+	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
+		return fail(fmt.Errorf("invalid json: %w", err))
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return fail(fmt.Errorf("client not found"))
+	}
+
+	if client.SQLStore != nil {
+		return fail(fmt.Errorf("device data is not available when using deviceDbPath"))
+	}
+	if client.DeviceStore == nil {
+		return fail(fmt.Errorf("device store not initialized"))
+	}
+
+	data, err := client.DeviceStore.GetDeviceData()
+	if err != nil {
+		return fail(err)
+	}
+
+	return success(map[string]interface{}{
+		"deviceData": data,
+	})
+}
+
+//export MxRotateDeviceKey
+func MxRotateDeviceKey(input *C.char) *C.char {
+	var payload struct {
+		Handle     uint64                         `json:"handle"`
+		Encryption *bridge.DeviceEncryptionConfig `json:"encryption"`
+	}
+	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
+		return fail(fmt.Errorf("invalid json: %w", err))
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return fail(fmt.Errorf("client not found"))
+	}
+
+	if err := client.RotateDeviceKey(payload.Encryption); err != nil {
+		return fail(err)
+	}
+
+	return success(map[string]interface{}{})
+}
+
+// ==================== E2EE Media Functions ====================
+
+//export MxSendE2EEImage
+func MxSendE2EEImage(input *C.char) *C.char {
+	return runExport(sendE2EEImage, input)
+}
+
+func sendE2EEImage(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle  uint64                      `json:"handle"`
+		Options bridge.SendE2EEImageOptions `json:"options"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	result, err := client.SendE2EEImage(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//export MxSendE2EEVideo
+func MxSendE2EEVideo(input *C.char) *C.char {
+	return runExport(sendE2EEVideo, input)
+}
+
+func sendE2EEVideo(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle  uint64                      `json:"handle"`
+		Options bridge.SendE2EEVideoOptions `json:"options"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	result, err := client.SendE2EEVideo(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//export MxSendE2EEAudio
+func MxSendE2EEAudio(input *C.char) *C.char {
+	return runExport(sendE2EEAudio, input)
+}
+
+func sendE2EEAudio(input json.RawMessage) (any, error) {
+	var payload struct {
+		callOptions
+		Handle  uint64                      `json:"handle"`
+		Options bridge.SendE2EEAudioOptions `json:"options"`
+	}
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	client := manager.Get(payload.Handle)
+	if client == nil {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	result, err := client.SendE2EEAudio(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//export MxSendE2EEDocument
+func MxSendE2EEDocument(input *C.char) *C.char {
+	return runExport(sendE2EEDocument, input)
+}
+
+func sendE2EEDocument(input json.RawMessage) (any, error) {
 	var payload struct {
-		Handle  uint64                     `json:"handle"`
-		Options bridge.DeleteThreadOptions `json:"options"`
+		callOptions
+		Handle  uint64                         `json:"handle"`
+		Options bridge.SendE2EEDocumentOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	if err := client.DeleteThread(&payload.Options); err != nil {
-		return fail(err)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer cancel()
 
-	return success(map[string]interface{}{})
+	result, err := client.SendE2EEDocument(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
-//export MxSearchUsers
-func MxSearchUsers(input *C.char) *C.char {
+//export MxSendE2EESticker
+func MxSendE2EESticker(input *C.char) *C.char {
+	return runExport(sendE2EESticker, input)
+}
+
+func sendE2EESticker(input json.RawMessage) (any, error) {
 	var payload struct {
-		Handle  uint64                    `json:"handle"`
-		Options bridge.SearchUsersOptions `json:"options"`
+		callOptions
+		Handle  uint64                        `json:"handle"`
+		Options bridge.SendE2EEStickerOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	users, err := client.SearchUsers(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(map[string]interface{}{
-		"users": users,
-	})
+	result, err := client.SendE2EESticker(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
-//export MxPollEvents
-func MxPollEvents(input *C.char) *C.char {
+//export MxDownloadE2EEMedia
+func MxDownloadE2EEMedia(input *C.char) *C.char {
+	return runExport(downloadE2EEMedia, input)
+}
+
+func downloadE2EEMedia(input json.RawMessage) (any, error) {
 	var payload struct {
-		Handle    uint64 `json:"handle"`
-		TimeoutMs int    `json:"timeoutMs"`
+		callOptions
+		Handle  uint64                          `json:"handle"`
+		Options bridge.DownloadE2EEMediaOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	select {
-	case evt, ok := <-client.Events():
-		if !ok {
-			return success(map[string]interface{}{
-				"type": "closed",
-			})
-		}
-		return success(evt)
-	default:
-		return success(map[string]interface{}{
-			"type": "timeout",
-		})
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
 	}
-}
+	defer cancel()
 
-// E2EE functions
+	result, err := client.DownloadE2EEMedia(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
 
-//export MxSendE2EEMessage
-func MxSendE2EEMessage(input *C.char) *C.char {
+	// Encode data as base64 for JSON transport
+	return map[string]interface{}{
+		"data":     base64.StdEncoding.EncodeToString(result.Data),
+		"mimeType": result.MimeType,
+		"fileSize": result.FileSize,
+	}, nil
+}
+
+//export MxGetCookies
+func MxGetCookies(input *C.char) *C.char {
 	var payload struct {
-		Handle           uint64 `json:"handle"`
-		ChatJID          string `json:"chatJid"`
-		Text             string `json:"text"`
-		ReplyToID        string `json:"replyToId,omitempty"`
-		ReplyToSenderJID string `json:"replyToSenderJid,omitempty"`
+		Handle uint64 `json:"handle"`
 	}
 	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
 		return fail(fmt.Errorf("invalid json: %w", err))
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
 		return fail(fmt.Errorf("client not found"))
 	}
 
-	result, err := client.SendMessage(&bridge.SendMessageOptions{
-		Text:                 payload.Text,
-		IsE2EE:               true,
-		E2EEChatJID:          payload.ChatJID,
-		E2EEReplyToID:        payload.ReplyToID,
-		E2EEReplyToSenderJID: payload.ReplyToSenderJID,
+	cookies := client.GetCookies()
+	return success(map[string]interface{}{
+		"cookies": cookies,
 	})
-	if err != nil {
-		return fail(err)
-	}
+}
 
-	return success(result)
+//export MxRegisterPushNotifications
+func MxRegisterPushNotifications(input *C.char) *C.char {
+	return runExport(registerPushNotifications, input)
 }
 
-//export MxSendE2EEReaction
-func MxSendE2EEReaction(input *C.char) *C.char {
+func registerPushNotifications(input json.RawMessage) (any, error) {
 	var payload struct {
-		Handle    uint64 `json:"handle"`
-		ChatJID   string `json:"chatJid"`
-		MessageID string `json:"messageId"`
-		SenderJID string `json:"senderJid"`
-		Emoji     string `json:"emoji"`
+		callOptions
+		Handle  uint64                                  `json:"handle"`
+		Options bridge.RegisterPushNotificationsOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	if err := client.SendE2EEReaction(payload.ChatJID, payload.MessageID, payload.SenderJID, payload.Emoji); err != nil {
-		return fail(err)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer cancel()
 
-	return success(map[string]interface{}{})
+	// Use background context since we can't pass one through FFI
+	if err := client.RegisterPushNotifications(ctx, &payload.Options); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{}, nil
 }
 
-//export MxSendE2EETyping
-func MxSendE2EETyping(input *C.char) *C.char {
+//export MxResolveInstagramIDs
+func MxResolveInstagramIDs(input *C.char) *C.char {
 	var payload struct {
-		Handle   uint64 `json:"handle"`
-		ChatJID  string `json:"chatJid"`
-		IsTyping bool   `json:"isTyping"`
+		Handle uint64              `json:"handle"`
+		IDs    bridge.InstagramIDs `json:"ids"`
 	}
 	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
 		return fail(fmt.Errorf("invalid json: %w", err))
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
 		return fail(fmt.Errorf("client not found"))
 	}
 
-	if err := client.SendE2EETyping(payload.ChatJID, payload.IsTyping); err != nil {
+	result, err := client.ResolveInstagramIDs(&payload.IDs)
+	if err != nil {
 		return fail(err)
 	}
 
-	return success(map[string]interface{}{})
+	return success(result)
 }
 
-//export MxEditE2EEMessage
-func MxEditE2EEMessage(input *C.char) *C.char {
+//export MxFetchInstagramMedia
+func MxFetchInstagramMedia(input *C.char) *C.char {
+	return runExport(fetchInstagramMedia, input)
+}
+
+func fetchInstagramMedia(input json.RawMessage) (any, error) {
 	var payload struct {
-		Handle    uint64 `json:"handle"`
-		ChatJID   string `json:"chatJid"`
-		MessageID string `json:"messageId"`
-		NewText   string `json:"newText"`
+		callOptions
+		Handle  uint64                            `json:"handle"`
+		Options bridge.FetchInstagramMediaOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	if err := client.EditE2EEMessage(payload.ChatJID, payload.MessageID, payload.NewText); err != nil {
-		return fail(err)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer cancel()
 
-	return success(map[string]interface{}{})
+	result, err := client.FetchInstagramMedia(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
-//export MxUnsendE2EEMessage
-func MxUnsendE2EEMessage(input *C.char) *C.char {
+//export MxFetchInstagramReel
+func MxFetchInstagramReel(input *C.char) *C.char {
+	return runExport(fetchInstagramReel, input)
+}
+
+func fetchInstagramReel(input json.RawMessage) (any, error) {
 	var payload struct {
-		Handle    uint64 `json:"handle"`
-		ChatJID   string `json:"chatJid"`
-		MessageID string `json:"messageId"`
+		callOptions
+		Handle  uint64                           `json:"handle"`
+		Options bridge.FetchInstagramReelOptions `json:"options"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	if err := client.UnsendE2EEMessage(payload.ChatJID, payload.MessageID); err != nil {
-		return fail(err)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer cancel()
 
-	return success(map[string]interface{}{})
+	result, err := client.FetchInstagramReel(ctx, &payload.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
-//export MxGetDeviceData
-func MxGetDeviceData(input *C.char) *C.char {
+//export MxFetchInstagramProfile
+func MxFetchInstagramProfile(input *C.char) *C.char {
+	return runExport(fetchInstagramProfile, input)
+}
+
+func fetchInstagramProfile(input json.RawMessage) (any, error) {
 	var payload struct {
-		Handle uint64 `json:"handle"`
+		callOptions
+		Handle   uint64 `json:"handle"`
+		Username string `json:"username"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	if client.DeviceStore == nil {
-		return fail(fmt.Errorf("device store not initialized"))
+	ctx, cancel, err := beginCall(client, payload.callOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer cancel()
 
-	data, err := client.DeviceStore.GetDeviceData()
+	result, err := client.FetchInstagramProfile(ctx, payload.Username)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
 
-	return success(map[string]interface{}{
-		"deviceData": data,
-	})
+	return result, nil
 }
 
-// ==================== E2EE Media Functions ====================
+// ==================== E2EE Group Functions ====================
 
-//export MxSendE2EEImage
-func MxSendE2EEImage(input *C.char) *C.char {
+//export MxGetE2EEGroupInfo
+func MxGetE2EEGroupInfo(input *C.char) *C.char {
+	return runExport(getE2EEGroupInfo, input)
+}
+
+func getE2EEGroupInfo(input json.RawMessage) (any, error) {
 	var payload struct {
-		Handle  uint64                      `json:"handle"`
-		Options bridge.SendE2EEImageOptions `json:"options"`
+		callOptions
+		Handle  uint64 `json:"handle"`
+		ChatJID string `json:"chatJid"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	result, err := client.SendE2EEImage(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(result)
+	info, err := client.GetE2EEGroupInfo(ctx, payload.ChatJID)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
 }
 
-//export MxSendE2EEVideo
-func MxSendE2EEVideo(input *C.char) *C.char {
+//export MxCreateE2EEGroup
+func MxCreateE2EEGroup(input *C.char) *C.char {
+	return runExport(createE2EEGroup, input)
+}
+
+func createE2EEGroup(input json.RawMessage) (any, error) {
 	var payload struct {
-		Handle  uint64                      `json:"handle"`
-		Options bridge.SendE2EEVideoOptions `json:"options"`
+		callOptions
+		Handle       uint64  `json:"handle"`
+		Name         string  `json:"name"`
+		Participants []int64 `json:"participants"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	result, err := client.SendE2EEVideo(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(result)
+	info, err := client.CreateE2EEGroup(ctx, payload.Name, payload.Participants)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
 }
 
-//export MxSendE2EEAudio
-func MxSendE2EEAudio(input *C.char) *C.char {
+//export MxAddE2EEGroupParticipants
+func MxAddE2EEGroupParticipants(input *C.char) *C.char {
+	return runExport(addE2EEGroupParticipants, input)
+}
+
+func addE2EEGroupParticipants(input json.RawMessage) (any, error) {
 	var payload struct {
-		Handle  uint64                      `json:"handle"`
-		Options bridge.SendE2EEAudioOptions `json:"options"`
+		callOptions
+		Handle       uint64  `json:"handle"`
+		ChatJID      string  `json:"chatJid"`
+		Participants []int64 `json:"participants"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	result, err := client.SendE2EEAudio(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(result)
+	result, err := client.AddE2EEGroupParticipants(ctx, payload.ChatJID, payload.Participants)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"participants": result,
+	}, nil
 }
 
-//export MxSendE2EEDocument
-func MxSendE2EEDocument(input *C.char) *C.char {
+//export MxRemoveE2EEGroupParticipants
+func MxRemoveE2EEGroupParticipants(input *C.char) *C.char {
+	return runExport(removeE2EEGroupParticipants, input)
+}
+
+func removeE2EEGroupParticipants(input json.RawMessage) (any, error) {
 	var payload struct {
-		Handle  uint64                         `json:"handle"`
-		Options bridge.SendE2EEDocumentOptions `json:"options"`
+		callOptions
+		Handle       uint64  `json:"handle"`
+		ChatJID      string  `json:"chatJid"`
+		Participants []int64 `json:"participants"`
 	}
-	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
-		return fail(fmt.Errorf("invalid json: %w", err))
+	if err := json.Unmarshal(input, &payload); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
-		return fail(fmt.Errorf("client not found"))
+		return nil, fmt.Errorf("client not found")
 	}
 
-	result, err := client.SendE2EEDocument(&payload.Options)
+	ctx, cancel, err := beginCall(client, payload.callOptions)
 	if err != nil {
-		return fail(err)
+		return nil, err
 	}
+	defer cancel()
 
-	return success(result)
+	result, err := client.RemoveE2EEGroupParticipants(ctx, payload.ChatJID, payload.Participants)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"participants": result,
+	}, nil
 }
 
-//export MxSendE2EESticker
-func MxSendE2EESticker(input *C.char) *C.char {
+// ==================== Outbox Functions ====================
+
+//export MxEnqueueMessage
+func MxEnqueueMessage(input *C.char) *C.char {
 	var payload struct {
-		Handle  uint64                        `json:"handle"`
-		Options bridge.SendE2EEStickerOptions `json:"options"`
+		Handle         uint64                    `json:"handle"`
+		IdempotencyKey string                    `json:"idempotencyKey"`
+		Options        bridge.SendMessageOptions `json:"options"`
 	}
 	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
 		return fail(fmt.Errorf("invalid json: %w", err))
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
 		return fail(fmt.Errorf("client not found"))
 	}
 
-	result, err := client.SendE2EESticker(&payload.Options)
+	item, err := client.EnqueueMessage(payload.IdempotencyKey, &payload.Options)
 	if err != nil {
 		return fail(err)
 	}
 
-	return success(result)
+	return success(item)
 }
 
-//export MxDownloadE2EEMedia
-func MxDownloadE2EEMedia(input *C.char) *C.char {
+//export MxGetOutboxItem
+func MxGetOutboxItem(input *C.char) *C.char {
 	var payload struct {
-		Handle  uint64                          `json:"handle"`
-		Options bridge.DownloadE2EEMediaOptions `json:"options"`
+		Handle         uint64 `json:"handle"`
+		IdempotencyKey string `json:"idempotencyKey"`
 	}
 	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
 		return fail(fmt.Errorf("invalid json: %w", err))
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
 		return fail(fmt.Errorf("client not found"))
 	}
 
-	result, err := client.DownloadE2EEMedia(&payload.Options)
+	item, err := client.GetOutboxItem(payload.IdempotencyKey)
 	if err != nil {
 		return fail(err)
 	}
 
-	// Encode data as base64 for JSON transport
-	return success(map[string]interface{}{
-		"data":     base64.StdEncoding.EncodeToString(result.Data),
-		"mimeType": result.MimeType,
-		"fileSize": result.FileSize,
-	})
+	return success(item)
 }
 
-//export MxGetCookies
-func MxGetCookies(input *C.char) *C.char {
+//export MxListOutbox
+func MxListOutbox(input *C.char) *C.char {
 	var payload struct {
 		Handle uint64 `json:"handle"`
 	}
@@ -1020,39 +2534,37 @@ func MxGetCookies(input *C.char) *C.char {
 		return fail(fmt.Errorf("invalid json: %w", err))
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
 		return fail(fmt.Errorf("client not found"))
 	}
 
-	cookies := client.GetCookies()
+	items, err := client.ListOutbox()
+	if err != nil {
+		return fail(err)
+	}
+
 	return success(map[string]interface{}{
-		"cookies": cookies,
+		"items": items,
 	})
 }
 
-//export MxRegisterPushNotifications
-func MxRegisterPushNotifications(input *C.char) *C.char {
+//export MxRemoveOutboxItem
+func MxRemoveOutboxItem(input *C.char) *C.char {
 	var payload struct {
-		Handle  uint64                                  `json:"handle"`
-		Options bridge.RegisterPushNotificationsOptions `json:"options"`
+		Handle         uint64 `json:"handle"`
+		IdempotencyKey string `json:"idempotencyKey"`
 	}
 	if err := json.Unmarshal([]byte(C.GoString(input)), &payload); err != nil {
 		return fail(fmt.Errorf("invalid json: %w", err))
 	}
 
-	clientsMu.RLock()
-	client := clients[handle(payload.Handle)]
-	clientsMu.RUnlock()
+	client := manager.Get(payload.Handle)
 	if client == nil {
 		return fail(fmt.Errorf("client not found"))
 	}
 
-	// Use background context since we can't pass one through FFI
-	ctx := context.Background()
-	if err := client.RegisterPushNotifications(ctx, &payload.Options); err != nil {
+	if err := client.RemoveOutboxItem(payload.IdempotencyKey); err != nil {
 		return fail(err)
 	}
 
@@ -20,18 +20,51 @@
 
 import { EventEmitter } from "node:events";
 
-import { native } from "./native.js";
+import { callOptionsStorage, native } from "./native.js";
 import type {
+    BlockStatusData,
+    CallOptions,
     ClientEvent,
+    ClientInfo,
     ClientOptions,
+    CommunityChatsPage,
+    CommunityMembersPage,
     Cookies,
     CreateThreadResult,
+    DeviceEncryptionConfig,
+    DisappearingTimerChangedData,
+    E2EEDecryptFailedData,
+    E2EEDecryptRecoveredData,
+    E2EEGroupInfo,
+    E2EEGroupMembershipData,
+    E2EEGroupParticipant,
+    E2EEGroupSubjectData,
     E2EEMessage,
+    FetchCommunityMembersOptions,
+    IdentityChangedData,
     InitialData,
+    InstagramIDs,
+    InstagramMediaItem,
+    InstagramProfile,
+    InstagramReel,
+    LinkPreview,
+    ListCommunityChatsOptions,
     Message,
+    MetricsSnapshot,
+    OutboxItem,
+    ReactionDetail,
+    RequestCompletedData,
+    RequestFailedData,
+    SafetyNumber,
+    SearchOptions,
+    SearchResult,
     SearchUserResult,
+    SendFailure,
     SendMessageOptions,
     SendMessageResult,
+    SendReactionV2Options,
+    ThreadFolderData,
+    ThreadUnreadData,
     UploadMediaResult,
     User,
     UserInfo,
@@ -54,14 +87,49 @@ export interface ClientEventMap {
     message: [Message];
     messageEdit: [{ messageId: string; threadId: bigint; newText: string; editCount?: bigint; timestampMs?: bigint }];
     messageUnsend: [{ messageId: string; threadId: bigint }];
-    reaction: [{ messageId: string; threadId: bigint; actorId: bigint; reaction: string; timestampMs?: bigint }];
-    typing: [{ threadId: bigint; senderId: bigint; isTyping: boolean }];
+    reaction: [
+        {
+            messageId: string;
+            threadId: bigint;
+            actorId: bigint;
+            reaction: string;
+            timestampMs?: bigint;
+            reactionFbid?: bigint;
+            count?: bigint;
+        },
+    ];
+    typing: [
+        {
+            threadId: bigint;
+            senderId: bigint;
+            isTyping: boolean;
+            chatJid?: string;
+            igThreadId?: string;
+            igSenderId?: string;
+        },
+    ];
     readReceipt: [{ threadId: bigint; readerId: bigint; readWatermarkTimestampMs: bigint; timestampMs?: bigint }];
     e2eeConnected: [];
     e2eeMessage: [E2EEMessage];
     e2eeReaction: [{ messageId: string; chatJid: string; senderJid: string; senderId?: bigint; reaction: string }];
     e2eeReceipt: [{ type: string; chat: string; sender: string; messageIds: string[] }];
     deviceDataChanged: [{ deviceData: string }];
+    messageSendConfirmed: [{ otid: string; messageId: string; threadId: bigint }];
+    messageSendFailed: [SendFailure];
+    outboxStatus: [OutboxItem];
+    e2eeGroupJoined: [E2EEGroupInfo];
+    e2eeGroupMembership: [E2EEGroupMembershipData];
+    e2eeGroupSubject: [E2EEGroupSubjectData];
+    e2eeDecryptFailed: [E2EEDecryptFailedData];
+    e2eeDecryptRecovered: [E2EEDecryptRecoveredData];
+    disappearingTimerChanged: [DisappearingTimerChangedData];
+    identityChanged: [IdentityChangedData];
+    threadFolder: [ThreadFolderData];
+    threadUnread: [ThreadUnreadData];
+    blockStatus: [BlockStatusData];
+    contactUpdated: [UserInfo];
+    requestCompleted: [RequestCompletedData];
+    requestFailed: [RequestFailedData];
     raw: [{ from: "lightspeed" | "whatsmeow" | "internal"; type: string; data: unknown }];
 }
 
@@ -195,8 +263,14 @@ export class Client<
             platform: this.options.platform,
             devicePath: this.options.devicePath,
             deviceData: this.options.deviceData,
+            deviceDbPath: this.options.deviceDbPath,
+            deviceEncryption: this.options.deviceEncryption,
             e2eeMemoryOnly: this.options.e2eeMemoryOnly,
             logLevel: this.options.logLevel,
+            outbox: this.options.outbox,
+            rateLimit: this.options.rateLimit,
+            trustPolicy: this.options.trustPolicy,
+            metrics: this.options.metrics,
         });
         this.handle = handle;
 
@@ -247,7 +321,9 @@ export class Client<
      *
      * @param threadId - Thread ID to send to
      * @param options - Message options (text, reply, mentions)
-     * @returns Send result with message ID
+     * @returns Send result with message ID. If Meta did not confirm the message yet, `pending` is set
+     * and a `messageSendConfirmed` or `messageSendFailed` event follows.
+     * @throws MessengerError with code "send_failed" if Meta rejected the message
      */
     async sendMessage(threadId: bigint, options: SendMessageOptions | string): Promise<SendMessageResult> {
         if (!this.handle) throw new Error("Not connected");
@@ -265,6 +341,66 @@ export class Client<
         });
     }
 
+    /**
+     * Queue a message in the outbox. It is sent in the background and retried with backoff
+     * until it succeeds or runs out of attempts, `outboxStatus` events report its progress.
+     * Requires the `outbox` client option.
+     *
+     * @param idempotencyKey - Unique key of the message. Enqueueing the same key again returns
+     * the existing item instead of sending the message twice.
+     * @param threadId - Thread ID to send to
+     * @param options - Message options (text, reply, mentions)
+     * @returns The outbox item
+     */
+    async enqueueMessage(
+        idempotencyKey: string,
+        threadId: bigint,
+        options: SendMessageOptions | string,
+    ): Promise<OutboxItem> {
+        if (!this.handle) throw new Error("Not connected");
+
+        const opts = typeof options === "string" ? { text: options } : options;
+
+        return native.enqueueMessage(this.handle, idempotencyKey, {
+            threadId,
+            text: opts.text,
+            replyToId: opts.replyToId,
+            attachmentFbIds: opts.attachmentFbIds,
+            mentionIds: opts.mentions?.map(m => m.userId),
+            mentionOffsets: opts.mentions?.map(m => m.offset),
+            mentionLengths: opts.mentions?.map(m => m.length),
+        });
+    }
+
+    /**
+     * Get an outbox item
+     *
+     * @param idempotencyKey - Key the message was enqueued with
+     */
+    async getOutboxItem(idempotencyKey: string): Promise<OutboxItem> {
+        if (!this.handle) throw new Error("Not connected");
+        return native.getOutboxItem(this.handle, idempotencyKey);
+    }
+
+    /**
+     * List all outbox items, including finished ones that are kept for deduplication
+     */
+    async listOutbox(): Promise<OutboxItem[]> {
+        if (!this.handle) throw new Error("Not connected");
+        const result = native.listOutbox(this.handle);
+        return result.items;
+    }
+
+    /**
+     * Remove an outbox item. Items that are being sent can't be removed.
+     *
+     * @param idempotencyKey - Key the message was enqueued with
+     */
+    async removeOutboxItem(idempotencyKey: string): Promise<void> {
+        if (!this.handle) throw new Error("Not connected");
+        native.removeOutboxItem(this.handle, idempotencyKey);
+    }
+
     /**
      * Send / Remove a reaction to a message
      *
@@ -329,6 +465,7 @@ export class Client<
      * @param filename - Filename
      * @param mimeType - MIME type
      * @param isVoice - Whether it's a voice message
+     * @param probe - Probe dimensions and duration into `info` (video and audio need ffprobe)
      * @returns Upload result with Facebook ID
      */
     async uploadMedia(
@@ -337,6 +474,7 @@ export class Client<
         filename: string,
         mimeType: string,
         isVoice: boolean = false,
+        probe: boolean = false,
     ): Promise<UploadMediaResult> {
         if (!this.handle) throw new Error("Not connected");
         return native.uploadMedia(this.handle, {
@@ -345,6 +483,7 @@ export class Client<
             mimeType,
             data: Array.from(data),
             isVoice,
+            probe,
         });
     }
 
@@ -404,19 +543,21 @@ export class Client<
      * @param threadId - Thread ID
      * @param data - Audio data as Buffer
      * @param filename - Filename
-     * @param options - Optional: replyToId
+     * @param options - Optional: mimeType (default: audio/mpeg), transcode (convert to MP4/AAC with ffmpeg), replyToId
      */
     async sendVoice(
         threadId: bigint,
         data: Buffer,
         filename: string,
-        options?: { replyToId?: string },
+        options?: { mimeType?: string; transcode?: boolean; replyToId?: string },
     ): Promise<SendMessageResult> {
         if (!this.handle) throw new Error("Not connected");
         return native.sendVoice(this.handle, {
             threadId,
             data: Array.from(data),
             filename,
+            mimeType: options?.mimeType,
+            transcode: options?.transcode,
             replyToId: options?.replyToId,
         });
     }
@@ -477,14 +618,39 @@ export class Client<
     }
 
     /**
-     * Get detailed information about a user
+     * Get detailed information about a user.
+     * Cached contacts are returned directly until they are a few hours old.
      *
      * @param userId - User ID
+     * @param refresh - Optional: ignore the cache
      * @returns User info
      */
-    async getUserInfo(userId: bigint): Promise<UserInfo> {
+    async getUserInfo(userId: bigint, refresh?: boolean): Promise<UserInfo> {
+        if (!this.handle) throw new Error("Not connected");
+        return native.getUserInfo(this.handle, { userId, refresh });
+    }
+
+    /**
+     * Get information about many users at once, only fetching the ones that aren't cached.
+     * Users that can't be found are left out of the result.
+     *
+     * @param userIds - User IDs
+     * @param refresh - Optional: ignore the cache
+     */
+    async getUsersInfo(userIds: bigint[], refresh?: boolean): Promise<UserInfo[]> {
+        if (!this.handle) throw new Error("Not connected");
+        const result = await native.getUsersInfo(this.handle, { userIds, refresh });
+        return result.users;
+    }
+
+    /**
+     * List the cached contact directory, sorted by name
+     *
+     * @param options - Optional: query (matches name or username), limit
+     */
+    async listContacts(options: { query?: string; limit?: number } = {}): Promise<UserInfo[]> {
         if (!this.handle) throw new Error("Not connected");
-        return native.getUserInfo(this.handle, { userId });
+        return native.listContacts(this.handle, options).contacts;
     }
 
     /**
@@ -562,15 +728,26 @@ export class Client<
      *
      * @param chatJid - Chat JID
      * @param text - Message text
-     * @param options - Optional: replyToId and replyToSenderJid for replies
+     * @param options - Optional: replyToId and replyToSenderJid for replies, mentions (UTF-16 offsets
+     * and lengths into text) and a link preview
      */
     async sendE2EEMessage(
         chatJid: string,
         text: string,
-        options?: { replyToId?: string; replyToSenderJid?: string },
+        options?: {
+            replyToId?: string;
+            replyToSenderJid?: string;
+            mentions?: Array<{ userId: bigint; offset: number; length: number }>;
+            linkPreview?: LinkPreview;
+        },
     ): Promise<SendMessageResult> {
         if (!this.handle) throw new Error("Not connected");
-        return native.sendE2EEMessage(this.handle, chatJid, text, options?.replyToId, options?.replyToSenderJid);
+        return native.sendE2EEMessage(this.handle, chatJid, text, options?.replyToId, options?.replyToSenderJid, {
+            mentionIds: options?.mentions?.map(m => m.userId),
+            mentionOffsets: options?.mentions?.map(m => m.offset),
+            mentionLengths: options?.mentions?.map(m => m.length),
+            linkPreview: options?.linkPreview,
+        });
     }
 
     /**
@@ -628,13 +805,20 @@ export class Client<
      * @param chatJid - Chat JID
      * @param data - Image data as Buffer
      * @param mimeType - MIME type (e.g., image/jpeg, image/png)
-     * @param options - Optional caption, dimensions, and reply options
+     * @param options - Optional caption, dimensions, view-once, and reply options
      */
     async sendE2EEImage(
         chatJid: string,
         data: Buffer,
         mimeType: string = "image/jpeg",
-        options?: { caption?: string; width?: number; height?: number; replyToId?: string; replyToSenderJid?: string },
+        options?: {
+            caption?: string;
+            width?: number;
+            height?: number;
+            viewOnce?: boolean;
+            replyToId?: string;
+            replyToSenderJid?: string;
+        },
     ): Promise<SendMessageResult> {
         if (!this.handle) throw new Error("Not connected");
         return native.sendE2EEImage(this.handle, {
@@ -644,6 +828,7 @@ export class Client<
             caption: options?.caption,
             width: options?.width,
             height: options?.height,
+            viewOnce: options?.viewOnce,
             replyToId: options?.replyToId,
             replyToSenderJid: options?.replyToSenderJid,
         });
@@ -655,7 +840,7 @@ export class Client<
      * @param chatJid - Chat JID
      * @param data - Video data as Buffer
      * @param mimeType - MIME type (default: video/mp4)
-     * @param options - Optional caption, dimensions, duration, and reply options
+     * @param options - Optional caption, dimensions, duration, view-once, and reply options
      */
     async sendE2EEVideo(
         chatJid: string,
@@ -666,6 +851,7 @@ export class Client<
             width?: number;
             height?: number;
             duration?: number;
+            viewOnce?: boolean;
             replyToId?: string;
             replyToSenderJid?: string;
         },
@@ -679,6 +865,7 @@ export class Client<
             width: options?.width,
             height: options?.height,
             duration: options?.duration,
+            viewOnce: options?.viewOnce,
             replyToId: options?.replyToId,
             replyToSenderJid: options?.replyToSenderJid,
         });
@@ -690,13 +877,20 @@ export class Client<
      * @param chatJid - Chat JID
      * @param data - Audio data as Buffer
      * @param mimeType - MIME type (default: audio/ogg)
-     * @param options - Optional PTT (push-to-talk/voice message), duration, and reply options
+     * @param options - Optional PTT (push-to-talk/voice message), duration, transcode (convert to OGG/Opus with
+     * ffmpeg and send as PTT), and reply options
      */
     async sendE2EEAudio(
         chatJid: string,
         data: Buffer,
         mimeType: string = "audio/ogg",
-        options?: { ptt?: boolean; duration?: number; replyToId?: string; replyToSenderJid?: string },
+        options?: {
+            ptt?: boolean;
+            duration?: number;
+            transcode?: boolean;
+            replyToId?: string;
+            replyToSenderJid?: string;
+        },
     ): Promise<SendMessageResult> {
         if (!this.handle) throw new Error("Not connected");
         return native.sendE2EEAudio(this.handle, {
@@ -705,6 +899,7 @@ export class Client<
             mimeType,
             ptt: options?.ptt ?? false,
             duration: options?.duration,
+            transcode: options?.transcode,
             replyToId: options?.replyToId,
             replyToSenderJid: options?.replyToSenderJid,
         });
@@ -774,6 +969,18 @@ export class Client<
         return result.deviceData;
     }
 
+    /**
+     * Re-encrypt the E2EE device data with a new key
+     *
+     * Not available with deviceDbPath, the SQLite store is not encrypted by the bridge.
+     *
+     * @param encryption - New key, or null to store the device data in clear text
+     */
+    async rotateDeviceKey(encryption: DeviceEncryptionConfig | null): Promise<void> {
+        if (!this.handle) throw new Error("Not connected");
+        native.rotateDeviceKey(this.handle, encryption);
+    }
+
     /**
      * Get the current cookies from the internal client state
      *
@@ -844,6 +1051,348 @@ export class Client<
         };
     }
 
+    // ========== E2EE Group Methods ==========
+
+    /**
+     * Create an E2EE group chat
+     *
+     * @param name - Group name
+     * @param participants - User IDs to add (not including yourself)
+     * @returns The created group
+     */
+    async createE2EEGroup(name: string, participants: bigint[]): Promise<E2EEGroupInfo> {
+        if (!this.handle) throw new Error("Not connected");
+        return native.createE2EEGroup(this.handle, name, participants);
+    }
+
+    /**
+     * Get an E2EE group and its participants
+     *
+     * @param chatJid - Group chat JID
+     */
+    async getE2EEGroupInfo(chatJid: string): Promise<E2EEGroupInfo> {
+        if (!this.handle) throw new Error("Not connected");
+        return native.getE2EEGroupInfo(this.handle, chatJid);
+    }
+
+    /**
+     * Add participants to an E2EE group
+     *
+     * @param chatJid - Group chat JID
+     * @param participants - User IDs to add
+     * @returns Result per participant, `error` is set for the ones that failed
+     */
+    async addE2EEGroupParticipants(chatJid: string, participants: bigint[]): Promise<E2EEGroupParticipant[]> {
+        if (!this.handle) throw new Error("Not connected");
+        const result = await native.addE2EEGroupParticipants(this.handle, chatJid, participants);
+        return result.participants;
+    }
+
+    /**
+     * Remove participants from an E2EE group
+     *
+     * @param chatJid - Group chat JID
+     * @param participants - User IDs to remove
+     * @returns Result per participant, `error` is set for the ones that failed
+     */
+    async removeE2EEGroupParticipants(chatJid: string, participants: bigint[]): Promise<E2EEGroupParticipant[]> {
+        if (!this.handle) throw new Error("Not connected");
+        const result = await native.removeE2EEGroupParticipants(this.handle, chatJid, participants);
+        return result.participants;
+    }
+
+    /**
+     * Set the disappearing message timer of an E2EE chat
+     *
+     * Emits `disappearingTimerChanged` once the server accepted the change. Regular threads
+     * can't be changed, a JID that isn't an E2EE chat throws a MessengerError with code "unsupported".
+     *
+     * @param chatJid - Chat JID
+     * @param timerSeconds - Timer in seconds, 0 turns disappearing messages off
+     */
+    async setE2EEDisappearingTimer(chatJid: string, timerSeconds: number): Promise<void> {
+        if (!this.handle) throw new Error("Not connected");
+        await native.setE2EEDisappearingTimer(this.handle, chatJid, timerSeconds);
+    }
+
+    // ========== E2EE Identity Methods ==========
+
+    /**
+     * Get the safety number with a contact
+     *
+     * @param contactId - Contact's Facebook ID
+     */
+    async getE2EESafetyNumber(contactId: bigint): Promise<SafetyNumber> {
+        if (!this.handle) throw new Error("Not connected");
+        return native.getE2EESafetyNumber(this.handle, contactId);
+    }
+
+    /**
+     * Check a safety number the contact read out or scanned. Whitespace is ignored.
+     *
+     * @param contactId - Contact's Facebook ID
+     * @param safetyNumber - Safety number to compare
+     * @returns Whether it matches the current safety number
+     */
+    async compareE2EESafetyNumber(contactId: bigint, safetyNumber: string): Promise<boolean> {
+        if (!this.handle) throw new Error("Not connected");
+        const result = await native.compareE2EESafetyNumber(this.handle, contactId, safetyNumber);
+        return result.matches;
+    }
+
+    /**
+     * Approve the changed identity keys of a contact that were rejected by the strict trust policy
+     *
+     * @param contactId - Contact's Facebook ID
+     */
+    async trustE2EEIdentity(contactId: bigint): Promise<void> {
+        if (!this.handle) throw new Error("Not connected");
+        await native.trustE2EEIdentity(this.handle, contactId);
+    }
+
+    // ========== Instagram Methods ==========
+
+    /**
+     * Fill in the missing form of Instagram thread and user IDs. Only IDs seen in synced
+     * threads, contacts or user lookups can be resolved.
+     *
+     * @param ids - Thread and/or user IDs in either form
+     */
+    async resolveInstagramIDs(ids: InstagramIDs): Promise<InstagramIDs> {
+        if (!this.handle) throw new Error("Not connected");
+        return native.resolveInstagramIDs(this.handle, ids);
+    }
+
+    /**
+     * Resolve an Instagram post or reel. Either mediaId or shortcode (from instagram.com/p/<shortcode>) must be set.
+     *
+     * @param options - mediaId or shortcode, and optionally downloadDir to download the media files into
+     */
+    async fetchInstagramMedia(options: {
+        mediaId?: string;
+        shortcode?: string;
+        downloadDir?: string;
+    }): Promise<InstagramMediaItem> {
+        if (!this.handle) throw new Error("Not connected");
+        return native.fetchInstagramMedia(this.handle, options);
+    }
+
+    /**
+     * Resolve Instagram stories or highlights. Highlight IDs have the format "highlight:<id>".
+     *
+     * @param reelIds - Reel IDs (user IDs for stories)
+     * @param options - Optional: mediaId to only return one item, downloadDir to download the media files into
+     */
+    async fetchInstagramReel(
+        reelIds: string[],
+        options?: { mediaId?: string; downloadDir?: string },
+    ): Promise<InstagramReel[]> {
+        if (!this.handle) throw new Error("Not connected");
+        return native.fetchInstagramReel(this.handle, {
+            reelIds,
+            mediaId: options?.mediaId,
+            downloadDir: options?.downloadDir,
+        });
+    }
+
+    /**
+     * Get the public profile of an Instagram user
+     *
+     * @param username - Instagram username
+     */
+    async fetchInstagramProfile(username: string): Promise<InstagramProfile> {
+        if (!this.handle) throw new Error("Not connected");
+        return native.fetchInstagramProfile(this.handle, username);
+    }
+
+    /**
+     * Send read receipts for E2EE messages
+     *
+     * @param chatJid - Chat JID
+     * @param messageIds - IDs of the messages that were read
+     * @param senderJid - Sender of the messages, required in groups
+     */
+    async markE2EERead(chatJid: string, messageIds: string[], senderJid?: string): Promise<void> {
+        if (!this.handle) throw new Error("Not connected");
+        await native.markE2EERead(this.handle, chatJid, messageIds, senderJid);
+    }
+
+    /**
+     * Search contacts, groups, pages, community chats and message content.
+     * Meta only returns the best message matches of each thread.
+     *
+     * @param query - Search text
+     * @param options - Optional: scopes (default users, groups and pages), threadId, limit
+     */
+    async search(query: string, options?: Omit<SearchOptions, "query">): Promise<SearchResult[]> {
+        if (!this.handle) throw new Error("Not connected");
+        const result = await native.search(this.handle, { ...options, query });
+        return result.results;
+    }
+
+    /**
+     * Delete a message only for the current user
+     *
+     * @param threadId - Thread ID
+     * @param messageId - Message ID to delete
+     */
+    async deleteMessageForMe(threadId: bigint, messageId: string): Promise<void> {
+        if (!this.handle) throw new Error("Not connected");
+        await native.deleteMessageForMe(this.handle, threadId, messageId);
+    }
+
+    /**
+     * List who reacted to a message with which reaction
+     *
+     * @param threadId - Thread ID
+     * @param messageId - Message ID
+     * @param reactionFbid - Optional: only list this reaction
+     */
+    async fetchReactions(threadId: bigint, messageId: string, reactionFbid?: bigint): Promise<ReactionDetail[]> {
+        if (!this.handle) throw new Error("Not connected");
+        const result = await native.fetchReactions(this.handle, { threadId, messageId, reactionFbid });
+        return result.reactions;
+    }
+
+    /**
+     * Add or remove a reaction without replacing the user's other reactions.
+     * Falls back to sendReaction if the reaction hasn't been seen on the message and no reactionFbid is given.
+     *
+     * @param options - Thread, message, reaction and whether to remove it
+     */
+    async sendReactionV2(options: SendReactionV2Options): Promise<void> {
+        if (!this.handle) throw new Error("Not connected");
+        await native.sendReactionV2(this.handle, options);
+    }
+
+    /**
+     * Mark a thread unread
+     *
+     * @param threadId - Thread ID
+     * @param lastMessageTimestampMs - Optional: timestamp of the last message, which becomes unread.
+     * Without it the whole thread is unread.
+     */
+    async markUnread(threadId: bigint, lastMessageTimestampMs?: bigint): Promise<void> {
+        if (!this.handle) throw new Error("Not connected");
+        await native.markUnread(this.handle, threadId, lastMessageTimestampMs);
+    }
+
+    /**
+     * Get this client's entry in the manager
+     */
+    async getClientInfo(): Promise<ClientInfo> {
+        if (!this.handle) throw new Error("Not connected");
+        return native.getClientInfo(this.handle);
+    }
+
+    /**
+     * Get a snapshot of this client's metrics, requires the metrics option
+     */
+    async getMetrics(): Promise<MetricsSnapshot> {
+        if (!this.handle) throw new Error("Not connected");
+        return native.getMetrics(this.handle);
+    }
+
+    /**
+     * Run calls with a timeout and/or request ID.
+     * Every call made inside fn, including after awaits, uses these options.
+     * Calls that time out or are cancelled throw a MessengerError with code "timeout" or "cancelled".
+     *
+     * @param options - timeoutMs and/or requestId
+     * @param fn - Function making the calls
+     *
+     * @example
+     * ```typescript
+     * await client.withCallOptions({ timeoutMs: 30_000, requestId: "upload-1" }, () =>
+     *     client.sendImage(threadId, data, "photo.jpg"),
+     * );
+     * ```
+     */
+    withCallOptions<T>(options: CallOptions, fn: () => Promise<T>): Promise<T> {
+        return callOptionsStorage.run(options, fn);
+    }
+
+    /**
+     * Cancel a running call started with a request ID
+     *
+     * @param requestId - Request ID given to withCallOptions
+     * @returns Whether a running call had the request ID
+     */
+    async cancelRequest(requestId: string): Promise<boolean> {
+        const result = native.cancel(requestId);
+        return result.cancelled;
+    }
+
+    /**
+     * Run a bridge call in the background instead of blocking a thread until it's done.
+     * The result is delivered with the requestCompleted or requestFailed event.
+     *
+     * @param method - Export to call, e.g. "MxSendMessage"
+     * @param payload - Payload of the export without the handle, may include requestId and timeoutMs
+     * @returns Request ID of the events, generated if the payload has none
+     * @throws If a request with the same requestId is still running
+     */
+    async startRequest(method: string, payload: Record<string, unknown> = {}): Promise<string> {
+        if (!this.handle) throw new Error("Not connected");
+        const result = native.callAsync(this.handle, method, payload);
+        return result.requestId;
+    }
+
+    // ========== Community Methods ==========
+
+    /**
+     * List the chats of a community, most recently active first
+     *
+     * @param communityId - Community ID
+     * @param options - Optional: beforeThreadId and beforeActivityTimestampMs (from the previous page),
+     * additionalPagesToFetch
+     */
+    async listCommunityChats(
+        communityId: bigint,
+        options?: Omit<ListCommunityChatsOptions, "communityId">,
+    ): Promise<CommunityChatsPage> {
+        if (!this.handle) throw new Error("Not connected");
+        return native.listCommunityChats(this.handle, { ...options, communityId });
+    }
+
+    /**
+     * List the members of a community or one of its chats
+     *
+     * @param communityId - Community ID
+     * @param options - Optional: threadId, cursor (from the previous page), query, adminsOnly
+     */
+    async fetchCommunityMembers(
+        communityId: bigint,
+        options?: Omit<FetchCommunityMembersOptions, "communityId">,
+    ): Promise<CommunityMembersPage> {
+        if (!this.handle) throw new Error("Not connected");
+        return native.fetchCommunityMembers(this.handle, { ...options, communityId });
+    }
+
+    /**
+     * Start a sub-thread from a message in a community chat
+     *
+     * @param threadId - Community chat thread ID
+     * @param messageId - Message to start the sub-thread from
+     * @param communityId - Optional: community ID, needed if the chat hasn't been synced yet
+     * @returns The sub-thread ID, or 0n if it arrives with the next sync
+     */
+    async createCommunitySubThread(threadId: bigint, messageId: string, communityId?: bigint): Promise<bigint> {
+        if (!this.handle) throw new Error("Not connected");
+        const result = await native.createCommunitySubThread(this.handle, { threadId, messageId, communityId });
+        return result.threadId;
+    }
+
+    /**
+     * Delete a sub-thread of a community chat
+     *
+     * @param threadId - Sub-thread ID
+     */
+    async deleteCommunitySubThread(threadId: bigint): Promise<void> {
+        if (!this.handle) throw new Error("Not connected");
+        await native.deleteCommunitySubThread(this.handle, threadId);
+    }
+
     private startEventLoop(): void {
         if (this.eventLoopRunning) return;
         this.eventLoopRunning = true;
@@ -935,6 +1484,27 @@ export class Client<
             case "raw":
                 this.emit("raw", event.data);
                 break;
+            case "messageSendConfirmed":
+                this.emit("messageSendConfirmed", event.data);
+                break;
+            case "messageSendFailed":
+                this.emit("messageSendFailed", event.data);
+                break;
+            case "outboxStatus":
+                this.emit("outboxStatus", event.data);
+                break;
+            case "disappearingTimerChanged":
+                this.emit("disappearingTimerChanged", event.data);
+                break;
+            case "identityChanged":
+                this.emit("identityChanged", event.data);
+                break;
+            case "requestCompleted":
+                this.emit("requestCompleted", event.data);
+                break;
+            case "requestFailed":
+                this.emit("requestFailed", event.data);
+                break;
 
             // queue until fullyReady
             case "message":
@@ -946,6 +1516,15 @@ export class Client<
             case "e2eeMessage":
             case "e2eeReaction":
             case "e2eeReceipt":
+            case "e2eeGroupJoined":
+            case "e2eeGroupMembership":
+            case "e2eeGroupSubject":
+            case "e2eeDecryptFailed":
+            case "e2eeDecryptRecovered":
+            case "threadFolder":
+            case "threadUnread":
+            case "blockStatus":
+            case "contactUpdated":
                 if (this._fullyReadyEmitted) {
                     this.emitEvent(event);
                 } else {
@@ -984,6 +1563,33 @@ export class Client<
             case "e2eeReceipt":
                 this.emit("e2eeReceipt", event.data);
                 break;
+            case "e2eeGroupJoined":
+                this.emit("e2eeGroupJoined", event.data);
+                break;
+            case "e2eeGroupMembership":
+                this.emit("e2eeGroupMembership", event.data);
+                break;
+            case "e2eeGroupSubject":
+                this.emit("e2eeGroupSubject", event.data);
+                break;
+            case "e2eeDecryptFailed":
+                this.emit("e2eeDecryptFailed", event.data);
+                break;
+            case "e2eeDecryptRecovered":
+                this.emit("e2eeDecryptRecovered", event.data);
+                break;
+            case "threadFolder":
+                this.emit("threadFolder", event.data);
+                break;
+            case "threadUnread":
+                this.emit("threadUnread", event.data);
+                break;
+            case "blockStatus":
+                this.emit("blockStatus", event.data);
+                break;
+            case "contactUpdated":
+                this.emit("contactUpdated", event.data);
+                break;
         }
     }
 
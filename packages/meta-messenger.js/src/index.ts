@@ -61,6 +61,7 @@
 // Exports all
 export * from "./client.js";
 export * from "./login.js";
+export * from "./manager.js";
 export * from "./types.js";
 export * from "./utils.js";
 
@@ -0,0 +1,83 @@
+/*
+ * meta-messenger.js
+ * Unofficial Meta Messenger Chat API for Node.js
+ *
+ * Copyright (c) 2026 Yumi Team and contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+
+import { native } from "./native.js";
+import type { ClientInfo, ManagedEvent, ManagerConfig } from "./types.js";
+
+/**
+ * The manager owning every client of this process, and the HTTP transport, media cache and event queue they share
+ */
+export const manager = {
+    /**
+     * Change the manager's config. Limits apply to clients created afterwards.
+     *
+     * @param config - Manager config
+     */
+    configure(config: ManagerConfig): void {
+        native.configureManager(config);
+    },
+
+    /**
+     * List the clients owned by the manager
+     */
+    listClients(): ClientInfo[] {
+        return native.listClients().clients;
+    },
+
+    /**
+     * Wait for the next multiplexed event of any client, requires multiplexEvents
+     *
+     * @param timeoutMs - How long to wait, 0 to only return a queued event
+     * @returns The event, or null on timeout
+     */
+    async pollEvents(timeoutMs = 1000): Promise<ManagedEvent | null> {
+        const event = (await native.pollAllEvents(timeoutMs)) as ManagedEvent | { type: "timeout" };
+        return event.type === "timeout" ? null : (event as ManagedEvent);
+    },
+
+    /**
+     * Serve the metrics of all clients in the Prometheus text format on /metrics
+     *
+     * @param address - Optional: listen address, default a free port on 127.0.0.1
+     * @returns The address the server listens on
+     */
+    serveMetrics(address = ""): string {
+        return native.serveMetrics(address).address;
+    },
+
+    /**
+     * Stop the metrics server
+     */
+    stopMetrics(): void {
+        native.stopMetrics();
+    },
+
+    /**
+     * Receive multiplexed events through a callback instead of pollEvents, requires multiplexEvents.
+     * Events are dropped (and counted in eventsDropped) if the handler falls more than eventBufferSize
+     * events behind, except requestCompleted and requestFailed, which wait for room.
+     *
+     * @param handler - Event handler, null to go back to pollEvents
+     */
+    setEventHandler(handler: ((event: ManagedEvent) => void) | null): void {
+        native.setEventCallback(handler);
+    },
+};
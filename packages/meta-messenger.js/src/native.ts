@@ -18,6 +18,7 @@
  * along with this program. If not, see <https://www.gnu.org/licenses/>.
  */
 
+import { AsyncLocalStorage } from "node:async_hooks";
 import fs from "node:fs";
 import path from "node:path";
 import { fileURLToPath } from "node:url";
@@ -25,6 +26,39 @@ import { fileURLToPath } from "node:url";
 import koffi from "koffi";
 import JSONBig from "yumi-json-bigint";
 
+import {
+    type CallOptions,
+    type ClientInfo,
+    type CommunityChatsPage,
+    type CommunityMembersPage,
+    type DeviceEncryptionConfig,
+    type E2EEGroupInfo,
+    type E2EEGroupParticipant,
+    type FetchCommunityMembersOptions,
+    type InstagramIDs,
+    type InstagramMediaItem,
+    type InstagramProfile,
+    type InstagramReel,
+    type LinkPreview,
+    type ListCommunityChatsOptions,
+    type ManagedEvent,
+    type ManagerConfig,
+    type MediaInfo,
+    MessengerError,
+    type MetricsSnapshot,
+    type OutboxConfig,
+    type OutboxItem,
+    type OutboxSendOptions,
+    type RateLimitConfig,
+    type ReactionDetail,
+    type SafetyNumber,
+    type SearchOptions,
+    type SearchResult,
+    type SendReactionV2Options,
+    type TrustPolicy,
+    type UserInfo,
+} from "./types.js";
+
 // Configure json-bigint to use native BigInt
 const JSONBigNative = JSONBig({
     useNativeBigInt: true,
@@ -51,6 +85,9 @@ const lib = koffi.load(LIB_FILE);
 
 const mk = (ret: string, name: string, args: string[]) => lib.func(name, ret, args);
 
+// Callback receiving the JSON of each multiplexed event
+const EventCallback = koffi.proto("void MxEventCallback(const char *event)");
+
 const fns = {
     MxFreeCString: mk("void", "MxFreeCString", ["char*"]),
     MxNewClient: mk("str", "MxNewClient", ["str"]),
@@ -84,6 +121,7 @@ const fns = {
     MxEditE2EEMessage: mk("str", "MxEditE2EEMessage", ["str"]),
     MxUnsendE2EEMessage: mk("str", "MxUnsendE2EEMessage", ["str"]),
     MxGetDeviceData: mk("str", "MxGetDeviceData", ["str"]),
+    MxRotateDeviceKey: mk("str", "MxRotateDeviceKey", ["str"]),
     // E2EE Media functions
     MxSendE2EEImage: mk("str", "MxSendE2EEImage", ["str"]),
     MxSendE2EEVideo: mk("str", "MxSendE2EEVideo", ["str"]),
@@ -94,26 +132,82 @@ const fns = {
     // Cookie and push notification functions
     MxGetCookies: mk("str", "MxGetCookies", ["str"]),
     MxRegisterPushNotifications: mk("str", "MxRegisterPushNotifications", ["str"]),
+    // Outbox functions
+    MxEnqueueMessage: mk("str", "MxEnqueueMessage", ["str"]),
+    MxGetOutboxItem: mk("str", "MxGetOutboxItem", ["str"]),
+    MxListOutbox: mk("str", "MxListOutbox", ["str"]),
+    MxRemoveOutboxItem: mk("str", "MxRemoveOutboxItem", ["str"]),
+    // E2EE group functions
+    MxCreateE2EEGroup: mk("str", "MxCreateE2EEGroup", ["str"]),
+    MxGetE2EEGroupInfo: mk("str", "MxGetE2EEGroupInfo", ["str"]),
+    MxAddE2EEGroupParticipants: mk("str", "MxAddE2EEGroupParticipants", ["str"]),
+    MxRemoveE2EEGroupParticipants: mk("str", "MxRemoveE2EEGroupParticipants", ["str"]),
+    MxSetE2EEDisappearingTimer: mk("str", "MxSetE2EEDisappearingTimer", ["str"]),
+    // E2EE identity functions
+    MxGetE2EESafetyNumber: mk("str", "MxGetE2EESafetyNumber", ["str"]),
+    MxCompareE2EESafetyNumber: mk("str", "MxCompareE2EESafetyNumber", ["str"]),
+    MxTrustE2EEIdentity: mk("str", "MxTrustE2EEIdentity", ["str"]),
+    // Instagram functions
+    MxResolveInstagramIDs: mk("str", "MxResolveInstagramIDs", ["str"]),
+    MxFetchInstagramMedia: mk("str", "MxFetchInstagramMedia", ["str"]),
+    MxFetchInstagramReel: mk("str", "MxFetchInstagramReel", ["str"]),
+    MxFetchInstagramProfile: mk("str", "MxFetchInstagramProfile", ["str"]),
+    MxMarkE2EERead: mk("str", "MxMarkE2EERead", ["str"]),
+    MxSearch: mk("str", "MxSearch", ["str"]),
+    MxDeleteMessageForMe: mk("str", "MxDeleteMessageForMe", ["str"]),
+    MxFetchReactions: mk("str", "MxFetchReactions", ["str"]),
+    MxSendReactionV2: mk("str", "MxSendReactionV2", ["str"]),
+    MxMarkUnread: mk("str", "MxMarkUnread", ["str"]),
+    // Community functions
+    MxListCommunityChats: mk("str", "MxListCommunityChats", ["str"]),
+    MxFetchCommunityMembers: mk("str", "MxFetchCommunityMembers", ["str"]),
+    MxCreateCommunitySubThread: mk("str", "MxCreateCommunitySubThread", ["str"]),
+    MxDeleteCommunitySubThread: mk("str", "MxDeleteCommunitySubThread", ["str"]),
+    MxGetUsersInfo: mk("str", "MxGetUsersInfo", ["str"]),
+    MxListContacts: mk("str", "MxListContacts", ["str"]),
+    // Manager functions
+    MxConfigureManager: mk("str", "MxConfigureManager", ["str"]),
+    MxSetEventCallback: lib.func("MxSetEventCallback", "str", [koffi.pointer(EventCallback)]),
+    MxListClients: mk("str", "MxListClients", ["str"]),
+    MxGetClientInfo: mk("str", "MxGetClientInfo", ["str"]),
+    MxPollAllEvents: mk("str", "MxPollAllEvents", ["str"]),
+    MxGetMetrics: mk("str", "MxGetMetrics", ["str"]),
+    MxServeMetrics: mk("str", "MxServeMetrics", ["str"]),
+    MxStopMetrics: mk("str", "MxStopMetrics", ["str"]),
+    MxCancel: mk("str", "MxCancel", ["str"]),
+    MxCallAsync: mk("str", "MxCallAsync", ["str"]),
 } as const;
 
 interface JsonResp<T = unknown> {
     ok: boolean;
     data?: T;
     error?: string;
+    code?: string;
+    details?: unknown;
 }
 
+type SendResult = { messageId: string; timestampMs: bigint; otid?: string; pending?: boolean };
+
+// Call options of the calls made inside Client.withCallOptions, kept across awaits
+export const callOptionsStorage = new AsyncLocalStorage<CallOptions>();
+
 function call<T>(fn: keyof typeof fns, payload: unknown): T {
+    const options = callOptionsStorage.getStore();
+    if (options) payload = { ...options, ...(payload as object) };
     // Use JSONBigNative.stringify to serialize BigInt as numbers (not strings)
     const input = JSONBigNative.stringify(payload);
     const bound = fns[fn] as (arg: string) => string;
     const out = bound(input);
     const data = JSONBigNative.parse(out) as JsonResp<T>;
-    if (!data.ok) throw new Error(data.error || "Unknown error");
+    if (!data.ok) throw new MessengerError(data.error || "Unknown error", data.code, data.details);
     return data.data as T;
 }
 
 // Async version that yields to event loop
 function callAsync<T>(fn: keyof typeof fns, payload: unknown): Promise<T> {
+    // Read the options now, the timeout below runs outside of the caller's async context
+    const options = callOptionsStorage.getStore();
+    if (options) payload = { ...options, ...(payload as object) };
     return new Promise((resolve, reject) => {
         // Use setTimeout(0) to yield to event loop
         setTimeout(() => {
@@ -127,14 +221,37 @@ function callAsync<T>(fn: keyof typeof fns, payload: unknown): Promise<T> {
     });
 }
 
+// Registered once and kept for the lifetime of the library, the Go side may still be calling it while it's replaced
+let eventCallback: ReturnType<typeof koffi.register> | null = null;
+let eventHandler: ((event: ManagedEvent) => void) | null = null;
+
+function setEventCallback(handler: ((event: ManagedEvent) => void) | null): void {
+    eventHandler = handler;
+    if (handler && !eventCallback) {
+        eventCallback = koffi.register(
+            (json: string) => eventHandler?.(JSONBigNative.parse(json) as ManagedEvent),
+            koffi.pointer(EventCallback),
+        );
+    }
+    const out = fns.MxSetEventCallback(handler ? eventCallback : null) as string;
+    const data = JSONBigNative.parse(out) as JsonResp<unknown>;
+    if (!data.ok) throw new MessengerError(data.error || "Unknown error", data.code, data.details);
+}
+
 export const native = {
     newClient: (cfg: {
         cookies: Record<string, string>;
         platform?: string;
         devicePath?: string;
         deviceData?: string;
+        deviceDbPath?: string;
+        deviceEncryption?: DeviceEncryptionConfig;
         e2eeMemoryOnly?: boolean;
         logLevel?: string;
+        outbox?: OutboxConfig;
+        rateLimit?: RateLimitConfig;
+        trustPolicy?: TrustPolicy;
+        metrics?: boolean;
     }) => call<{ handle: number }>("MxNewClient", cfg),
 
     connect: (handle: number) =>
@@ -164,7 +281,7 @@ export const native = {
             isE2EE?: boolean;
             e2eeChatJid?: string;
         },
-    ) => callAsync<{ messageId: string; timestampMs: bigint }>("MxSendMessage", { handle, options }),
+    ) => callAsync<SendResult>("MxSendMessage", { handle, options }),
 
     sendReaction: (handle: number, threadId: bigint, messageId: string, emoji: string) =>
         callAsync<unknown>("MxSendReaction", { handle, threadId, messageId, emoji }),
@@ -188,21 +305,31 @@ export const native = {
             mimeType: string;
             data: number[];
             isVoice?: boolean;
+            probe?: boolean;
         },
-    ) => callAsync<{ fbId: bigint; filename: string }>("MxUploadMedia", { handle, options }),
+    ) => callAsync<{ fbId: bigint; filename: string; info?: MediaInfo }>("MxUploadMedia", { handle, options }),
 
     sendImage: (
         handle: number,
         options: { threadId: bigint; data: number[]; filename: string; caption?: string; replyToId?: string },
-    ) => callAsync<{ messageId: string; timestampMs: bigint }>("MxSendImage", { handle, options }),
+    ) => callAsync<SendResult>("MxSendImage", { handle, options }),
 
     sendVideo: (
         handle: number,
         options: { threadId: bigint; data: number[]; filename: string; caption?: string; replyToId?: string },
-    ) => callAsync<{ messageId: string; timestampMs: bigint }>("MxSendVideo", { handle, options }),
+    ) => callAsync<SendResult>("MxSendVideo", { handle, options }),
 
-    sendVoice: (handle: number, options: { threadId: bigint; data: number[]; filename: string; replyToId?: string }) =>
-        callAsync<{ messageId: string; timestampMs: bigint }>("MxSendVoice", { handle, options }),
+    sendVoice: (
+        handle: number,
+        options: {
+            threadId: bigint;
+            data: number[];
+            filename: string;
+            mimeType?: string;
+            transcode?: boolean;
+            replyToId?: string;
+        },
+    ) => callAsync<SendResult>("MxSendVoice", { handle, options }),
 
     sendFile: (
         handle: number,
@@ -214,26 +341,22 @@ export const native = {
             caption?: string;
             replyToId?: string;
         },
-    ) => callAsync<{ messageId: string; timestampMs: bigint }>("MxSendFile", { handle, options }),
+    ) => callAsync<SendResult>("MxSendFile", { handle, options }),
 
     sendSticker: (handle: number, options: { threadId: bigint; stickerId: bigint; replyToId?: string }) =>
-        callAsync<{ messageId: string; timestampMs: bigint }>("MxSendSticker", { handle, options }),
+        callAsync<SendResult>("MxSendSticker", { handle, options }),
 
     createThread: (handle: number, options: { userId: bigint }) =>
         callAsync<{ threadId: bigint }>("MxCreateThread", { handle, options }),
 
-    getUserInfo: (handle: number, options: { userId: bigint }) =>
-        callAsync<{
-            id: bigint;
-            name: string;
-            firstName?: string;
-            username?: string;
-            profilePictureUrl?: string;
-            isMessengerUser?: boolean;
-            isVerified?: boolean;
-            gender?: number;
-            canViewerMessage?: boolean;
-        }>("MxGetUserInfo", { handle, options }),
+    getUserInfo: (handle: number, options: { userId: bigint; refresh?: boolean }) =>
+        callAsync<UserInfo>("MxGetUserInfo", { handle, options }),
+
+    getUsersInfo: (handle: number, options: { userIds: bigint[]; refresh?: boolean }) =>
+        callAsync<{ users: UserInfo[] }>("MxGetUsersInfo", { handle, options }),
+
+    listContacts: (handle: number, options: { query?: string; limit?: number }) =>
+        call<{ contacts: UserInfo[] }>("MxListContacts", { handle, options }),
 
     setGroupPhoto: (handle: number, threadId: bigint, data: string, mimeType: string) =>
         callAsync<unknown>("MxSetGroupPhoto", { handle, threadId, data, mimeType }),
@@ -256,13 +379,26 @@ export const native = {
     pollEvents: (handle: number, timeoutMs: number) => callAsync<unknown>("MxPollEvents", { handle, timeoutMs }),
 
     // E2EE functions
-    sendE2EEMessage: (handle: number, chatJid: string, text: string, replyToId?: string, replyToSenderJid?: string) =>
-        callAsync<{ messageId: string; timestampMs: bigint }>("MxSendE2EEMessage", {
+    sendE2EEMessage: (
+        handle: number,
+        chatJid: string,
+        text: string,
+        replyToId?: string,
+        replyToSenderJid?: string,
+        extra?: {
+            mentionIds?: bigint[];
+            mentionOffsets?: number[];
+            mentionLengths?: number[];
+            linkPreview?: LinkPreview;
+        },
+    ) =>
+        callAsync<SendResult>("MxSendE2EEMessage", {
             handle,
             chatJid,
             text,
             replyToId,
             replyToSenderJid,
+            ...extra,
         }),
 
     sendE2EEReaction: (handle: number, chatJid: string, messageId: string, senderJid: string, emoji: string) =>
@@ -279,6 +415,9 @@ export const native = {
 
     getDeviceData: (handle: number) => call<{ deviceData: string }>("MxGetDeviceData", { handle }),
 
+    rotateDeviceKey: (handle: number, encryption: DeviceEncryptionConfig | null) =>
+        call<unknown>("MxRotateDeviceKey", { handle, encryption }),
+
     // E2EE Media functions
     sendE2EEImage: (
         handle: number,
@@ -289,10 +428,11 @@ export const native = {
             caption?: string;
             width?: number;
             height?: number;
+            viewOnce?: boolean;
             replyToId?: string;
             replyToSenderJid?: string;
         },
-    ) => callAsync<{ messageId: string; timestampMs: bigint }>("MxSendE2EEImage", { handle, options }),
+    ) => callAsync<SendResult>("MxSendE2EEImage", { handle, options }),
 
     sendE2EEVideo: (
         handle: number,
@@ -304,10 +444,11 @@ export const native = {
             width?: number;
             height?: number;
             duration?: number;
+            viewOnce?: boolean;
             replyToId?: string;
             replyToSenderJid?: string;
         },
-    ) => callAsync<{ messageId: string; timestampMs: bigint }>("MxSendE2EEVideo", { handle, options }),
+    ) => callAsync<SendResult>("MxSendE2EEVideo", { handle, options }),
 
     sendE2EEAudio: (
         handle: number,
@@ -317,10 +458,11 @@ export const native = {
             mimeType: string;
             duration?: number;
             ptt?: boolean; // Push-to-talk (voice message)
+            transcode?: boolean; // Convert to OGG/Opus with ffmpeg and send as PTT
             replyToId?: string;
             replyToSenderJid?: string;
         },
-    ) => callAsync<{ messageId: string; timestampMs: bigint }>("MxSendE2EEAudio", { handle, options }),
+    ) => callAsync<SendResult>("MxSendE2EEAudio", { handle, options }),
 
     sendE2EEDocument: (
         handle: number,
@@ -332,7 +474,7 @@ export const native = {
             replyToId?: string;
             replyToSenderJid?: string;
         },
-    ) => callAsync<{ messageId: string; timestampMs: bigint }>("MxSendE2EEDocument", { handle, options }),
+    ) => callAsync<SendResult>("MxSendE2EEDocument", { handle, options }),
 
     sendE2EESticker: (
         handle: number,
@@ -343,7 +485,7 @@ export const native = {
             replyToId?: string;
             replyToSenderJid?: string;
         },
-    ) => callAsync<{ messageId: string; timestampMs: bigint }>("MxSendE2EESticker", { handle, options }),
+    ) => callAsync<SendResult>("MxSendE2EESticker", { handle, options }),
 
     downloadE2EEMedia: (
         handle: number,
@@ -369,5 +511,119 @@ export const native = {
         },
     ) => callAsync<unknown>("MxRegisterPushNotifications", { handle, options }),
 
+    // Outbox functions
+    enqueueMessage: (handle: number, idempotencyKey: string, options: OutboxSendOptions) =>
+        call<OutboxItem>("MxEnqueueMessage", { handle, idempotencyKey, options }),
+
+    getOutboxItem: (handle: number, idempotencyKey: string) =>
+        call<OutboxItem>("MxGetOutboxItem", { handle, idempotencyKey }),
+
+    listOutbox: (handle: number) => call<{ items: OutboxItem[] }>("MxListOutbox", { handle }),
+
+    removeOutboxItem: (handle: number, idempotencyKey: string) =>
+        call<unknown>("MxRemoveOutboxItem", { handle, idempotencyKey }),
+
+    // E2EE group functions
+    createE2EEGroup: (handle: number, name: string, participants: bigint[]) =>
+        callAsync<E2EEGroupInfo>("MxCreateE2EEGroup", { handle, name, participants }),
+
+    getE2EEGroupInfo: (handle: number, chatJid: string) =>
+        callAsync<E2EEGroupInfo>("MxGetE2EEGroupInfo", { handle, chatJid }),
+
+    addE2EEGroupParticipants: (handle: number, chatJid: string, participants: bigint[]) =>
+        callAsync<{ participants: E2EEGroupParticipant[] }>("MxAddE2EEGroupParticipants", {
+            handle,
+            chatJid,
+            participants,
+        }),
+
+    removeE2EEGroupParticipants: (handle: number, chatJid: string, participants: bigint[]) =>
+        callAsync<{ participants: E2EEGroupParticipant[] }>("MxRemoveE2EEGroupParticipants", {
+            handle,
+            chatJid,
+            participants,
+        }),
+
+    setE2EEDisappearingTimer: (handle: number, chatJid: string, timerSeconds: number) =>
+        callAsync<unknown>("MxSetE2EEDisappearingTimer", { handle, chatJid, timerSeconds }),
+
+    // E2EE identity functions
+    getE2EESafetyNumber: (handle: number, contactId: bigint) =>
+        callAsync<SafetyNumber>("MxGetE2EESafetyNumber", { handle, contactId }),
+
+    compareE2EESafetyNumber: (handle: number, contactId: bigint, safetyNumber: string) =>
+        callAsync<{ matches: boolean }>("MxCompareE2EESafetyNumber", { handle, contactId, safetyNumber }),
+
+    trustE2EEIdentity: (handle: number, contactId: bigint) =>
+        callAsync<unknown>("MxTrustE2EEIdentity", { handle, contactId }),
+
+    // Instagram functions
+    resolveInstagramIDs: (handle: number, ids: InstagramIDs) =>
+        call<InstagramIDs>("MxResolveInstagramIDs", { handle, ids }),
+
+    fetchInstagramMedia: (handle: number, options: { mediaId?: string; shortcode?: string; downloadDir?: string }) =>
+        callAsync<InstagramMediaItem>("MxFetchInstagramMedia", { handle, options }),
+
+    fetchInstagramReel: (handle: number, options: { reelIds: string[]; mediaId?: string; downloadDir?: string }) =>
+        callAsync<InstagramReel[]>("MxFetchInstagramReel", { handle, options }),
+
+    fetchInstagramProfile: (handle: number, username: string) =>
+        callAsync<InstagramProfile>("MxFetchInstagramProfile", { handle, username }),
+
+    markE2EERead: (handle: number, chatJid: string, messageIds: string[], senderJid?: string) =>
+        callAsync<unknown>("MxMarkE2EERead", { handle, chatJid, messageIds, senderJid }),
+
+    search: (handle: number, options: SearchOptions) =>
+        callAsync<{ results: SearchResult[] }>("MxSearch", { handle, options }),
+
+    deleteMessageForMe: (handle: number, threadId: bigint, messageId: string) =>
+        callAsync<unknown>("MxDeleteMessageForMe", { handle, threadId, messageId }),
+
+    fetchReactions: (handle: number, options: { threadId: bigint; messageId: string; reactionFbid?: bigint }) =>
+        callAsync<{ reactions: ReactionDetail[] }>("MxFetchReactions", { handle, options }),
+
+    sendReactionV2: (handle: number, options: SendReactionV2Options) =>
+        callAsync<unknown>("MxSendReactionV2", { handle, options }),
+
+    markUnread: (handle: number, threadId: bigint, lastMessageTimestampMs?: bigint) =>
+        callAsync<unknown>("MxMarkUnread", { handle, options: { threadId, lastMessageTimestampMs } }),
+
+    // Community functions
+    listCommunityChats: (handle: number, options: ListCommunityChatsOptions) =>
+        callAsync<CommunityChatsPage>("MxListCommunityChats", { handle, options }),
+
+    fetchCommunityMembers: (handle: number, options: FetchCommunityMembersOptions) =>
+        callAsync<CommunityMembersPage>("MxFetchCommunityMembers", { handle, options }),
+
+    createCommunitySubThread: (
+        handle: number,
+        options: { threadId: bigint; messageId: string; communityId?: bigint },
+    ) => callAsync<{ threadId: bigint }>("MxCreateCommunitySubThread", { handle, options }),
+
+    deleteCommunitySubThread: (handle: number, threadId: bigint) =>
+        callAsync<unknown>("MxDeleteCommunitySubThread", { handle, threadId }),
+
+    // Manager functions
+    configureManager: (cfg: ManagerConfig) => call<unknown>("MxConfigureManager", cfg),
+
+    listClients: () => call<{ clients: ClientInfo[] }>("MxListClients", {}),
+
+    getClientInfo: (handle: number) => call<ClientInfo>("MxGetClientInfo", { handle }),
+
+    pollAllEvents: (timeoutMs: number) => callAsync<unknown>("MxPollAllEvents", { timeoutMs }),
+
+    setEventCallback,
+
+    getMetrics: (handle: number) => call<MetricsSnapshot>("MxGetMetrics", { handle }),
+
+    serveMetrics: (address: string) => call<{ address: string }>("MxServeMetrics", { address }),
+
+    stopMetrics: () => call<unknown>("MxStopMetrics", {}),
+
+    cancel: (requestId: string) => call<{ cancelled: boolean }>("MxCancel", { requestId }),
+
+    callAsync: (handle: number, method: string, payload: Record<string, unknown>) =>
+        call<{ requestId: string }>("MxCallAsync", { ...payload, handle, method }),
+
     unload: () => lib.unload(),
 };
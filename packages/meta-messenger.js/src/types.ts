@@ -37,6 +37,22 @@ export type EventType =
     | "e2eeReaction"
     | "e2eeReceipt"
     | "deviceDataChanged"
+    | "messageSendConfirmed"
+    | "messageSendFailed"
+    | "outboxStatus"
+    | "e2eeGroupJoined"
+    | "e2eeGroupMembership"
+    | "e2eeGroupSubject"
+    | "e2eeDecryptFailed"
+    | "e2eeDecryptRecovered"
+    | "disappearingTimerChanged"
+    | "identityChanged"
+    | "threadFolder"
+    | "threadUnread"
+    | "blockStatus"
+    | "contactUpdated"
+    | "requestCompleted"
+    | "requestFailed"
     | "raw";
 
 /**
@@ -130,6 +146,10 @@ export interface ReactionEvent extends BaseEvent {
         actorId: bigint;
         reaction: string;
         timestampMs: bigint;
+        /** Set for reactions V2, where a user can add several reactions to a message */
+        reactionFbid?: bigint;
+        /** Number of users with this reaction, set for reactions V2 */
+        count?: bigint;
     };
 }
 
@@ -142,6 +162,11 @@ export interface TypingEvent extends BaseEvent {
         threadId: bigint;
         senderId: bigint;
         isTyping: boolean;
+        /** Set for typing in E2EE chats */
+        chatJid?: string;
+        /** Set for Instagram typing indicators, threadId and senderId are 0 if the Facebook ID isn't known yet */
+        igThreadId?: string;
+        igSenderId?: string;
     };
 }
 
@@ -209,6 +234,170 @@ export interface DeviceDataChangedEvent extends BaseEvent {
     };
 }
 
+/**
+ * Message send confirmed event - a send that returned `pending: true` was confirmed by Meta
+ */
+export interface MessageSendConfirmedEvent extends BaseEvent {
+    type: "messageSendConfirmed";
+    data: {
+        otid: string;
+        messageId: string;
+        threadId: bigint;
+    };
+}
+
+/**
+ * Message send failed event - a send that returned `pending: true` was rejected by Meta
+ */
+export interface MessageSendFailedEvent extends BaseEvent {
+    type: "messageSendFailed";
+    data: SendFailure;
+}
+
+/**
+ * Why Meta rejected an outgoing message
+ */
+export interface SendFailure {
+    otid: string;
+    threadId?: bigint;
+    /** Task ID, when the failure came from LSHandleFailedTask */
+    taskId?: bigint;
+    /** Reason given by Meta */
+    reason: string;
+    /** "optimistic" (LSMarkOptimisticMessageFailed) or "task" (LSHandleFailedTask) */
+    source: "optimistic" | "task";
+}
+
+/**
+ * Outbox status event - an outbox item was queued, sent or failed permanently
+ */
+export interface OutboxStatusEvent extends BaseEvent {
+    type: "outboxStatus";
+    data: OutboxItem;
+}
+
+/**
+ * E2EE group joined event - we were added to an encrypted group
+ */
+export interface E2EEGroupJoinedEvent extends BaseEvent {
+    type: "e2eeGroupJoined";
+    data: E2EEGroupInfo;
+}
+
+/**
+ * E2EE group membership event
+ */
+export interface E2EEGroupMembershipEvent extends BaseEvent {
+    type: "e2eeGroupMembership";
+    data: E2EEGroupMembershipData;
+}
+
+/**
+ * E2EE group subject event
+ */
+export interface E2EEGroupSubjectEvent extends BaseEvent {
+    type: "e2eeGroupSubject";
+    data: E2EEGroupSubjectData;
+}
+
+/**
+ * E2EE decrypt failed event
+ */
+export interface E2EEDecryptFailedEvent extends BaseEvent {
+    type: "e2eeDecryptFailed";
+    data: E2EEDecryptFailedData;
+}
+
+/**
+ * E2EE decrypt recovered event
+ */
+export interface E2EEDecryptRecoveredEvent extends BaseEvent {
+    type: "e2eeDecryptRecovered";
+    data: E2EEDecryptRecoveredData;
+}
+
+/**
+ * Disappearing timer changed event
+ */
+export interface DisappearingTimerChangedEvent extends BaseEvent {
+    type: "disappearingTimerChanged";
+    data: DisappearingTimerChangedData;
+}
+
+/**
+ * Identity changed event - a contact's E2EE identity key changed
+ */
+export interface IdentityChangedEvent extends BaseEvent {
+    type: "identityChanged";
+    data: IdentityChangedData;
+}
+
+/**
+ * Thread folder event - a thread was moved to another folder
+ */
+export interface ThreadFolderEvent extends BaseEvent {
+    type: "threadFolder";
+    data: ThreadFolderData;
+}
+
+/**
+ * Thread unread event - a thread was marked unread
+ */
+export interface ThreadUnreadEvent extends BaseEvent {
+    type: "threadUnread";
+    data: ThreadUnreadData;
+}
+
+/**
+ * Block status event - a user was blocked or unblocked
+ */
+export interface BlockStatusEvent extends BaseEvent {
+    type: "blockStatus";
+    data: BlockStatusData;
+}
+
+/**
+ * Contact updated event - the name or picture of a contact changed
+ */
+export interface ContactUpdatedEvent extends BaseEvent {
+    type: "contactUpdated";
+    data: UserInfo;
+}
+
+/**
+ * Request completed event - a request started with startRequest succeeded
+ */
+export interface RequestCompletedEvent extends BaseEvent {
+    type: "requestCompleted";
+    data: RequestCompletedData;
+}
+
+/**
+ * Request failed event - a request started with startRequest failed
+ */
+export interface RequestFailedEvent extends BaseEvent {
+    type: "requestFailed";
+    data: RequestFailedData;
+}
+
+/**
+ * Error thrown by native calls
+ *
+ * `code` is set for errors the bridge can classify, e.g. "send_failed" (details is a SendFailure),
+ * "unsupported" (a feature that's only available in E2EE chats), "timeout" or "cancelled".
+ */
+export class MessengerError extends Error {
+    readonly code?: string;
+    readonly details?: unknown;
+
+    constructor(message: string, code?: string, details?: unknown) {
+        super(message);
+        this.name = "MessengerError";
+        this.code = code;
+        this.details = details;
+    }
+}
+
 /**
  * Raw event source - indicates which channel the event came from
  */
@@ -256,6 +445,22 @@ export type ClientEvent =
     | E2EEReactionEvent
     | E2EEReceiptEvent
     | DeviceDataChangedEvent
+    | MessageSendConfirmedEvent
+    | MessageSendFailedEvent
+    | OutboxStatusEvent
+    | E2EEGroupJoinedEvent
+    | E2EEGroupMembershipEvent
+    | E2EEGroupSubjectEvent
+    | E2EEDecryptFailedEvent
+    | E2EEDecryptRecoveredEvent
+    | DisappearingTimerChangedEvent
+    | IdentityChangedEvent
+    | ThreadFolderEvent
+    | ThreadUnreadEvent
+    | BlockStatusEvent
+    | ContactUpdatedEvent
+    | RequestCompletedEvent
+    | RequestFailedEvent
     | RawEvent;
 
 /**
@@ -265,6 +470,8 @@ export interface User {
     id: bigint;
     name: string;
     username: string;
+    /** Instagram user ID, only on instagram */
+    igId?: string;
 }
 
 /**
@@ -276,6 +483,12 @@ export interface Thread {
     name: string;
     lastActivityTimestampMs: bigint;
     snippet: string;
+    /** Instagram thread ID, only on instagram */
+    igThreadId?: string;
+    /** Set for community sub-threads */
+    parentThreadId?: bigint;
+    /** Set for community chats and their sub-threads */
+    communityId?: bigint;
 }
 
 /**
@@ -376,6 +589,13 @@ export interface BaseMessage {
     replyTo?: ReplyTo;
     /** Mentioned users */
     mentions?: Mention[];
+    /**
+     * Media can only be viewed once. Reported for both kinds of threads, but view-once media
+     * can only be sent to E2EE chats.
+     */
+    viewOnce?: boolean;
+    /** When the message disappears (unix ms), set for disappearing messages with a known expiry */
+    expiresAt?: bigint;
 }
 
 /**
@@ -386,6 +606,12 @@ export interface BaseMessage {
 export interface Message extends BaseMessage {
     /** Whether this is an admin/system message */
     isAdminMsg?: boolean;
+    /** Parent chat, set for messages in community sub-threads */
+    parentThreadId?: bigint;
+    /** Set for messages in community chats and their sub-threads */
+    communityId?: bigint;
+    /** Set on the message a sub-thread was started from */
+    subThreadId?: bigint;
 }
 
 /**
@@ -449,6 +675,16 @@ export interface ClientOptions {
     devicePath?: string;
     /** E2EE device data as JSON string (takes priority over devicePath) */
     deviceData?: string;
+    /**
+     * SQLite database for the E2EE state (takes priority over devicePath and deviceData).
+     * An existing devicePath or deviceData is migrated into it on first use.
+     */
+    deviceDbPath?: string;
+    /**
+     * Encrypts the device file (devicePath) and the data of `deviceDataChanged` events.
+     * Can't be combined with deviceDbPath, the SQLite store is not encrypted by the bridge.
+     */
+    deviceEncryption?: DeviceEncryptionConfig;
     /** If true, E2EE state is stored in memory only (no file, no events). State will be lost on disconnect. Default: true */
     e2eeMemoryOnly?: boolean;
     /** Log level */
@@ -457,6 +693,129 @@ export interface ClientOptions {
     enableE2EE?: boolean;
     /** Auto reconnect on disconnect */
     autoReconnect?: boolean;
+    /** Enables the persistent outbox used by enqueueMessage */
+    outbox?: OutboxConfig;
+    /** Enables client-side rate limiting of outgoing actions */
+    rateLimit?: RateLimitConfig;
+    /**
+     * How changed E2EE identity keys of contacts are handled. Default: "tofu"
+     *
+     * - "tofu": the first key is trusted, changes are accepted and reported with `identityChanged`
+     * - "always": every key is accepted
+     * - "strict": changed keys are rejected until approved with trustE2EEIdentity
+     */
+    trustPolicy?: TrustPolicy;
+    /** Records metrics readable with getMetrics and the manager's Prometheus endpoint */
+    metrics?: boolean;
+}
+
+/**
+ * Device encryption options. Exactly one of passphrase or keyFile should be set.
+ * oldPassphrase/oldKeyFile open data written with a previous key, which is then
+ * re-encrypted with the current key.
+ */
+export interface DeviceEncryptionConfig {
+    passphrase?: string;
+    /** Any file with at least 32 bytes of secret material */
+    keyFile?: string;
+    oldPassphrase?: string;
+    oldKeyFile?: string;
+}
+
+/**
+ * Rate limited action types
+ *
+ * "manage" covers thread and contact management (mute, rename, folders, blocking...),
+ * "query" covers searches and lookups.
+ */
+export type RateLimitAction = "send" | "react" | "typing" | "upload" | "manage" | "query";
+
+/**
+ * Token bucket rule
+ */
+export interface RateLimitRule {
+    /** Tokens added per second, must be positive */
+    rate: number;
+    /** Bucket size. Default: max(1, rate) */
+    burst?: number;
+}
+
+/**
+ * Rate limit options. A rate limited call throws a MessengerError with code "rate_limited"
+ * and details `{ action, threadKey?, retryAfterMs }`.
+ */
+export interface RateLimitConfig {
+    /** Shared by all actions and threads */
+    global?: RateLimitRule;
+    /** One bucket per thread, shared by all actions */
+    perThread?: RateLimitRule;
+    /** Per action buckets */
+    actions?: Partial<Record<RateLimitAction, RateLimitRule>>;
+    /** "queue" (default) waits for a token, "reject" fails immediately */
+    mode?: "queue" | "reject";
+    /** Queue mode only: fail instead of waiting longer than this. Default: wait as long as needed */
+    maxWaitMs?: number;
+    /** Random delay added before each send */
+    jitterMinMs?: number;
+    jitterMaxMs?: number;
+}
+
+/**
+ * Outbox options. Durations are in milliseconds.
+ */
+export interface OutboxConfig {
+    /** JSON file the outbox is persisted to, memory only if not set */
+    path?: string;
+    /** Attempts before an item is marked failed. Default: 10 */
+    maxAttempts?: number;
+    /** Delay before the first retry, doubled on each attempt. Default: 1000 */
+    baseDelayMs?: number;
+    /** Upper bound of the retry delay. Default: 300000 */
+    maxDelayMs?: number;
+    /** How long finished items are kept for deduplication. Default: 24 hours */
+    retentionMs?: number;
+}
+
+/**
+ * State of an outbox item. "pending" means Meta accepted the send but hasn't confirmed it yet,
+ * it becomes "sent" or "failed" once the confirmation arrives.
+ */
+export type OutboxStatus = "queued" | "sending" | "pending" | "sent" | "failed";
+
+/**
+ * Message in the outbox
+ */
+export interface OutboxItem {
+    /** Idempotency key the item was enqueued with */
+    key: string;
+    /** Offline threading ID, reused for every attempt */
+    otid: bigint;
+    options: OutboxSendOptions;
+    status: OutboxStatus;
+    attempts: number;
+    lastError?: string;
+    /** Message ID, set once the message is sent */
+    messageId?: string;
+    createdAtMs: bigint;
+    updatedAtMs: bigint;
+    nextAttemptAtMs?: bigint;
+}
+
+/**
+ * Send options stored with an outbox item
+ */
+export interface OutboxSendOptions {
+    threadId: bigint;
+    text: string;
+    replyToId?: string;
+    mentionIds?: bigint[];
+    mentionOffsets?: number[];
+    mentionLengths?: number[];
+    attachmentFbIds?: bigint[];
+    stickerId?: bigint;
+    url?: string;
+    isE2EE?: boolean;
+    e2eeChatJid?: string;
 }
 
 /**
@@ -469,7 +828,7 @@ export interface SendMessageOptions {
     replyToId?: string;
     /** Pre-uploaded attachment Facebook IDs (from uploadMedia) */
     attachmentFbIds?: bigint[];
-    /** User IDs to mention */
+    /** User IDs to mention. Offsets and lengths are in UTF-16 code units and must lie inside the text. */
     mentions?: Array<{
         userId: bigint;
         offset: number;
@@ -477,12 +836,31 @@ export interface SendMessageOptions {
     }>;
 }
 
+/**
+ * Link preview of an E2EE message
+ */
+export interface LinkPreview {
+    url: string;
+    /** The URL as it appears in the text. Default: url */
+    matchedText?: string;
+    title?: string;
+    description?: string;
+}
+
 /**
  * Send message result
  */
 export interface SendMessageResult {
+    /** Message ID, empty while `pending` is true */
     messageId: string;
     timestampMs: bigint;
+    /** Offline threading ID of the send (regular messages only) */
+    otid?: string;
+    /**
+     * True when Meta accepted the message but did not confirm it yet. A `messageSendConfirmed`
+     * or `messageSendFailed` event with the same otid follows.
+     */
+    pending?: boolean;
 }
 
 /**
@@ -491,6 +869,18 @@ export interface SendMessageResult {
 export interface UploadMediaResult {
     fbId: bigint;
     filename: string;
+    /** Locally probed dimensions and duration, only set when probing was requested */
+    info?: MediaInfo;
+}
+
+/**
+ * Metadata probed from media data. Video and audio need ffprobe to be installed.
+ */
+export interface MediaInfo {
+    width?: number;
+    height?: number;
+    /** Duration in seconds */
+    duration?: number;
 }
 
 /**
@@ -522,4 +912,488 @@ export interface UserInfo {
     isVerified?: boolean;
     gender?: number;
     canViewerMessage?: boolean;
+    /** Instagram user ID, only on Instagram */
+    igId?: string;
+    isBlocked?: boolean;
+    presence?: ContactPresence;
+    /** When the full contact was last received, unix ms */
+    updatedAtMs?: bigint;
+}
+
+/**
+ * Last known presence of a contact
+ */
+export interface ContactPresence {
+    status: number;
+    lastActiveTimestampMs?: bigint;
+}
+
+/**
+ * Member of an E2EE group
+ */
+export interface E2EEGroupParticipant {
+    jid: string;
+    userId?: bigint;
+    isAdmin: boolean;
+    isSuperAdmin: boolean;
+    /** Set when adding or removing this participant failed */
+    error?: number;
+}
+
+/**
+ * E2EE group chat
+ */
+export interface E2EEGroupInfo {
+    jid: string;
+    name: string;
+    topic?: string;
+    ownerJid?: string;
+    isLocked: boolean;
+    isAnnounce: boolean;
+    isEphemeral: boolean;
+    /** Disappearing message timer in seconds */
+    disappearingTimer?: number;
+    createdAtMs?: bigint;
+    participants: E2EEGroupParticipant[];
+}
+
+/**
+ * Members joined, left or changed admin status in an E2EE group
+ */
+export interface E2EEGroupMembershipData {
+    chatJid: string;
+    senderJid?: string;
+    joined?: string[];
+    left?: string[];
+    promoted?: string[];
+    demoted?: string[];
+    timestampMs: bigint;
+}
+
+/**
+ * The name or topic of an E2EE group changed
+ */
+export interface E2EEGroupSubjectData {
+    chatJid: string;
+    senderJid?: string;
+    name?: string;
+    topic?: string;
+    timestampMs: bigint;
+}
+
+/**
+ * An incoming E2EE message couldn't be decrypted. A retry is requested automatically,
+ * if the message arrives later an e2eeDecryptRecovered event with the same ID follows.
+ */
+export interface E2EEDecryptFailedData {
+    messageId: string;
+    chatJid: string;
+    senderJid: string;
+    senderId: bigint;
+    timestampMs: bigint;
+    /** The sender didn't send a ciphertext for this device at all */
+    isUnavailable: boolean;
+    /** e.g. "view_once" */
+    unavailableType?: string;
+    /** The sender asked for the failure not to be shown */
+    hidden: boolean;
+}
+
+/**
+ * A message that previously failed to decrypt was received after a retry.
+ * The e2eeMessage event for it is emitted as usual.
+ */
+export interface E2EEDecryptRecoveredData {
+    messageId: string;
+    chatJid: string;
+    senderJid: string;
+    retryCount: number;
+    failedAtMs: bigint;
+}
+
+/**
+ * The disappearing message timer of a chat changed. Either chatJid (E2EE chats) or threadId (regular threads) is set.
+ * Regular threads only report changes made elsewhere, the timer can only be changed in E2EE chats.
+ */
+export interface DisappearingTimerChangedData {
+    chatJid?: string;
+    threadId?: bigint;
+    senderId?: bigint;
+    /** 0 means disappearing messages are off */
+    timerSeconds: number;
+    type: "none" | "afterSend" | "afterRead";
+    timestampMs: bigint;
+}
+
+/**
+ * How changed E2EE identity keys of contacts are handled
+ */
+export type TrustPolicy = "tofu" | "always" | "strict";
+
+/**
+ * The identity key of a contact's device changed
+ */
+export interface IdentityChangedData {
+    /** Signal address, "<userId>:<device>" */
+    address: string;
+    userId: bigint;
+    device: number;
+    oldFingerprint?: string;
+    newFingerprint: string;
+    /** False if the key was rejected by the strict policy */
+    trusted: boolean;
+    policy: TrustPolicy;
+    timestampMs: bigint;
+}
+
+/**
+ * Safety number of an E2EE contact, compare it out of band to verify the encryption
+ */
+export interface SafetyNumber {
+    contactId: bigint;
+    /** 60 digits */
+    safetyNumber: string;
+    localFingerprint: string;
+    remoteFingerprint: string;
+    /** Number of contact devices included */
+    devices: number;
+}
+
+/**
+ * Both forms of an Instagram thread or user ID
+ */
+export interface InstagramIDs {
+    threadId?: bigint;
+    igThreadId?: string;
+    userId?: bigint;
+    igUserId?: string;
+}
+
+/**
+ * Author of Instagram media
+ */
+export interface InstagramUser {
+    id: string;
+    username: string;
+    fullName?: string;
+    profilePicUrl?: string;
+    isVerified?: boolean;
+    isPrivate?: boolean;
+}
+
+/**
+ * A resolved Instagram post, reel or story item
+ */
+export interface InstagramMediaItem {
+    id: string;
+    shortcode?: string;
+    type: "image" | "video" | "carousel";
+    /** Best quality video, or image if there is no video */
+    url?: string;
+    /** Best quality image */
+    thumbnailUrl?: string;
+    width?: number;
+    height?: number;
+    /** Duration in seconds for videos */
+    duration?: number;
+    caption?: string;
+    author?: InstagramUser;
+    takenAtMs?: bigint;
+    /** Carousel children */
+    items?: InstagramMediaItem[];
+    /** Set when downloaded */
+    filePath?: string;
+}
+
+/**
+ * An Instagram story reel or highlight
+ */
+export interface InstagramReel {
+    id: string;
+    title?: string;
+    author?: InstagramUser;
+    items: InstagramMediaItem[];
+}
+
+/**
+ * Public profile of an Instagram user
+ */
+export interface InstagramProfile extends InstagramUser {
+    fbid?: string;
+    biography?: string;
+    followerCount: number;
+    followingCount: number;
+    postCount: number;
+    isBusiness?: boolean;
+}
+
+/**
+ * Search scope, also the type of each search result
+ */
+export type SearchScope = "users" | "groups" | "pages" | "communities" | "messages";
+
+/**
+ * Search options
+ */
+export interface SearchOptions {
+    query: string;
+    /** Default: users, groups and pages */
+    scopes?: SearchScope[];
+    /** Only return message matches in this thread, implies the messages scope */
+    threadId?: bigint;
+    limit?: number;
+}
+
+/**
+ * Search result. Message matches point to the thread they were found in.
+ */
+export interface SearchResult {
+    type: SearchScope;
+    id: string;
+    threadId?: bigint;
+    userId?: bigint;
+    threadType?: number;
+    name: string;
+    profilePictureUrl?: string;
+    contextLine?: string;
+    isVerified?: boolean;
+    canViewerMessage?: boolean;
+    communityId?: bigint;
+    igId?: string;
+    /** Set for message matches */
+    messageId?: string;
+    /** Set for message matches */
+    messageTimestampMs?: bigint;
+    /** Matching message text */
+    snippet?: string;
+}
+
+/**
+ * A chat inside a Facebook community
+ */
+export interface CommunityChat {
+    threadId: bigint;
+    communityId: bigint;
+    type: number;
+    name: string;
+    lastActivityTimestampMs: bigint;
+    snippet?: string;
+}
+
+/**
+ * A member of a community or community chat
+ */
+export interface CommunityMember {
+    userId: bigint;
+    communityId: bigint;
+    name: string;
+    firstName?: string;
+    nickname?: string;
+    profilePictureUrl?: string;
+    isAdmin?: boolean;
+    isModerator?: boolean;
+    isMuted?: boolean;
+    isBlocked?: boolean;
+}
+
+/**
+ * Options for listing the chats of a community. Pages go from the most recently active chat
+ * backwards, pass the next* values of the previous page as before* to continue.
+ */
+export interface ListCommunityChatsOptions {
+    communityId: bigint;
+    beforeThreadId?: bigint;
+    beforeActivityTimestampMs?: bigint;
+    additionalPagesToFetch?: number;
+}
+
+/**
+ * A range of community chats, most recently active first
+ */
+export interface CommunityChatsPage {
+    chats: CommunityChat[];
+    hasMore: boolean;
+    nextThreadId?: bigint;
+    nextActivityTimestampMs?: bigint;
+}
+
+/**
+ * Options for listing the members of a community
+ */
+export interface FetchCommunityMembersOptions {
+    communityId: bigint;
+    /** Only list members of this community chat */
+    threadId?: bigint;
+    /** nextCursor of the previous page */
+    cursor?: string;
+    query?: string;
+    adminsOnly?: boolean;
+}
+
+/**
+ * A range of community members
+ */
+export interface CommunityMembersPage {
+    members: CommunityMember[];
+    hasMore: boolean;
+    nextCursor?: string;
+}
+
+/**
+ * A single user's reaction on a message
+ */
+export interface ReactionDetail {
+    reactorId: bigint;
+    name: string;
+    profilePictureUrl?: string;
+    /** Empty if the emoji of the reaction FBID isn't known */
+    reaction?: string;
+    reactionFbid: bigint;
+    timestampMs: bigint;
+}
+
+/**
+ * Options for adding or removing one of several reactions on a message
+ */
+export interface SendReactionV2Options {
+    threadId: bigint;
+    messageId: string;
+    messageTimestampMs: bigint;
+    reaction: string;
+    /** Looked up from the reactions seen on the message when not set */
+    reactionFbid?: bigint;
+    /** reaction_style sent to Meta, 1 (default) for a normal tap */
+    style?: number;
+    remove?: boolean;
+}
+
+/**
+ * Thread folder, as named by Meta. "pending" is the message requests folder.
+ */
+export type ThreadFolder = "inbox" | "pending" | "other" | "spam" | "archived";
+
+/**
+ * Thread folder event data
+ */
+export interface ThreadFolderData {
+    threadId: bigint;
+    folder: ThreadFolder;
+}
+
+/**
+ * Thread unread event data
+ */
+export interface ThreadUnreadData {
+    threadId: bigint;
+    /** Messages after this timestamp are unread, not set if the whole thread is unread */
+    readWatermarkTimestampMs?: bigint;
+}
+
+/**
+ * Block status event data
+ */
+export interface BlockStatusData {
+    userId: bigint;
+    blocked: boolean;
+}
+
+/**
+ * Config of the resources and limits shared by all clients
+ */
+export interface ManagerConfig {
+    /** 0 = unlimited */
+    maxClients?: number;
+    /** Default 64 MiB, -1 disables the media cache */
+    mediaCacheBytes?: number;
+    /** Deliver the events of all clients through pollAllEvents or the event callback instead of each client */
+    multiplexEvents?: boolean;
+    /** Size of the multiplexed event queue, default 1000. Can't be changed while clients exist. */
+    eventBufferSize?: number;
+    /** Per-account rate limits for clients created without their own rateLimit */
+    rateLimit?: RateLimitConfig;
+    /** Enables metrics for all clients created afterwards */
+    metrics?: boolean;
+}
+
+/**
+ * A client owned by the manager
+ */
+export interface ClientInfo {
+    handle: number;
+    platform: string;
+    /** Set once connected */
+    userId?: bigint;
+    connected: boolean;
+    e2eeConnected: boolean;
+    createdAtMs: bigint;
+    /** Events waiting in the client's own queue */
+    queuedEvents: number;
+}
+
+/**
+ * Multiplexed event with the handle of the client it belongs to
+ */
+export type ManagedEvent = ClientEvent & { handle: number };
+
+/**
+ * Cumulative histogram. count includes the observations above the last bucket.
+ */
+export interface HistogramSnapshot {
+    count: bigint;
+    sum: number;
+    buckets: { le: number; count: bigint }[];
+}
+
+/**
+ * State of a client's metrics at one point in time
+ */
+export interface MetricsSnapshot {
+    handle: number;
+    userId?: bigint;
+    eventQueueDepth: number;
+    eventQueueCapacity: number;
+    eventsEmitted: bigint;
+    eventsDropped: bigint;
+    reconnects: bigint;
+    /** Failed sends, reactions and uploads, keyed by rate limit action */
+    sendFailures: Record<string, bigint>;
+    /** Seconds, keyed by task name */
+    taskLatency: Record<string, HistogramSnapshot>;
+    /** Keyed by task name */
+    taskErrors: Record<string, bigint>;
+    /** Bytes, keyed by media kind */
+    uploadSizes: Record<string, HistogramSnapshot>;
+}
+
+/**
+ * Options of the calls made inside Client.withCallOptions
+ */
+export interface CallOptions {
+    /** Fails the call with code "timeout" after this many ms */
+    timeoutMs?: number;
+    /** Lets cancelRequest cancel the call, only one running call can use a request ID */
+    requestId?: string;
+}
+
+/**
+ * Request completed event data
+ */
+export interface RequestCompletedData {
+    requestId: string;
+    /** Export that was called, e.g. "MxSendMessage" */
+    method: string;
+    /** What the synchronous call returns */
+    result?: unknown;
+}
+
+/**
+ * Request failed event data
+ */
+export interface RequestFailedData {
+    requestId: string;
+    method: string;
+    error: string;
+    /** Same codes as MessengerError, e.g. "timeout" or "cancelled" */
+    code?: string;
+    details?: unknown;
 }